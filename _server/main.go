@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -28,10 +30,26 @@ import (
 
 const DefaultRadius = 5.0 // km
 
+// stationSearchLimit caps the stations listed by a name or address search.
+const stationSearchLimit = 20
+
 func main() {
 	c := cache.New(30*time.Minute, 90*time.Minute)
 	port := flag.Int("port", 8080, "HTTP server port")
 	dbPath := flag.String("db", "fuel_prices.db", "Path to the database file")
+	cacheEntries := flag.Int("cache-entries", 1024, "Maximum number of cached nearby queries")
+	cacheSnapshots := flag.Int("cache-snapshots", 4, "Maximum number of cached parsed snapshots")
+	cacheTTL := flag.Duration("cache-ttl", 10*time.Minute, "How long cached snapshots and nearby queries are kept")
+	logSearches := flag.Bool("log-searches", false, "Record coarsened search locations in location_logs")
+	logPrecision := flag.Int("log-geohash-precision", 5, "Geohash length search locations are coarsened to")
+	logRetention := flag.Duration("log-retention", 90*24*time.Hour, "How long logged search locations are kept")
+	logMinCount := flag.Int("log-min-count", 5, "Minimum searches before a location is included in reports")
+	keepSnapshots := flag.Int("keep-snapshots", 30, "Days of raw snapshots to keep (0 keeps all)")
+	keepDailyPrices := flag.Int("keep-daily-prices", 0, "Days of daily prices to keep before downsampling to weekly averages (0 keeps all)")
+	keepWeeklyPrices := flag.Int("keep-weekly-prices", 0, "Days of weekly price averages to keep (0 keeps all)")
+	keepIntraday := flag.Int("keep-intraday", 30, "Days of intraday snapshots to keep (0 keeps all)")
+	adminUser := flag.String("admin-user", "admin", "Username for the /admin pages")
+	adminPassword := flag.String("admin-password", "", "Password for the /admin pages, which are disabled when empty")
 	flag.Parse()
 
 	ctx := context.Background()
@@ -44,7 +62,18 @@ func main() {
 	})
 
 	// Initialize storage
-	storage, err := gasdb.NewStorage(ctx, *dbPath, logger.Logger)
+	storage, err := gasdb.NewStorage(ctx, *dbPath, logger.Logger,
+		gasdb.WithCacheSize(*cacheEntries),
+		gasdb.WithSnapshotCacheSize(*cacheSnapshots),
+		gasdb.WithSnapshotTTL(*cacheTTL),
+		gasdb.WithQueryTTL(*cacheTTL),
+		gasdb.WithLocationLogging(gasdb.LocationLogPolicy{
+			Enabled:          *logSearches,
+			GeohashPrecision: *logPrecision,
+			Retention:        *logRetention,
+			MinCount:         *logMinCount,
+		}),
+	)
 	if err != nil {
 		log.Fatalf("Error initializing storage: %v", err)
 	}
@@ -63,11 +92,24 @@ func main() {
 			} else {
 				logger.Info("Price update completed successfully")
 			}
-			log.Println("Prices deleting old records")
-			if err := storage.DeleteOldRecords(ctx, 2); err != nil {
-				logger.Error("Error deleting old records", "error", err)
+			log.Println("Pruning old records")
+			retention := gasdb.RetentionPolicy{
+				Snapshots:    *keepSnapshots,
+				DailyPrices:  *keepDailyPrices,
+				WeeklyPrices: *keepWeeklyPrices,
+				Intraday:     *keepIntraday,
+			}
+			if report, err := storage.Prune(ctx, retention, false); err != nil {
+				logger.Error("Error pruning old records", "error", err)
 			} else {
-				logger.Info("Old records cleanup completed successfully")
+				logger.Info("Old records cleanup completed successfully",
+					"snapshots", report.Snapshots, "daily_prices", report.DailyPrices, "weekly_prices", report.WeeklyPrices,
+					"intraday_snapshots", report.IntradaySnapshots)
+			}
+			if deleted, err := storage.PurgeExpiredLocationLogs(ctx); err != nil {
+				logger.Error("Error purging expired location logs", "error", err)
+			} else if deleted > 0 {
+				logger.Info("Purged expired location logs", "deleted", deleted)
 			}
 			log.Println("Prices vacuuming database")
 			if err := storage.VacuumDatabase(ctx); err != nil {
@@ -80,6 +122,21 @@ func main() {
 		}
 	}()
 
+	// Webhook deliveries queued by the updates are sent apart from them, so an
+	// endpoint that is down does not hold back the updates and pruning
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if delivered, err := storage.DeliverWebhooks(ctx); err != nil {
+				logger.Error("Error delivering webhooks", "error", err)
+			} else if delivered > 0 {
+				logger.Info("Webhook deliveries sent", "delivered", delivered)
+			}
+		}
+	}()
+
 	// Create router
 	r := chi.NewRouter()
 	r.Use(middleware.RealIP)
@@ -113,10 +170,21 @@ func main() {
 		latStr := query.Get("lat")
 		lngStr := query.Get("lng")
 		radiusStr := query.Get("radius")
+		dateStr := query.Get("date")
 
 		var lat, lng, radius float64
+		var date time.Time
 		var err error
 
+		// An empty date searches the latest stored prices
+		if dateStr != "" {
+			date, err = time.Parse("2006-01-02", dateStr)
+			if err != nil {
+				http.Error(w, "Invalid date value, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+
 		// Set default radius if not provided or invalid
 		if radiusStr == "" {
 			radius = DefaultRadius
@@ -166,7 +234,16 @@ func main() {
 		}
 
 		// Find nearby stations
-		nearbyStations, err := storage.NearbyPrices(ctx, lat, lng, radius*1000)
+		var nearbyStations []*api.GasStation
+		if date.IsZero() {
+			nearbyStations, err = storage.NearbyPrices(ctx, lat, lng, radius*1000)
+		} else {
+			nearbyStations, err = storage.NearbyPricesAt(ctx, date, lat, lng, radius*1000)
+		}
+		if errors.Is(err, gasdb.ErrNoData) {
+			http.Error(w, "No prices stored for "+dateStr, http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error finding nearby stations: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -217,12 +294,228 @@ func main() {
 		templates.ResultsPage(stations, location, lat, lng, radius, nil, t).Render(r.Context(), w)
 	})
 
+	r.Get("/stations", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		t := translations.GetTranslations(translations.GetLanguageFromQuery(query.Get("lang")))
+
+		q := strings.TrimSpace(query.Get("q"))
+		if q == "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		matches, err := storage.SearchStations(r.Context(), q, stationSearchLimit)
+		if err != nil {
+			http.Error(w, "Error searching stations: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Show the current prices of the matches still listed
+		ids := make([]string, len(matches))
+		for i, match := range matches {
+			ids[i] = match.IDEESS
+		}
+		stations, err := storage.LatestStations(r.Context(), ids)
+		if err != nil && !errors.Is(err, gasdb.ErrNoData) {
+			http.Error(w, "Error getting prices: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		templates.StationSearchPage(stations, q, t).Render(r.Context(), w)
+	})
+
+	if *adminPassword != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.BasicAuth("gasdb admin", map[string]string{*adminUser: *adminPassword}))
+
+			r.Get("/heatmap", func(w http.ResponseWriter, r *http.Request) {
+				t := translations.GetTranslations(translations.GetLanguageFromQuery(r.URL.Query().Get("lang")))
+				templates.Heatmap(t).Render(r.Context(), w)
+			})
+
+			r.Get("/heatmap.json", func(w http.ResponseWriter, r *http.Request) {
+				query, err := parseHeatmapQuery(r.URL.Query())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				points, err := storage.Heatmap(r.Context(), query)
+				if err != nil {
+					http.Error(w, "Error building heatmap: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(points); err != nil {
+					logger.Error("Error encoding heatmap", "error", err)
+				}
+			})
+
+			r.Get("/watch", func(w http.ResponseWriter, r *http.Request) {
+				rules, err := storage.WatchRules(r.Context())
+				if err != nil {
+					http.Error(w, "Error listing watch rules: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				body := make([]watchRuleJSON, len(rules))
+				for i, rule := range rules {
+					body[i] = watchRuleJSON{WatchRule: rule, Cooldown: rule.Cooldown.String()}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(body); err != nil {
+					logger.Error("Error encoding watch rules", "error", err)
+				}
+			})
+
+			r.Post("/watch", func(w http.ResponseWriter, r *http.Request) {
+				var body watchRuleJSON
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "Invalid watch rule: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				rule := body.WatchRule
+				if body.Cooldown != "" {
+					cooldown, err := time.ParseDuration(body.Cooldown)
+					if err != nil {
+						http.Error(w, "Invalid watch rule: invalid cooldown, expected a duration such as 24h", http.StatusBadRequest)
+						return
+					}
+					rule.Cooldown = cooldown
+				}
+
+				id, err := storage.AddWatchRule(r.Context(), rule)
+				if err != nil {
+					http.Error(w, "Invalid watch rule: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				if err := json.NewEncoder(w).Encode(map[string]int64{"id": id}); err != nil {
+					logger.Error("Error encoding watch rule id", "error", err)
+				}
+			})
+
+			r.Delete("/watch/{id}", func(w http.ResponseWriter, r *http.Request) {
+				id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+				if err != nil {
+					http.Error(w, "Invalid watch rule id", http.StatusBadRequest)
+					return
+				}
+
+				if err := storage.DeleteWatchRule(r.Context(), id); errors.Is(err, gasdb.ErrWatchRuleNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				} else if err != nil {
+					http.Error(w, "Error deleting watch rule: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			r.Get("/alerts", func(w http.ResponseWriter, r *http.Request) {
+				query, err := parseAlertQuery(r.URL.Query())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				alerts, err := storage.Alerts(r.Context(), query)
+				if err != nil {
+					http.Error(w, "Error listing alerts: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(alerts); err != nil {
+					logger.Error("Error encoding alerts", "error", err)
+				}
+			})
+		})
+	}
+
 	// Start server
 	addr := fmt.Sprintf("127.0.0.1:%d", *port)
 	logger.Debug("Starting server on", "addr", addr)
 	log.Fatal(http.ListenAndServe(addr, r))
 }
 
+// parseHeatmapQuery reads the zoom, bbox (minLng,minLat,maxLng,maxLat) and
+// days parameters of a heatmap request.
+func parseHeatmapQuery(values url.Values) (gasdb.HeatmapQuery, error) {
+	var query gasdb.HeatmapQuery
+
+	if zoom := values.Get("zoom"); zoom != "" {
+		z, err := strconv.Atoi(zoom)
+		if err != nil {
+			return query, errors.New("invalid zoom value")
+		}
+		query.Zoom = z
+	}
+
+	if bbox := values.Get("bbox"); bbox != "" {
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			return query, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		coords := make([]float64, len(parts))
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return query, errors.New("invalid bbox value")
+			}
+			coords[i] = v
+		}
+		query.BBox = &gasdb.BBox{MinLng: coords[0], MinLat: coords[1], MaxLng: coords[2], MaxLat: coords[3]}
+	}
+
+	if days := values.Get("days"); days != "" {
+		d, err := strconv.Atoi(days)
+		if err != nil || d < 0 {
+			return query, errors.New("invalid days value")
+		}
+		query.Window = time.Duration(d) * 24 * time.Hour
+	}
+
+	return query, nil
+}
+
+// watchRuleJSON is a watch rule as exchanged by the /admin/watch routes,
+// with its cooldown as a duration string such as "24h".
+type watchRuleJSON struct {
+	gasdb.WatchRule
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// parseAlertQuery reads the rule, from and to (YYYY-MM-DD) parameters of an
+// alerts request.
+func parseAlertQuery(values url.Values) (gasdb.AlertQuery, error) {
+	var query gasdb.AlertQuery
+
+	if rule := values.Get("rule"); rule != "" {
+		id, err := strconv.ParseInt(rule, 10, 64)
+		if err != nil {
+			return query, errors.New("invalid rule value")
+		}
+		query.RuleID = id
+	}
+
+	for name, dest := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := values.Get(name); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s value, expected YYYY-MM-DD", name)
+			}
+			*dest = date
+		}
+	}
+
+	return query, nil
+}
+
 func gominatimResultToLatLon(result gominatim.SearchResult) (lat, lng float64, err error) {
 	lat, err = strconv.ParseFloat(result.Lat, 64)
 	if err != nil {
@@ -8,14 +8,16 @@ func GetEnglishTranslations() Translations {
 		ResultsTitle: "Fuel Station Results",
 
 		// Home page
-		HomeHeading:             "🔎 Nearby Fuel Stations",
-		LastUpdated:             "📅 Fuel prices last updated:",
-		LocationLabel:           "Location",
-		LocationPlaceholder:     "City, province (Spain only)",
-		LocationExample:         "Example: Tibidabo, Barcelona",
-		SearchButton:            "Search",
-		UseLocationButton:       "Use My Location",
-		GeolocationNotSupported: "Geolocation not supported",
+		HomeHeading:              "🔎 Nearby Fuel Stations",
+		LastUpdated:              "📅 Fuel prices last updated:",
+		LocationLabel:            "Location",
+		LocationPlaceholder:      "City, province (Spain only)",
+		LocationExample:          "Example: Tibidabo, Barcelona",
+		SearchButton:             "Search",
+		UseLocationButton:        "Use My Location",
+		GeolocationNotSupported:  "Geolocation not supported",
+		StationSearchLabel:       "Station name or address",
+		StationSearchPlaceholder: "Example: Repsol Avenida de América",
 
 		// Geolocation messages
 		RequestingLocation:  "Requesting your location...",
@@ -37,6 +39,10 @@ func GetEnglishTranslations() Translations {
 		StationsWithin:   "stations within",
 		OfYourLocation:   "of your location.",
 
+		// Station search results page
+		StationSearchHeading: "Matching Fuel Stations",
+		NoStationsMatch:      "No open fuel stations match",
+
 		// Station card
 		MapButton:        "🗺️ OSM",
 		GoogleMapsButton: "📍 Google Maps",
@@ -49,6 +55,13 @@ func GetEnglishTranslations() Translations {
 		PremiumDiesel:    "Premium Diesel:",
 		NotAvailable:     "N/A",
 
+		// Admin heatmap page
+		HeatmapTitle:       "Search Heatmap",
+		HeatmapHeading:     "🔥 Search Heatmap",
+		HeatmapDescription: "Logged searches clustered for the current zoom level. Areas with too few searches are hidden.",
+		HeatmapWindowLabel: "Period",
+		HeatmapAllTime:     "All time",
+
 		// Footer
 		FooterCopyright: "Fuel Station Finder Spain",
 	}
@@ -8,14 +8,16 @@ func GetSpanishTranslations() Translations {
 		ResultsTitle: "Resultados de Gasolineras",
 
 		// Home page
-		HomeHeading:             "🔎 Gasolineras Cercanas",
-		LastUpdated:             "📅 Precios actualizados el:",
-		LocationLabel:           "Ubicación",
-		LocationPlaceholder:     "Ciudad, provincia (solo España)",
-		LocationExample:         "Ejemplo: Tibidabo, Barcelona",
-		SearchButton:            "Buscar",
-		UseLocationButton:       "Usar Mi Ubicación",
-		GeolocationNotSupported: "Geolocalización no soportada",
+		HomeHeading:              "🔎 Gasolineras Cercanas",
+		LastUpdated:              "📅 Precios actualizados el:",
+		LocationLabel:            "Ubicación",
+		LocationPlaceholder:      "Ciudad, provincia (solo España)",
+		LocationExample:          "Ejemplo: Tibidabo, Barcelona",
+		SearchButton:             "Buscar",
+		UseLocationButton:        "Usar Mi Ubicación",
+		GeolocationNotSupported:  "Geolocalización no soportada",
+		StationSearchLabel:       "Nombre o dirección de la gasolinera",
+		StationSearchPlaceholder: "Ejemplo: Repsol Avenida de América",
 
 		// Geolocation messages
 		RequestingLocation:  "Obteniendo tu ubicación...",
@@ -37,6 +39,10 @@ func GetSpanishTranslations() Translations {
 		StationsWithin:   "estaciones en un radio de",
 		OfYourLocation:   "de tu ubicación.",
 
+		// Station search results page
+		StationSearchHeading: "Gasolineras Encontradas",
+		NoStationsMatch:      "Ninguna gasolinera abierta coincide con",
+
 		// Station card
 		MapButton:        "🗺️ OSM",
 		GoogleMapsButton: "📍 Google Maps",
@@ -49,6 +55,13 @@ func GetSpanishTranslations() Translations {
 		PremiumDiesel:    "Diésel Premium:",
 		NotAvailable:     "N/D",
 
+		// Admin heatmap page
+		HeatmapTitle:       "Mapa de Búsquedas",
+		HeatmapHeading:     "🔥 Mapa de Búsquedas",
+		HeatmapDescription: "Búsquedas registradas agrupadas según el nivel de zoom. Las zonas con pocas búsquedas se ocultan.",
+		HeatmapWindowLabel: "Periodo",
+		HeatmapAllTime:     "Todo",
+
 		// Footer
 		FooterCopyright: "Buscador de Gasolineras España",
 	}
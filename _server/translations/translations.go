@@ -7,14 +7,16 @@ type Translations struct {
 	ResultsTitle string
 
 	// Home page
-	HomeHeading             string
-	LastUpdated             string
-	LocationLabel           string
-	LocationPlaceholder     string
-	LocationExample         string
-	SearchButton            string
-	UseLocationButton       string
-	GeolocationNotSupported string
+	HomeHeading              string
+	LastUpdated              string
+	LocationLabel            string
+	LocationPlaceholder      string
+	LocationExample          string
+	SearchButton             string
+	UseLocationButton        string
+	GeolocationNotSupported  string
+	StationSearchLabel       string
+	StationSearchPlaceholder string
 
 	// Geolocation messages
 	RequestingLocation  string
@@ -36,6 +38,10 @@ type Translations struct {
 	StationsWithin   string
 	OfYourLocation   string
 
+	// Station search results page
+	StationSearchHeading string
+	NoStationsMatch      string
+
 	// Station card
 	MapButton        string
 	GoogleMapsButton string
@@ -48,6 +54,13 @@ type Translations struct {
 	PremiumDiesel    string
 	NotAvailable     string
 
+	// Admin heatmap page
+	HeatmapTitle       string
+	HeatmapHeading     string
+	HeatmapDescription string
+	HeatmapWindowLabel string
+	HeatmapAllTime     string
+
 	// Footer
 	FooterCopyright string
 }
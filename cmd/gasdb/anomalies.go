@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func anomaliesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "anomalies",
+		Usage: "Review the prices and stations quarantined as anomalies",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Start date (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End date (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "station",
+				Usage: "Station IDEESS",
+			},
+			&cli.StringFlag{
+				Name:  "reason",
+				Usage: "Only list one reason: implausible_price, regional_outlier, price_jump or coordinates_jump",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Include the anomalies already dismissed",
+			},
+			&cli.StringFlag{
+				Name:  "dismiss",
+				Usage: "Dismiss the anomalies of --station on this date (YYYY-MM-DD) as false positives",
+			},
+			&cli.StringFlag{
+				Name:  "fuel",
+				Usage: "Fuel dismissed with --dismiss, every fuel by default",
+			},
+		},
+		Action: anomaliesAction,
+	}
+}
+
+func anomaliesAction(c *cli.Context) error {
+	from, err := parseOptionalDate(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := parseOptionalDate(c.String("to"))
+	if err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if c.String("dismiss") != "" {
+		return dismissAnomalies(ctx, c, storage)
+	}
+
+	anomalies, err := storage.Anomalies(ctx, gasdb.AnomalyQuery{
+		From:      from,
+		To:        to,
+		IDEESS:    c.String("station"),
+		Reason:    gasdb.AnomalyReason(c.String("reason")),
+		Dismissed: c.Bool("all"),
+	})
+	if err != nil {
+		return err
+	}
+	if len(anomalies) == 0 {
+		fmt.Println("No anomalies found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tSTATION\tFUEL\tREASON\tDETAIL\t")
+	for _, a := range anomalies {
+		fuel := string(a.Fuel)
+		if fuel == "" {
+			fuel = "all"
+		}
+		reason := string(a.Reason)
+		if a.Dismissed {
+			reason += " (dismissed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", a.Date, a.IDEESS, fuel, reason, a.Detail)
+	}
+	return w.Flush()
+}
+
+func dismissAnomalies(ctx context.Context, c *cli.Context, storage *gasdb.Storage) error {
+	if c.String("station") == "" {
+		return errors.New("--dismiss requires --station")
+	}
+	date, err := parseOptionalDate(c.String("dismiss"))
+	if err != nil {
+		return fmt.Errorf("invalid dismiss date: %w", err)
+	}
+	var fuel gasdb.Fuel
+	if c.String("fuel") != "" {
+		if fuel, err = gasdb.ParseFuel(c.String("fuel")); err != nil {
+			return err
+		}
+	}
+
+	dismissed, err := storage.DismissAnomalies(ctx, date, c.String("station"), fuel)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dismissed %d anomalies.\n", dismissed)
+	return nil
+}
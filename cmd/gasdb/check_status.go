@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/rubiojr/gasdb/internal/gasdb"
@@ -31,13 +33,24 @@ func checkStatusCommand() *cli.Command {
 				Usage:    "End date (YYYY-MM-DD)",
 				Required: false,
 			},
+			&cli.BoolFlag{
+				Name:  "fix",
+				Usage: "Fetch the missing days",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Days fetched in parallel with --fix",
+				Value: 4,
+			},
 		},
 		Action: checkStatusAction,
 	}
 }
 
 func checkStatusAction(c *cli.Context) error {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	dbPath := c.String("db")
 	storage, err := gasdb.NewStorage(ctx, dbPath, slog.New(slog.DiscardHandler))
 	if err != nil {
@@ -49,51 +62,70 @@ func checkStatusAction(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if len(allDates) == 0 {
+	if len(allDates) == 0 && !c.Bool("fix") {
 		fmt.Println("No dates found in database.")
 		return nil
 	}
 
-	var startDate, endDate time.Time
-	if c.String("start") != "" {
-		startDate, err = time.Parse("2006-01-02", c.String("start"))
-		if err != nil {
-			return fmt.Errorf("invalid start date: %w", err)
-		}
-	} else {
-		startDate = time.Date(2007, 1, 1, 0, 0, 0, 0, time.UTC)
+	startDate, err := parseOptionalDate(c.String("start"))
+	if err != nil {
+		return fmt.Errorf("invalid start date: %w", err)
 	}
-	if c.String("end") != "" {
-		endDate, err = time.Parse("2006-01-02", c.String("end"))
-		if err != nil {
-			return fmt.Errorf("invalid end date: %w", err)
-		}
-	} else {
-		endDate = time.Now()
+	endDate, err := parseOptionalDate(c.String("end"))
+	if err != nil {
+		return fmt.Errorf("invalid end date: %w", err)
 	}
 
-	fmt.Printf("Checking for missing days in range: %s to %s\n", startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
-
-	dateSet := make(map[string]struct{}, len(allDates))
-	for _, d := range allDates {
-		dateSet[d.Format("2006-01-02")] = struct{}{}
+	missing, err := storage.MissingDates(ctx, startDate, endDate)
+	if err != nil {
+		return err
 	}
 
-	var missing []string
-	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
-		ds := d.Format("2006-01-02")
-		if _, ok := dateSet[ds]; !ok {
-			missing = append(missing, ds)
-		}
+	if startDate.IsZero() {
+		startDate = time.Date(2007, 1, 1, 0, 0, 0, 0, time.UTC)
 	}
+	if endDate.IsZero() {
+		endDate = gasdb.Today()
+	}
+	fmt.Printf("Checking for missing days in range: %s to %s\n", startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
 
 	if len(missing) == 0 {
 		fmt.Println("No missing days in the given range.")
-	} else {
-		fmt.Println("Missing days:")
-		for _, m := range missing {
-			fmt.Println(m)
-		}
+		return nil
+	}
+
+	fmt.Println("Missing days:")
+	for _, m := range missing {
+		fmt.Println(m.Format("2006-01-02"))
+	}
+
+	if !c.Bool("fix") {
+		return nil
+	}
+
+	// Past days come from the historic endpoint, today's prices from the live
+	// one, as in update
+	today := gasdb.Today()
+	backfillTo := endDate
+	if !backfillTo.Before(today) {
+		backfillTo = today.AddDate(0, 0, -1)
+	}
+	report, err := storage.Backfill(ctx, gasdb.BackfillOptions{
+		From:        startDate,
+		To:          backfillTo,
+		OnlyMissing: true,
+		Concurrency: c.Int("concurrency"),
+		Progress:    printBackfillProgress,
+	})
+	if report != nil {
+		printBackfillReport(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if missing[len(missing)-1].Before(today) {
+		return nil
 	}
-	return nil
+	return storage.UpdateDB(ctx)
 }
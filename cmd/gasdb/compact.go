@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+const bytesPerMB = 1024 * 1024
+
+func compactCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "compact",
+		Usage: "Compress raw snapshots stored uncompressed in fuel_prices",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.BoolFlag{
+				Name:  "vacuum",
+				Usage: "Run VACUUM afterwards to return the freed space to the filesystem",
+			},
+		},
+		Action: compactAction,
+	}
+}
+
+func compactAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	stats, err := storage.CompactSnapshots(ctx)
+	if stats != nil && stats.Rows > 0 {
+		fmt.Printf("Compressed %d snapshots: %.1f MB -> %.1f MB\n",
+			stats.Rows, float64(stats.BytesBefore)/bytesPerMB, float64(stats.BytesAfter)/bytesPerMB)
+	}
+	if err != nil {
+		return err
+	}
+	if stats.Rows == 0 {
+		fmt.Println("All snapshots are already compressed.")
+	}
+
+	if c.Bool("vacuum") {
+		fmt.Println("Vacuuming database...")
+		return storage.Vacuum(ctx)
+	}
+	return nil
+}
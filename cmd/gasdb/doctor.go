@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check the database for corruption and prices that do not match their snapshots",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.BoolFlag{
+				Name:  "repair",
+				Usage: "Rebuild the station days, prices and aggregates of the days that do not match their snapshots",
+			},
+		},
+		Action: doctorAction,
+	}
+}
+
+func doctorAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	repair := c.Bool("repair")
+	report, err := storage.Doctor(ctx, repair)
+	if report != nil {
+		printDoctorReport(report, repair)
+	}
+	if err != nil {
+		return err
+	}
+
+	remaining := 0
+	for _, p := range report.Problems {
+		if !repair || !p.Repairable {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d problems found", remaining)
+	}
+	return nil
+}
+
+func printDoctorReport(report *gasdb.DoctorReport, repair bool) {
+	fmt.Printf("Checked %d snapshots.\n", report.Snapshots)
+	if len(report.Problems) == 0 {
+		fmt.Println("No problems found.")
+		return
+	}
+
+	repairable := 0
+	for _, p := range report.Problems {
+		where := p.Date
+		if p.IDEESS != "" {
+			where += " station " + p.IDEESS
+		}
+		if where != "" {
+			where += ": "
+		}
+		fmt.Printf("%-18s %s%s\n", p.Kind, where, p.Detail)
+		if p.Repairable {
+			repairable++
+		}
+	}
+
+	if len(report.Repaired) > 0 {
+		fmt.Printf("Rebuilt the station days, prices and aggregates of %d days; anomalies and station events were left as they were.\n", len(report.Repaired))
+	}
+	if !repair && repairable > 0 {
+		fmt.Printf("%d problems can be fixed with --repair.\n", repairable)
+	}
+}
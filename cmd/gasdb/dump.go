@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func dumpCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "dump",
+		Usage:     "Export daily prices to CSV or Parquet files partitioned by fuel and month",
+		ArgsUsage: "<directory>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: csv or parquet",
+				Value: string(gasdb.DumpCSV),
+			},
+			&cli.StringSliceFlag{
+				Name:  "station",
+				Usage: "Only export these station ids (IDEESS), repeatable",
+			},
+			&cli.StringSliceFlag{
+				Name:  "fuel",
+				Usage: "Only export these fuels, e.g. gasoleo_a or diesel, repeatable",
+			},
+			&cli.StringSliceFlag{
+				Name:  "ccaa",
+				Usage: "Only export stations in these autonomous community ids, repeatable",
+			},
+			&cli.StringSliceFlag{
+				Name:  "province",
+				Usage: "Only export stations in these province ids, repeatable",
+			},
+			&cli.StringSliceFlag{
+				Name:  "municipality",
+				Usage: "Only export stations in these municipality ids, repeatable",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Start date (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End date (YYYY-MM-DD)",
+			},
+		},
+		Action: dumpAction,
+	}
+}
+
+func dumpAction(c *cli.Context) error {
+	dir := c.Args().First()
+	if dir == "" {
+		return errors.New("missing output directory")
+	}
+	format, err := gasdb.ParseDumpFormat(c.String("format"))
+	if err != nil {
+		return err
+	}
+
+	query := gasdb.DumpQuery{
+		Stations:       c.StringSlice("station"),
+		CCAA:           c.StringSlice("ccaa"),
+		Provinces:      c.StringSlice("province"),
+		Municipalities: c.StringSlice("municipality"),
+	}
+	for _, name := range c.StringSlice("fuel") {
+		fuel, err := gasdb.ParseFuel(name)
+		if err != nil {
+			return err
+		}
+		query.Fuels = append(query.Fuels, fuel)
+	}
+	if query.From, err = parseOptionalDate(c.String("from")); err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	if query.To, err = parseOptionalDate(c.String("to")); err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	manifest, err := storage.Dump(ctx, dir, format, query)
+	if err != nil {
+		return err
+	}
+
+	var rows int64
+	for _, f := range manifest.Files {
+		rows += f.Rows
+	}
+	fmt.Printf("Wrote %d prices to %d files in %s\n", rows, len(manifest.Files), dir)
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+const defaultSparklineWidth = 80
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+func historyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "Show the price history of a station",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.StringFlag{
+				Name:     "station",
+				Usage:    "Station IDEESS",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "fuel",
+				Usage: "Fuel type (e.g. diesel, gasolina95, gasoleo_premium)",
+				Value: "diesel",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Start date (YYYY-MM-DD), defaults to the first stored price",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End date (YYYY-MM-DD), defaults to the last stored price",
+			},
+			&cli.BoolFlag{
+				Name:  "csv",
+				Usage: "Print the series as CSV instead of a chart",
+			},
+			&cli.BoolFlag{
+				Name:  "changes",
+				Usage: "Only print the days where the price changed",
+			},
+			&cli.BoolFlag{
+				Name:  "intraday",
+				Usage: "Print every price change published during the day instead of daily prices",
+			},
+			&cli.IntFlag{
+				Name:  "width",
+				Usage: "Chart width in characters",
+				Value: defaultSparklineWidth,
+			},
+		},
+		Action: historyAction,
+	}
+}
+
+func historyAction(c *cli.Context) error {
+	ctx := context.Background()
+
+	fuel, err := gasdb.ParseFuel(c.String("fuel"))
+	if err != nil {
+		return err
+	}
+	from, err := parseOptionalDate(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := parseOptionalDate(c.String("to"))
+	if err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if c.Bool("intraday") {
+		points, err := storage.IntradayHistory(ctx, c.String("station"), fuel, from, to)
+		if err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			return errors.New("no intraday prices found for the station and fuel")
+		}
+		return printIntradayHistory(points, c.Bool("csv"))
+	}
+
+	series, err := storage.StationHistory(ctx, c.String("station"), fuel, from, to)
+	if err != nil {
+		return err
+	}
+	if len(series.Available()) == 0 {
+		return errors.New("no prices found for the station and fuel")
+	}
+
+	points := series.Points
+	if c.Bool("changes") {
+		points = series.ChangePoints()
+	}
+
+	if c.Bool("csv") {
+		return writeHistoryCSV(points)
+	}
+
+	fmt.Printf("Station %s, %s, %s to %s\n\n", series.IDEESS, series.Fuel,
+		series.From.Format("2006-01-02"), series.To.Format("2006-01-02"))
+	if c.Bool("changes") {
+		for _, p := range points {
+			fmt.Printf("%s  %s\n", p.Date.Format("2006-01-02"), formatPoint(p))
+		}
+		return nil
+	}
+
+	available := series.Available()
+	minPrice, maxPrice := math.Inf(1), math.Inf(-1)
+	for _, p := range available {
+		minPrice = math.Min(minPrice, p.Price)
+		maxPrice = math.Max(maxPrice, p.Price)
+	}
+	fmt.Println(sparkline(points, c.Int("width"), minPrice, maxPrice))
+	fmt.Printf("\nmin %.3f €  max %.3f €  last %.3f € (%s)  missing days %d\n",
+		minPrice, maxPrice, available[len(available)-1].Price,
+		available[len(available)-1].Date.Format("2006-01-02"), len(series.Points)-len(available))
+	return nil
+}
+
+func parseOptionalDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func formatPoint(p gasdb.PricePoint) string {
+	if p.Missing {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f €", p.Price)
+}
+
+// printIntradayHistory prints the fetch and publication times of every
+// intraday price change, in Madrid time.
+func printIntradayHistory(points []gasdb.IntradayPoint, asCSV bool) error {
+	w := csv.NewWriter(os.Stdout)
+	if asCSV {
+		if err := w.Write([]string{"fetched_at", "published_at", "price"}); err != nil {
+			return err
+		}
+	}
+	for _, p := range points {
+		published := ""
+		if !p.PublishedAt.IsZero() {
+			published = p.PublishedAt.In(gasdb.BusinessLocation).Format(time.DateTime)
+		}
+		fetched := p.FetchedAt.In(gasdb.BusinessLocation).Format(time.DateTime)
+		if !asCSV {
+			fmt.Printf("%s  %-19s  %.3f €\n", fetched, published, p.Price)
+			continue
+		}
+		if err := w.Write([]string{fetched, published, strconv.FormatFloat(p.Price, 'f', 3, 64)}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeHistoryCSV(points []gasdb.PricePoint) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write([]string{"date", "price"}); err != nil {
+		return err
+	}
+	for _, p := range points {
+		price := ""
+		if !p.Missing {
+			price = strconv.FormatFloat(p.Price, 'f', 3, 64)
+		}
+		if err := w.Write([]string{p.Date.Format("2006-01-02"), price}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// sparkline renders points as a single line of block characters. Long series
+// are averaged into width buckets; buckets without prices are left blank.
+func sparkline(points []gasdb.PricePoint, width int, minPrice, maxPrice float64) string {
+	if width <= 0 || width > len(points) {
+		width = len(points)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < width; i++ {
+		start := i * len(points) / width
+		end := (i + 1) * len(points) / width
+
+		sum, n := 0.0, 0
+		for _, p := range points[start:end] {
+			if !p.Missing {
+				sum += p.Price
+				n++
+			}
+		}
+		if n == 0 {
+			sb.WriteRune(' ')
+			continue
+		}
+
+		level := 0
+		if maxPrice > minPrice {
+			level = int((sum/float64(n) - minPrice) / (maxPrice - minPrice) * float64(len(sparkTicks)-1))
+		}
+		sb.WriteRune(sparkTicks[level])
+	}
+	return sb.String()
+}
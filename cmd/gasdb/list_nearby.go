@@ -11,6 +11,7 @@ import (
 
 	"github.com/muesli/gominatim"
 	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/rubiojr/gasdb/pkg/api"
 	"github.com/tkrajina/gpxgo/gpx"
 	"github.com/urfave/cli/v2"
 )
@@ -52,9 +53,8 @@ func listNearbyCommand() *cli.Command {
 			},
 			&cli.StringFlag{
 				Name:     "date",
-				Usage:    "Date to search",
+				Usage:    "Date to search (YYYY-MM-DD), defaults to the latest stored prices",
 				Required: false,
-				Value:    time.Now().Format("2006-01-02"),
 			},
 		},
 		Action: listNearbyAction,
@@ -67,18 +67,27 @@ func listNearbyAction(c *cli.Context) error {
 	radius := c.Float64("radius")
 	loc := c.String("location")
 
+	var date time.Time
+	if c.String("date") != "" {
+		var err error
+		date, err = time.Parse("2006-01-02", c.String("date"))
+		if err != nil {
+			return fmt.Errorf("invalid date: %w", err)
+		}
+	}
+
 	if loc != "" {
-		return listNearbyByName(c.String("db"), loc, radius)
+		return listNearbyByName(c.String("db"), loc, radius, date)
 	}
 
 	if lat == 0 && lng == 0 {
 		return errors.New("location or latitude and longitude are required")
 	}
 
-	return listNearbyStations(c.String("db"), lat, lng, radius)
+	return listNearbyStations(c.String("db"), lat, lng, radius, date)
 }
 
-func listNearbyByName(dbPath, name string, distanceKm float64) error {
+func listNearbyByName(dbPath, name string, distanceKm float64, date time.Time) error {
 	gominatim.SetServer("https://nominatim.openstreetmap.org/")
 	qry := gominatim.SearchQuery{
 		Q: name,
@@ -98,10 +107,11 @@ func listNearbyByName(dbPath, name string, distanceKm float64) error {
 	if err2 != nil {
 		return err2
 	}
-	return listNearbyStations(dbPath, lat, lon, distanceKm)
+	return listNearbyStations(dbPath, lat, lon, distanceKm, date)
 }
 
-func listNearbyStations(dbPath string, lat, lng, radius float64) error {
+// listNearbyStations prints the stations within radius km. A zero date uses the latest stored prices.
+func listNearbyStations(dbPath string, lat, lng, radius float64, date time.Time) error {
 	ctx := context.Background()
 	storage, err := gasdb.NewStorage(ctx, dbPath, slog.New(slog.DiscardHandler))
 	if err != nil {
@@ -111,7 +121,13 @@ func listNearbyStations(dbPath string, lat, lng, radius float64) error {
 
 	fmt.Println("Filtering stations within\n", radius, "km radius...")
 
-	nearbyStations, err := storage.NearbyPrices(ctx, lat, lng, radius*metersPerKm)
+	var nearbyStations []*api.GasStation
+	if date.IsZero() {
+		nearbyStations, err = storage.NearbyPrices(ctx, lat, lng, radius*metersPerKm)
+	} else {
+		fmt.Println("Using prices from", date.Format("2006-01-02"))
+		nearbyStations, err = storage.NearbyPricesAt(ctx, date, lat, lng, radius*metersPerKm)
+	}
 	if err != nil {
 		return fmt.Errorf("error fetching nearby stations: %w", err)
 	}
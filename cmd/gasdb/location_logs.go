@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func locationLogsCommand() *cli.Command {
+	dbFlag := &cli.StringFlag{
+		Name:     "db",
+		Usage:    "Database file",
+		Required: false,
+		Value:    "fuel_prices.db",
+	}
+
+	return &cli.Command{
+		Name:  "location-logs",
+		Usage: "Export or erase the logged search locations",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "export",
+				Usage: "Export every logged search cell",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format: csv or json",
+						Value: "csv",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Output file, stdout when empty",
+					},
+				},
+				Action: exportLocationLogsAction,
+			},
+			{
+				Name:  "erase",
+				Usage: "Erase logged search cells",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.StringFlag{
+						Name:  "before",
+						Usage: "Only erase cells last searched before this date (YYYY-MM-DD)",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Erase every cell",
+					},
+				},
+				Action: eraseLocationLogsAction,
+			},
+		},
+	}
+}
+
+func exportLocationLogsAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	logs, err := storage.ExportLocationLogs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path := c.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(logs)
+	case "csv":
+		return writeLocationLogsCSV(out, logs)
+	default:
+		return fmt.Errorf("unknown format %q", c.String("format"))
+	}
+}
+
+func writeLocationLogsCSV(out io.Writer, logs []gasdb.LocationLog) error {
+	w := csv.NewWriter(out)
+	header := []string{"geohash", "latitude", "longitude", "distance", "search_count", "search_time", "last_search"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, l := range logs {
+		record := []string{
+			l.Geohash,
+			strconv.FormatFloat(l.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(l.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(l.Distance, 'f', -1, 64),
+			strconv.FormatInt(l.SearchCount, 10),
+			l.SearchTime.Format(time.RFC3339),
+			l.LastSearch.Format(time.RFC3339),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func eraseLocationLogsAction(c *cli.Context) error {
+	before, err := parseOptionalDate(c.String("before"))
+	if err != nil {
+		return fmt.Errorf("invalid before date: %w", err)
+	}
+	if before.IsZero() && !c.Bool("all") {
+		return errors.New("pass --before to erase old cells or --all to erase every cell")
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	deleted, err := storage.EraseLocationLogs(ctx, before)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Erased %d location log cells.\n", deleted)
+	return nil
+}
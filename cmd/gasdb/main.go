@@ -16,6 +16,21 @@ func main() {
 			migrateCommand(),
 			listNearbyCommand(),
 			checkStatusCommand(),
+			compactCommand(),
+			historyCommand(),
+			statsCommand(),
+			locationLogsCommand(),
+			pruneCommand(),
+			backupCommand(),
+			restoreCommand(),
+			doctorCommand(),
+			searchCommand(),
+			dumpCommand(),
+			anomaliesCommand(),
+			qualityCommand(),
+			stationCommand(),
+			watchCommand(),
+			webhookCommand(),
 		},
 	}
 
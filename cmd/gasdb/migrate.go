@@ -11,7 +11,7 @@ import (
 func migrateCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "migrate",
-		Usage: "Migrate the fuel price database",
+		Usage: "Migrate the fuel price database to the normalized stations and prices layout",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:     "db",
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func pruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Apply retention policies, downsampling old daily prices to weekly averages",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.IntFlag{
+				Name:  "snapshots",
+				Usage: "Days of raw snapshots to keep (0 keeps all)",
+			},
+			&cli.IntFlag{
+				Name:  "daily-prices",
+				Usage: "Days of per-station daily prices to keep before downsampling (0 keeps all)",
+			},
+			&cli.IntFlag{
+				Name:  "weekly-prices",
+				Usage: "Days of weekly price averages to keep (0 keeps all)",
+			},
+			&cli.IntFlag{
+				Name:  "intraday",
+				Usage: "Days of intraday snapshots to keep (0 keeps all)",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "Rows deleted per transaction",
+				Value: 500,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report what would be pruned",
+			},
+		},
+		Action: pruneAction,
+	}
+}
+
+func pruneAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	policy := gasdb.RetentionPolicy{
+		Snapshots:    c.Int("snapshots"),
+		DailyPrices:  c.Int("daily-prices"),
+		WeeklyPrices: c.Int("weekly-prices"),
+		Intraday:     c.Int("intraday"),
+		BatchSize:    c.Int("batch-size"),
+	}
+	report, err := storage.Prune(ctx, policy, c.Bool("dry-run"))
+	if report != nil {
+		printPruneReport(report)
+	}
+	return err
+}
+
+func printPruneReport(report *gasdb.PruneReport) {
+	verb := "Deleted"
+	if report.DryRun {
+		verb = "Would delete"
+	}
+
+	if report.SnapshotsCutoff != "" {
+		fmt.Printf("%s %d snapshots before %s\n", verb, report.Snapshots, report.SnapshotsCutoff)
+	}
+	if report.DailyPricesCutoff != "" {
+		fmt.Printf("%s %d prices and %d station days from %d days before %s, downsampled to weekly averages\n",
+			verb, report.DailyPrices, report.StationDays, report.DailyDays, report.DailyPricesCutoff)
+	}
+	if report.WeeklyPricesCutoff != "" {
+		fmt.Printf("%s %d weekly averages before %s\n", verb, report.WeeklyPrices, report.WeeklyPricesCutoff)
+	}
+	if report.IntradayCutoff != "" {
+		fmt.Printf("%s %d intraday snapshots and %d intraday prices before %s\n",
+			verb, report.IntradaySnapshots, report.IntradayPrices, report.IntradayCutoff)
+	}
+	if report.SnapshotsCutoff == "" && report.DailyPricesCutoff == "" && report.WeeklyPricesCutoff == "" && report.IntradayCutoff == "" {
+		fmt.Println("No retention set, nothing to prune.")
+	}
+}
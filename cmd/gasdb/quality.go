@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+var qualityTemplate = template.Must(template.New("quality").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>gasdb data quality</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+td.count { text-align: right; }
+</style>
+</head>
+<body>
+{{- $kinds := .Kinds }}
+{{- with .Report }}
+<h1>Data quality on {{ .Date }}</h1>
+<p>{{ .Stations }} stations, {{ len .Issues }} issues.</p>
+<table>
+<tr><th>Issue</th><th>Stations</th></tr>
+{{- range $kinds }}
+<tr><td>{{ . }}</td><td class="count">{{ index $.Report.Counts . }}</td></tr>
+{{- end }}
+</table>
+<table>
+<tr><th>Station</th><th>Brand</th><th>Province</th><th>Issue</th><th>Detail</th></tr>
+{{- range .Issues }}
+<tr><td>{{ .IDEESS }}</td><td>{{ .Rotulo }}</td><td>{{ .Provincia }}</td><td>{{ .Kind }}</td><td>{{ .Detail }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .History }}
+<h1>Data quality history</h1>
+<table>
+<tr><th>Date</th><th>Stations</th>{{ range $kinds }}<th>{{ . }}</th>{{ end }}</tr>
+{{- range . }}
+{{- $counts := .Counts }}
+<tr><td>{{ .Date }}</td><td class="count">{{ .Stations }}</td>{{ range $kinds }}<td class="count">{{ index $counts . }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+func qualityCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "quality",
+		Usage: "Report stations with bad coordinates, schedules, postal codes or no prices",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.StringFlag{
+				Name:  "date",
+				Usage: "Report on this date (YYYY-MM-DD), the last stored day by default",
+			},
+			&cli.BoolFlag{
+				Name:  "history",
+				Usage: "Report the number of issues of every stored day instead",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Start date of --history (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End date of --history (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: json or html",
+				Value: "json",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Output file, stdout when empty",
+			},
+		},
+		Action: qualityAction,
+	}
+}
+
+func qualityAction(c *cli.Context) error {
+	format := c.String("format")
+	if format != "json" && format != "html" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var report *gasdb.QualityReport
+	var history []gasdb.QualitySummary
+	if c.Bool("history") {
+		from, err := parseOptionalDate(c.String("from"))
+		if err != nil {
+			return fmt.Errorf("invalid from date: %w", err)
+		}
+		to, err := parseOptionalDate(c.String("to"))
+		if err != nil {
+			return fmt.Errorf("invalid to date: %w", err)
+		}
+		if history, err = storage.QualityHistory(ctx, from, to); err != nil {
+			return err
+		}
+	} else {
+		date, err := parseOptionalDate(c.String("date"))
+		if err != nil {
+			return fmt.Errorf("invalid date: %w", err)
+		}
+		if report, err = storage.Quality(ctx, date); err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if path := c.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if format == "html" {
+		return qualityTemplate.Execute(out, struct {
+			Kinds   []gasdb.QualityIssueKind
+			Report  *gasdb.QualityReport
+			History []gasdb.QualitySummary
+		}{gasdb.QualityIssueKinds, report, history})
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if report != nil {
+		return enc.Encode(report)
+	}
+	return enc.Encode(history)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func searchCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "search",
+		Usage:     "Find stations by name, address, town or postal code",
+		ArgsUsage: "<words...>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "Maximum number of stations",
+				Value: 20,
+			},
+		},
+		Action: searchAction,
+	}
+}
+
+func searchAction(c *cli.Context) error {
+	query := strings.Join(c.Args().Slice(), " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New("missing search words")
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	stations, err := storage.SearchStations(ctx, query, c.Int("limit"))
+	if err != nil {
+		return err
+	}
+	if len(stations) == 0 {
+		fmt.Println("No stations found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IDEESS\tBRAND\tADDRESS\tTOWN\tPROVINCE\tCP\tLAST SEEN\t")
+	for _, st := range stations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			st.IDEESS, st.Rotulo, st.Direccion, st.Localidad, st.Provincia, st.CP,
+			st.ValidTo.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func stationCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "station",
+		Usage:     "Show the details, timeline and price summary of a station",
+		ArgsUsage: "<ideess>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.BoolFlag{
+				Name:  "rebuild",
+				Usage: "Derive the timeline of every station from every stored day first",
+			},
+		},
+		Action: stationAction,
+	}
+}
+
+func stationAction(c *cli.Context) error {
+	ideess := c.Args().First()
+	if ideess == "" {
+		return errors.New("missing station IDEESS")
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if c.Bool("rebuild") {
+		if err := storage.RebuildStationEvents(ctx); err != nil {
+			return err
+		}
+	}
+
+	profile, err := storage.Station(ctx, ideess)
+	if err != nil {
+		return err
+	}
+	events, err := storage.StationEvents(ctx, gasdb.StationEventFilter{IDEESS: ideess})
+	if err != nil {
+		return err
+	}
+
+	st := profile.Station
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Station\t%s\n", st.IDEESS)
+	fmt.Fprintf(w, "Brand\t%s\n", st.Rotulo)
+	fmt.Fprintf(w, "Address\t%s, %s %s (%s)\n", st.Direccion, st.CP, st.Localidad, st.Provincia)
+	fmt.Fprintf(w, "Coordinates\t%s, %s\n", st.Latitud, st.Longitud)
+	fmt.Fprintf(w, "Schedule\t%s\n", st.Horario)
+	fmt.Fprintf(w, "First seen\t%s\n", profile.FirstSeen)
+	fmt.Fprintf(w, "Last seen\t%s\n", profile.LastSeen)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nTimeline")
+	if len(events) == 0 {
+		fmt.Println("No events recorded, run with --rebuild to derive them from the stored days.")
+	} else {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tEVENT\tFROM\tTO\t")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", e.Date, e.Kind, e.Old, e.New)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\nPrices")
+	if len(profile.Prices) == 0 {
+		fmt.Println("No prices stored.")
+		return nil
+	}
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FUEL\tLAST\tLAST DATE\tMIN\tAVG\tMAX\tDAYS\t")
+	for _, p := range profile.Prices {
+		fmt.Fprintf(w, "%s\t%.3f\t%s\t%.3f\t%.3f\t%.3f\t%d\t\n", p.Fuel, p.Last, p.LastDate, p.Min, p.Avg, p.Max, p.Days)
+	}
+	return w.Flush()
+}
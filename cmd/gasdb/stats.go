@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func statsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "Print average, median, min and max prices per region or brand",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "db",
+				Usage:    "Database file",
+				Required: false,
+				Value:    "fuel_prices.db",
+			},
+			&cli.StringFlag{
+				Name:  "by",
+				Usage: "Grouping: country, ccaa, province, municipality or brand",
+				Value: string(gasdb.GroupCountry),
+			},
+			&cli.StringFlag{
+				Name:  "granularity",
+				Usage: "Time bucket: day, week or month",
+				Value: string(gasdb.GranularityDay),
+			},
+			&cli.StringFlag{
+				Name:  "fuel",
+				Usage: "Fuel type (e.g. diesel, gasolina95), all fuels when empty",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Only show this group, by ID or name (e.g. 28 or MADRID for provinces)",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Start date (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "End date (YYYY-MM-DD)",
+			},
+			&cli.BoolFlag{
+				Name:  "rebuild",
+				Usage: "Recompute the summary tables from every stored day first",
+			},
+		},
+		Action: statsAction,
+	}
+}
+
+func statsAction(c *cli.Context) error {
+	ctx := context.Background()
+
+	query := gasdb.AggregateQuery{Group: c.String("group")}
+	var err error
+	if query.GroupBy, err = gasdb.ParseGroupBy(c.String("by")); err != nil {
+		return err
+	}
+	if query.Granularity, err = gasdb.ParseGranularity(c.String("granularity")); err != nil {
+		return err
+	}
+	if c.String("fuel") != "" {
+		if query.Fuel, err = gasdb.ParseFuel(c.String("fuel")); err != nil {
+			return err
+		}
+	}
+	if query.From, err = parseOptionalDate(c.String("from")); err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	if query.To, err = parseOptionalDate(c.String("to")); err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if c.Bool("rebuild") {
+		fmt.Println("Rebuilding price aggregates...")
+		if err := storage.RebuildAggregates(ctx); err != nil {
+			return err
+		}
+	}
+
+	rows, err := storage.Aggregate(ctx, query)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		fmt.Println("No aggregates found. Databases created before aggregates existed need --rebuild once.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PERIOD\tGROUP\tFUEL\tAVG\tMEDIAN\tMIN\tMAX\tSTATIONS\t")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.3f\t%.3f\t%.3f\t%.3f\t%d\t\n",
+			row.Period.Format("2006-01-02"), row.Label, row.Fuel,
+			row.Avg, row.Median, row.Min, row.Max, row.Stations)
+	}
+	return w.Flush()
+}
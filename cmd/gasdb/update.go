@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"time"
 
 	"github.com/rubiojr/gasdb/internal/gasdb"
 	"github.com/urfave/cli/v2"
@@ -19,16 +23,92 @@ func updateCommand() *cli.Command {
 				Required: false,
 				Value:    "fuel_prices.db",
 			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "First day to fetch (YYYY-MM-DD), 2007-01-01 by default",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "Last day to fetch (YYYY-MM-DD), yesterday by default",
+			},
+			&cli.BoolFlag{
+				Name:  "only-missing",
+				Usage: "Fetch exactly the days check-status reports as missing",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Days fetched in parallel",
+				Value: 4,
+			},
+			&cli.IntFlag{
+				Name:  "retries",
+				Usage: "Extra passes over the days that failed (0 disables retries)",
+				Value: 2,
+			},
 		},
 		Action: updateAction,
 	}
 }
 
 func updateAction(c *cli.Context) error {
-	ctx := context.Background()
+	// Interrupting keeps the days saved so far, the next run resumes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
 	if err != nil {
 		return err
 	}
-	return storage.UpdateDBAll(ctx)
+	defer storage.Close()
+
+	from, err := parseOptionalDate(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := parseOptionalDate(c.String("to"))
+	if err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	retries := c.Int("retries")
+	if retries == 0 {
+		retries = -1
+	}
+	report, err := storage.Backfill(ctx, gasdb.BackfillOptions{
+		From:        from,
+		To:          to,
+		OnlyMissing: c.Bool("only-missing"),
+		Concurrency: c.Int("concurrency"),
+		Retries:     retries,
+		Progress:    printBackfillProgress,
+	})
+	if report != nil {
+		printBackfillReport(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Today's prices come from the live endpoint, only when the range reaches today
+	if !to.IsZero() && to.Before(gasdb.Today()) {
+		return nil
+	}
+	return storage.UpdateDB(ctx)
+}
+
+// printBackfillReport prints the days filled and the ones still failing.
+func printBackfillReport(report *gasdb.BackfillReport) {
+	if len(report.Fetched) == 0 && len(report.Failed) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Printf("Filled %d days, %d still failing.\n", len(report.Fetched), len(report.Failed))
+	for _, date := range report.Failed {
+		fmt.Println(date.Format("2006-01-02"))
+	}
+}
+
+func printBackfillProgress(p gasdb.BackfillProgress) {
+	fmt.Fprintf(os.Stderr, "\r%s: %d/%d days, %d failed, ETA %s   ",
+		p.Date.Format("2006-01-02"), p.Done+p.Failed, p.Total, p.Failed, p.ETA.Round(time.Second))
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func watchCommand() *cli.Command {
+	dbFlag := &cli.StringFlag{
+		Name:     "db",
+		Usage:    "Database file",
+		Required: false,
+		Value:    "fuel_prices.db",
+	}
+
+	return &cli.Command{
+		Name:  "watch",
+		Usage: "Manage price watch rules and review their alerts",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "Add a watch rule, evaluated every time the latest prices are saved",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Rule name",
+					},
+					&cli.StringFlag{
+						Name:  "fuel",
+						Usage: "Fuel type (e.g. diesel, gasolina95)",
+						Value: "diesel",
+					},
+					&cli.Float64Flag{
+						Name:  "below",
+						Usage: "Alert when the price drops below this threshold",
+					},
+					&cli.BoolFlag{
+						Name:  "cheapest-in-town",
+						Usage: "Alert when a station is the cheapest of its municipality",
+					},
+					&cli.StringSliceFlag{
+						Name:  "station",
+						Usage: "Watch this station id (IDEESS), repeatable",
+					},
+					&cli.Float64Flag{
+						Name:  "lat",
+						Usage: "Latitude of the watched area",
+					},
+					&cli.Float64Flag{
+						Name:  "lng",
+						Usage: "Longitude of the watched area",
+					},
+					&cli.Float64Flag{
+						Name:  "radius",
+						Usage: "Radius of the watched area in meters",
+						Value: 10000,
+					},
+					&cli.DurationFlag{
+						Name:  "cooldown",
+						Usage: "Minimum time between two alerts for the same station (default 168h)",
+					},
+				},
+				Action: addWatchAction,
+			},
+			{
+				Name:   "list",
+				Usage:  "List the watch rules",
+				Flags:  []cli.Flag{dbFlag},
+				Action: listWatchAction,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a watch rule and its alerts",
+				ArgsUsage: "<id>",
+				Flags:     []cli.Flag{dbFlag},
+				Action:    removeWatchAction,
+			},
+			{
+				Name:  "alerts",
+				Usage: "List the alerts raised, newest first",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.Int64Flag{
+						Name:  "rule",
+						Usage: "Only list the alerts of this rule id",
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Start date (YYYY-MM-DD)",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "End date (YYYY-MM-DD)",
+					},
+				},
+				Action: watchAlertsAction,
+			},
+		},
+	}
+}
+
+func addWatchAction(c *cli.Context) error {
+	rule := gasdb.WatchRule{
+		Name:     c.String("name"),
+		Fuel:     gasdb.Fuel(c.String("fuel")),
+		Stations: c.StringSlice("station"),
+		Cooldown: c.Duration("cooldown"),
+	}
+	switch {
+	case c.IsSet("below") && c.Bool("cheapest-in-town"):
+		return errors.New("pass either --below or --cheapest-in-town")
+	case c.IsSet("below"):
+		rule.Condition, rule.Threshold = gasdb.WatchBelow, c.Float64("below")
+	case c.Bool("cheapest-in-town"):
+		rule.Condition = gasdb.WatchCheapestInTown
+	default:
+		return errors.New("missing condition, pass --below or --cheapest-in-town")
+	}
+	if len(rule.Stations) == 0 {
+		if !c.IsSet("lat") || !c.IsSet("lng") {
+			return errors.New("pass --station or --lat and --lng")
+		}
+		rule.Latitude, rule.Longitude, rule.Radius = c.Float64("lat"), c.Float64("lng"), c.Float64("radius")
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	id, err := storage.AddWatchRule(ctx, rule)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added watch rule %d.\n", id)
+	return nil
+}
+
+func listWatchAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	rules, err := storage.WatchRules(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Println("No watch rules.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tFUEL\tCONDITION\tSTATIONS\tCOOLDOWN\t")
+	for _, r := range rules {
+		condition := string(r.Condition)
+		if r.Condition == gasdb.WatchBelow {
+			condition = fmt.Sprintf("below %.3f", r.Threshold)
+		}
+		stations := strings.Join(r.Stations, ",")
+		if stations == "" {
+			stations = fmt.Sprintf("%.0f m around %.4f,%.4f", r.Radius, r.Latitude, r.Longitude)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t\n", r.ID, r.Name, r.Fuel, condition, stations, r.Cooldown)
+	}
+	return w.Flush()
+}
+
+func removeWatchAction(c *cli.Context) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid rule id")
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if err := storage.DeleteWatchRule(ctx, id); err != nil {
+		return err
+	}
+	fmt.Printf("Removed watch rule %d.\n", id)
+	return nil
+}
+
+func watchAlertsAction(c *cli.Context) error {
+	from, err := parseOptionalDate(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("invalid from date: %w", err)
+	}
+	to, err := parseOptionalDate(c.String("to"))
+	if err != nil {
+		return fmt.Errorf("invalid to date: %w", err)
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	alerts, err := storage.Alerts(ctx, gasdb.AlertQuery{RuleID: c.Int64("rule"), From: from, To: to})
+	if err != nil {
+		return err
+	}
+	if len(alerts) == 0 {
+		fmt.Println("No alerts.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tRULE\tSTATION\tBRAND\tFUEL\tPRICE\tDETAIL\t")
+	for _, a := range alerts {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.3f\t%s\t\n", a.Date, a.RuleID, a.IDEESS, a.Rotulo, a.Fuel, a.Price, a.Detail)
+	}
+	return w.Flush()
+}
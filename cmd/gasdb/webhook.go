@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rubiojr/gasdb/internal/gasdb"
+	"github.com/urfave/cli/v2"
+)
+
+func webhookCommand() *cli.Command {
+	dbFlag := &cli.StringFlag{
+		Name:     "db",
+		Usage:    "Database file",
+		Required: false,
+		Value:    "fuel_prices.db",
+	}
+
+	return &cli.Command{
+		Name:  "webhook",
+		Usage: "Register webhooks pushed new snapshots, anomalies and alerts",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "Register a webhook endpoint",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.StringFlag{
+						Name:     "url",
+						Usage:    "Endpoint URL",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "secret",
+						Usage: "HMAC secret of the payload signatures, generated when empty",
+					},
+					&cli.StringSliceFlag{
+						Name:  "event",
+						Usage: "Only deliver this event: snapshot, anomaly or alert, repeatable",
+					},
+				},
+				Action: addWebhookAction,
+			},
+			{
+				Name:   "list",
+				Usage:  "List the webhooks",
+				Flags:  []cli.Flag{dbFlag},
+				Action: listWebhooksAction,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a webhook and its delivery log",
+				ArgsUsage: "<id>",
+				Flags:     []cli.Flag{dbFlag},
+				Action:    removeWebhookAction,
+			},
+			{
+				Name:      "test",
+				Usage:     "Send a ping event to a webhook",
+				ArgsUsage: "<id>",
+				Flags:     []cli.Flag{dbFlag},
+				Action:    testWebhookAction,
+			},
+			{
+				Name:  "deliveries",
+				Usage: "Show the delivery log",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.Int64Flag{
+						Name:  "webhook",
+						Usage: "Only show the deliveries of this webhook id",
+					},
+					&cli.StringFlag{
+						Name:  "status",
+						Usage: "Only show deliveries with this status: pending, delivered or failed",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Number of most recent deliveries shown",
+						Value: 20,
+					},
+				},
+				Action: webhookDeliveriesAction,
+			},
+			{
+				Name:  "deliver",
+				Usage: "Send the pending deliveries that are due",
+				Flags: []cli.Flag{
+					dbFlag,
+					&cli.BoolFlag{
+						Name:  "retry-failed",
+						Usage: "Queue the failed deliveries again before sending",
+					},
+				},
+				Action: deliverWebhooksAction,
+			},
+		},
+	}
+}
+
+func addWebhookAction(c *cli.Context) error {
+	hook := gasdb.Webhook{URL: c.String("url"), Secret: c.String("secret")}
+	for _, name := range c.StringSlice("event") {
+		event, err := gasdb.ParseWebhookEvent(name)
+		if err != nil {
+			return err
+		}
+		hook.Events = append(hook.Events, event)
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	added, err := storage.AddWebhook(ctx, hook)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added webhook %d.\n", added.ID)
+	if hook.Secret == "" {
+		fmt.Printf("Secret: %s\n", added.Secret)
+	}
+	return nil
+}
+
+func listWebhooksAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	hooks, err := storage.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		fmt.Println("No webhooks.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURL\tEVENTS\tCREATED\t")
+	for _, h := range hooks {
+		events := "all"
+		if len(h.Events) > 0 {
+			names := make([]string, len(h.Events))
+			for i, e := range h.Events {
+				names[i] = string(e)
+			}
+			events = strings.Join(names, ",")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t\n", h.ID, h.URL, events, h.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func webhookID(c *cli.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return 0, errors.New("missing or invalid webhook id")
+	}
+	return id, nil
+}
+
+func removeWebhookAction(c *cli.Context) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if err := storage.DeleteWebhook(ctx, id); err != nil {
+		return err
+	}
+	fmt.Printf("Removed webhook %d.\n", id)
+	return nil
+}
+
+func testWebhookAction(c *cli.Context) error {
+	id, err := webhookID(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	d, err := storage.TestWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if d.Status != gasdb.DeliveryDelivered {
+		return fmt.Errorf("ping failed: %s", d.Error)
+	}
+	fmt.Printf("Ping delivered, HTTP %d.\n", d.ResponseCode)
+	return nil
+}
+
+func webhookDeliveriesAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	deliveries, err := storage.WebhookDeliveries(ctx, gasdb.DeliveryQuery{
+		WebhookID: c.Int64("webhook"),
+		Status:    c.String("status"),
+		Limit:     c.Int("limit"),
+	})
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		fmt.Println("No deliveries.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWEBHOOK\tEVENT\tCREATED\tSTATUS\tATTEMPTS\tNEXT\tHTTP\tERROR\t")
+	for _, d := range deliveries {
+		next := "-"
+		if !d.NextAttemptAt.IsZero() {
+			next = d.NextAttemptAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%d\t%s\t%d\t%s\t\n", d.ID, d.WebhookID, d.Event,
+			d.CreatedAt.Format("2006-01-02 15:04"), d.Status, d.Attempts, next, d.ResponseCode, d.Error)
+	}
+	return w.Flush()
+}
+
+func deliverWebhooksAction(c *cli.Context) error {
+	ctx := context.Background()
+	storage, err := gasdb.NewStorage(ctx, c.String("db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if c.Bool("retry-failed") {
+		retried, err := storage.RetryFailedWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Queued %d failed deliveries again.\n", retried)
+	}
+
+	delivered, err := storage.DeliverWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Delivered %d pending deliveries.\n", delivered)
+	return nil
+}
@@ -7,14 +7,18 @@ godebug tlsrsakex=1
 require (
 	github.com/muesli/gominatim v0.1.0
 	github.com/ncruces/go-sqlite3 v0.27.1
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/parquet-go/parquet-go v0.25.1
 	github.com/tkrajina/gpxgo v1.4.0
 	github.com/urfave/cli/v2 v2.27.6
 )
 
 require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
 	github.com/ncruces/julianday v1.0.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/tetratelabs/wazero v1.9.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
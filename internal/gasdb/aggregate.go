@@ -0,0 +1,418 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GroupBy selects how stations are grouped in price aggregates.
+type GroupBy string
+
+const (
+	GroupCountry      GroupBy = "country"
+	GroupCCAA         GroupBy = "ccaa"
+	GroupProvince     GroupBy = "province"
+	GroupMunicipality GroupBy = "municipality"
+	GroupBrand        GroupBy = "brand"
+)
+
+// countryGroupKey is the single group key used for GroupCountry.
+const countryGroupKey = "ES"
+
+// GroupBys lists every supported grouping.
+var GroupBys = []GroupBy{GroupCountry, GroupCCAA, GroupProvince, GroupMunicipality, GroupBrand}
+
+// Granularity is the time bucket of price aggregates.
+type Granularity string
+
+const (
+	GranularityDay   Granularity = "day"
+	GranularityWeek  Granularity = "week"
+	GranularityMonth Granularity = "month"
+)
+
+// AggregateQuery selects rows from the price summary tables. Empty Fuel or
+// Group match every fuel or group, and zero From or To leave the range open.
+type AggregateQuery struct {
+	GroupBy     GroupBy
+	Granularity Granularity
+	Fuel        Fuel
+	Group       string
+	From        time.Time
+	To          time.Time
+}
+
+// AggregateRow holds the price statistics of a group and fuel over one period.
+//
+// Day rows are computed from every station price of the day. Week and month
+// rows are rolled up from the day rows: Avg, Min and Max are exact, Median is
+// the median of the daily medians and Stations is the largest number of
+// stations that reported on a single day of the period.
+type AggregateRow struct {
+	Period   time.Time
+	Group    string
+	Label    string
+	Fuel     Fuel
+	Avg      float64
+	Median   float64
+	Min      float64
+	Max      float64
+	Stations int
+}
+
+// createAggregateTables creates the price_aggregates summary table.
+func (s *Storage) createAggregateTables(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS price_aggregates (
+		granularity TEXT NOT NULL,
+		period TEXT NOT NULL,
+		group_by TEXT NOT NULL,
+		group_key TEXT NOT NULL,
+		label TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		avg REAL NOT NULL,
+		median REAL NOT NULL,
+		min REAL NOT NULL,
+		max REAL NOT NULL,
+		stations INTEGER NOT NULL,
+		PRIMARY KEY (granularity, group_by, period, group_key, fuel)
+	) WITHOUT ROWID;
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating price_aggregates table: %w", err)
+	}
+	return nil
+}
+
+// periodStart returns the first day of the period containing date.
+// Weeks start on Monday.
+func periodStart(date time.Time, granularity Granularity) time.Time {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case GranularityWeek:
+		offset := (int(date.Weekday()) + 6) % 7
+		return date.AddDate(0, 0, -offset)
+	case GranularityMonth:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return date
+	}
+}
+
+// periodEnd returns the last day of the period starting at start.
+func periodEnd(start time.Time, granularity Granularity) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return start.AddDate(0, 0, 6)
+	case GranularityMonth:
+		return start.AddDate(0, 1, -1)
+	default:
+		return start
+	}
+}
+
+type aggregateKey struct {
+	groupBy GroupBy
+	group   string
+	fuel    Fuel
+}
+
+// UpdateAggregates recomputes the day summaries for date and rolls them up
+// into the week and month containing it.
+func (s *Storage) UpdateAggregates(ctx context.Context, date time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	if err := updateAggregates(ctx, tx, date); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing aggregates: %w", err)
+	}
+	return nil
+}
+
+// updateAggregates does the work of UpdateAggregates inside tx, so SavePrices
+// can refresh the summaries in the same transaction as the snapshot.
+// Quarantined prices are left out until they are dismissed.
+func updateAggregates(ctx context.Context, tx *sql.Tx, date time.Time) error {
+	dateStr := date.Format("2006-01-02")
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.idccaa, s.idprovincia, s.provincia, s.idmunicipio, s.municipio,
+			UPPER(TRIM(s.rotulo)), p.fuel, p.price
+		FROM prices p
+		JOIN station_days d ON d.date = p.date AND d.ideess = p.ideess
+		JOIN stations s ON s.id = d.station_id
+		WHERE p.date = ? AND p.price > 0
+			AND NOT EXISTS (
+				SELECT 1 FROM quarantine q
+				WHERE q.date = p.date AND q.ideess = p.ideess AND q.fuel IN (p.fuel, '') AND NOT q.dismissed
+			)
+	`, dateStr)
+	if err != nil {
+		return fmt.Errorf("error querying prices for aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[aggregateKey][]float64)
+	labels := make(map[aggregateKey]string)
+	for rows.Next() {
+		var ccaa, province, provinceName, municipality, municipalityName, brand, fuel string
+		var price float64
+		if err := rows.Scan(&ccaa, &province, &provinceName, &municipality, &municipalityName, &brand, &fuel, &price); err != nil {
+			return fmt.Errorf("error scanning price: %w", err)
+		}
+
+		groups := []struct {
+			groupBy    GroupBy
+			key, label string
+		}{
+			{GroupCountry, countryGroupKey, "España"},
+			{GroupCCAA, ccaa, ccaa},
+			{GroupProvince, province, provinceName},
+			{GroupMunicipality, municipality, municipalityName},
+			{GroupBrand, brand, brand},
+		}
+		for _, g := range groups {
+			if g.key == "" {
+				continue
+			}
+			k := aggregateKey{g.groupBy, g.key, Fuel(fuel)}
+			values[k] = append(values[k], price)
+			labels[k] = g.label
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating prices: %w", err)
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM price_aggregates WHERE granularity = ? AND period = ?", GranularityDay, dateStr); err != nil {
+		return fmt.Errorf("error clearing day aggregates: %w", err)
+	}
+
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT OR REPLACE INTO price_aggregates
+			(granularity, period, group_by, group_key, label, fuel, avg, median, min, max, stations)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing aggregate insert: %w", err)
+	}
+	defer insert.Close()
+
+	for k, prices := range values {
+		row := summarize(prices)
+		_, err := insert.ExecContext(ctx, GranularityDay, dateStr, k.groupBy, k.group, labels[k], k.fuel,
+			row.Avg, row.Median, row.Min, row.Max, row.Stations)
+		if err != nil {
+			return fmt.Errorf("error saving day aggregate: %w", err)
+		}
+	}
+
+	for _, granularity := range []Granularity{GranularityWeek, GranularityMonth} {
+		if err := rollupAggregates(ctx, tx, insert, periodStart(date, granularity), granularity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupAggregates recomputes the week or month rows starting at start from the day rows.
+func rollupAggregates(ctx context.Context, tx *sql.Tx, insert *sql.Stmt, start time.Time, granularity Granularity) error {
+	startStr := start.Format("2006-01-02")
+	endStr := periodEnd(start, granularity).Format("2006-01-02")
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM price_aggregates WHERE granularity = ? AND period = ?", granularity, startStr); err != nil {
+		return fmt.Errorf("error clearing %s aggregates: %w", granularity, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT group_by, group_key, label, fuel, avg, median, min, max, stations
+		FROM price_aggregates
+		WHERE granularity = ? AND period BETWEEN ? AND ?
+	`, GranularityDay, startStr, endStr)
+	if err != nil {
+		return fmt.Errorf("error querying day aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	days := make(map[aggregateKey][]AggregateRow)
+	for rows.Next() {
+		var k aggregateKey
+		var row AggregateRow
+		if err := rows.Scan(&k.groupBy, &k.group, &row.Label, &k.fuel, &row.Avg, &row.Median, &row.Min, &row.Max, &row.Stations); err != nil {
+			return fmt.Errorf("error scanning day aggregate: %w", err)
+		}
+		days[k] = append(days[k], row)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating day aggregates: %w", err)
+	}
+	rows.Close()
+
+	for k, dayRows := range days {
+		row := rollup(dayRows)
+		_, err := insert.ExecContext(ctx, granularity, startStr, k.groupBy, k.group, row.Label, k.fuel,
+			row.Avg, row.Median, row.Min, row.Max, row.Stations)
+		if err != nil {
+			return fmt.Errorf("error saving %s aggregate: %w", granularity, err)
+		}
+	}
+	return nil
+}
+
+// summarize computes the statistics of a set of prices.
+func summarize(prices []float64) AggregateRow {
+	sort.Float64s(prices)
+	sum := 0.0
+	for _, p := range prices {
+		sum += p
+	}
+	return AggregateRow{
+		Avg:      sum / float64(len(prices)),
+		Median:   median(prices),
+		Min:      prices[0],
+		Max:      prices[len(prices)-1],
+		Stations: len(prices),
+	}
+}
+
+// rollup combines day rows into a single row for a longer period.
+func rollup(days []AggregateRow) AggregateRow {
+	result := AggregateRow{Label: days[0].Label, Min: math.Inf(1), Max: math.Inf(-1)}
+	medians := make([]float64, 0, len(days))
+	weighted, total := 0.0, 0
+	for _, d := range days {
+		weighted += d.Avg * float64(d.Stations)
+		total += d.Stations
+		result.Min = math.Min(result.Min, d.Min)
+		result.Max = math.Max(result.Max, d.Max)
+		if d.Stations > result.Stations {
+			result.Stations = d.Stations
+		}
+		medians = append(medians, d.Median)
+	}
+	sort.Float64s(medians)
+	result.Avg = weighted / float64(total)
+	result.Median = median(medians)
+	return result
+}
+
+// median returns the median of sorted values.
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// RebuildAggregates recomputes the summary tables for every stored day.
+func (s *Storage) RebuildAggregates(ctx context.Context) error {
+	dates, err := s.GetAllDates(ctx)
+	if err != nil {
+		return err
+	}
+	for _, date := range dates {
+		if err := s.UpdateAggregates(ctx, date); err != nil {
+			return fmt.Errorf("error updating aggregates for %s: %w", date.Format("2006-01-02"), err)
+		}
+		s.log.Debug("Rebuilt aggregates", "date", date.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// Aggregate returns price statistics from the summary tables, ordered by
+// period, group and fuel.
+func (s *Storage) Aggregate(ctx context.Context, q AggregateQuery) ([]AggregateRow, error) {
+	if q.GroupBy == "" {
+		q.GroupBy = GroupCountry
+	}
+	if q.Granularity == "" {
+		q.Granularity = GranularityDay
+	}
+
+	fromStr, toStr := minDateBound, maxDateBound
+	if !q.From.IsZero() {
+		fromStr = periodStart(q.From, q.Granularity).Format("2006-01-02")
+	}
+	if !q.To.IsZero() {
+		toStr = q.To.Format("2006-01-02")
+	}
+
+	conditions := []string{"granularity = ?", "group_by = ?", "period BETWEEN ? AND ?"}
+	args := []any{q.Granularity, q.GroupBy, fromStr, toStr}
+	if q.Fuel != "" {
+		conditions = append(conditions, "fuel = ?")
+		args = append(args, q.Fuel)
+	}
+	if q.Group != "" {
+		conditions = append(conditions, "(group_key = ? OR label = ?)")
+		args = append(args, q.Group, q.Group)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT period, group_key, label, fuel, avg, median, min, max, stations
+		FROM price_aggregates
+		WHERE %s
+		ORDER BY period, group_key, fuel
+	`, strings.Join(conditions, " AND "))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AggregateRow
+	for rows.Next() {
+		var row AggregateRow
+		var period string
+		if err := rows.Scan(&period, &row.Group, &row.Label, &row.Fuel, &row.Avg, &row.Median, &row.Min, &row.Max, &row.Stations); err != nil {
+			return nil, fmt.Errorf("error scanning aggregate: %w", err)
+		}
+		row.Period, _ = time.Parse("2006-01-02", period)
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating aggregates: %w", err)
+	}
+
+	return result, nil
+}
+
+// ParseGroupBy validates a grouping name.
+func ParseGroupBy(name string) (GroupBy, error) {
+	for _, g := range GroupBys {
+		if string(g) == strings.ToLower(name) {
+			return g, nil
+		}
+	}
+	return "", fmt.Errorf("unknown grouping %q", name)
+}
+
+// ParseGranularity validates a granularity name.
+func ParseGranularity(name string) (Granularity, error) {
+	switch g := Granularity(strings.ToLower(name)); g {
+	case GranularityDay, GranularityWeek, GranularityMonth:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q", name)
+	}
+}
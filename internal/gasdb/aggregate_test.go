@@ -0,0 +1,80 @@
+package gasdb
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	tests := []struct {
+		name  string
+		query AggregateQuery
+		want  []AggregateRow
+	}{
+		{
+			name:  "country by day",
+			query: AggregateQuery{Fuel: FuelGasoleoA},
+			want: []AggregateRow{
+				{Group: countryGroupKey, Avg: 1.469, Median: 1.469, Min: 1.459, Max: 1.479, Stations: 2},
+				{Group: countryGroupKey, Avg: 1.459, Median: 1.459, Min: 1.449, Max: 1.469, Stations: 2},
+			},
+		},
+		{
+			name:  "province by week",
+			query: AggregateQuery{GroupBy: GroupProvince, Granularity: GranularityWeek, Fuel: FuelGasoleoA},
+			want: []AggregateRow{
+				{Group: "28", Label: "MADRID", Avg: 1.464, Median: 1.464, Min: 1.449, Max: 1.479, Stations: 2},
+			},
+		},
+		{
+			name:  "brand by month",
+			query: AggregateQuery{GroupBy: GroupBrand, Granularity: GranularityMonth, Fuel: FuelGasolina95E5, Group: "MOEVE"},
+			want: []AggregateRow{
+				{Group: "MOEVE", Label: "MOEVE", Avg: 1.609, Median: 1.609, Min: 1.609, Max: 1.609, Stations: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Aggregate(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Aggregate() failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d rows, got %d: %+v", len(tt.want), len(got), got)
+			}
+			for i, want := range tt.want {
+				row := got[i]
+				if row.Group != want.Group || (want.Label != "" && row.Label != want.Label) || row.Stations != want.Stations {
+					t.Errorf("row %d: got %+v, want %+v", i, row, want)
+				}
+				for _, v := range [][2]float64{{row.Avg, want.Avg}, {row.Median, want.Median}, {row.Min, want.Min}, {row.Max, want.Max}} {
+					if math.Abs(v[0]-v[1]) > 1e-9 {
+						t.Errorf("row %d: got %+v, want %+v", i, row, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	date := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC) // Thursday
+	if got := periodStart(date, GranularityWeek).Format("2006-01-02"); got != "2024-02-26" {
+		t.Errorf("week start = %s, want 2024-02-26", got)
+	}
+	if got := periodStart(date, GranularityMonth).Format("2006-01-02"); got != "2024-02-01" {
+		t.Errorf("month start = %s, want 2024-02-01", got)
+	}
+	if got := periodEnd(periodStart(date, GranularityMonth), GranularityMonth).Format("2006-01-02"); got != "2024-02-29" {
+		t.Errorf("month end = %s, want 2024-02-29", got)
+	}
+}
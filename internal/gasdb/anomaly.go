@@ -0,0 +1,542 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// AnomalyReason explains why a price or station was quarantined.
+type AnomalyReason string
+
+const (
+	// AnomalyImplausiblePrice is a price outside the plausible range.
+	AnomalyImplausiblePrice AnomalyReason = "implausible_price"
+	// AnomalyRegionalOutlier is a price far from the median of its province
+	// for that fuel and day.
+	AnomalyRegionalOutlier AnomalyReason = "regional_outlier"
+	// AnomalyPriceJump is a price far from the previous price of the station.
+	AnomalyPriceJump AnomalyReason = "price_jump"
+	// AnomalyCoordinatesJump is a station that moved too far from its
+	// previous coordinates. It applies to every fuel of the station.
+	AnomalyCoordinatesJump AnomalyReason = "coordinates_jump"
+)
+
+const (
+	// maxRegionalDeviation is the largest relative distance of a price to
+	// the median of its province.
+	maxRegionalDeviation = 0.4
+	// minRegionalSamples is the number of prices a province needs for its
+	// median to be used, the national median is used otherwise.
+	minRegionalSamples = 5
+	// maxPriceChange is the largest relative change of a station price from
+	// its previous stored price.
+	maxPriceChange = 0.3
+	// maxCoordinatesJump is the largest distance in meters a station can move
+	// between two days.
+	maxCoordinatesJump = 100_000
+)
+
+// Anomaly is a quarantined price, or a whole station when Fuel is empty.
+type Anomaly struct {
+	Date   string        `json:"date"`
+	IDEESS string        `json:"ideess"`
+	Fuel   Fuel          `json:"fuel,omitempty"`
+	Reason AnomalyReason `json:"reason"`
+	// Value is the flagged price, or the distance moved in meters for
+	// AnomalyCoordinatesJump. Expected is the reference it was compared
+	// with: the regional median or the previous price.
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"`
+	Detail   string  `json:"detail"`
+	// Dismissed is set once the anomaly was reviewed as a false positive.
+	Dismissed bool `json:"dismissed"`
+}
+
+// AnomalyQuery filters Anomalies. Zero fields match everything.
+type AnomalyQuery struct {
+	From   time.Time
+	To     time.Time
+	IDEESS string
+	Reason AnomalyReason
+	// Dismissed includes the anomalies dismissed after review.
+	Dismissed bool
+}
+
+// createQuarantineTable creates quarantine, which holds the prices and
+// stations flagged as anomalies when their snapshot was ingested.
+func (s *Storage) createQuarantineTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS quarantine (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL,
+		value REAL NOT NULL,
+		expected REAL NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		dismissed INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (date, ideess, fuel, reason)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_quarantine_ideess ON quarantine (ideess, date);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating quarantine table: %w", err)
+	}
+	return nil
+}
+
+// plausiblePrice reports whether price is within the plausible range of fuel.
+func plausiblePrice(fuel Fuel, price float64) bool {
+	maxPrice := maxPlausiblePrice
+	if fuel == FuelHidrogeno {
+		maxPrice = maxPlausibleHydrogenPrice
+	}
+	return price >= minPlausiblePrice && price <= maxPrice
+}
+
+// dayPrices returns the prices stored for date. With unquarantined set, the
+// prices quarantined on that day are left out.
+func dayPrices(ctx context.Context, tx *sql.Tx, date string, unquarantined bool) (map[[2]string]float64, error) {
+	query := "SELECT p.ideess, p.fuel, p.price FROM prices p WHERE p.date = ?"
+	if unquarantined {
+		query += `
+		AND NOT EXISTS (
+			SELECT 1 FROM quarantine q
+			WHERE q.date = p.date AND q.ideess = p.ideess AND q.fuel IN (p.fuel, '') AND NOT q.dismissed
+		)`
+	}
+	rows, err := tx.QueryContext(ctx, query, date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying prices of %s: %w", date, err)
+	}
+	defer rows.Close()
+
+	prices := make(map[[2]string]float64)
+	for rows.Next() {
+		var ideess, fuel string
+		var price float64
+		if err := rows.Scan(&ideess, &fuel, &price); err != nil {
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+		prices[[2]string{ideess, fuel}] = price
+	}
+	return prices, rows.Err()
+}
+
+// dayCoordinates returns the coordinates of the stations listed on date.
+func dayCoordinates(ctx context.Context, tx *sql.Tx, date string) (map[string][2]float64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT d.ideess, s.latitud, s.longitud FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		WHERE d.date = ?
+	`, date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying coordinates of %s: %w", date, err)
+	}
+	defer rows.Close()
+
+	coords := make(map[string][2]float64)
+	for rows.Next() {
+		var ideess, lat, lng string
+		if err := rows.Scan(&ideess, &lat, &lng); err != nil {
+			return nil, fmt.Errorf("error scanning coordinates: %w", err)
+		}
+		latitude, err := ParseLatLong(lat)
+		if err != nil {
+			continue
+		}
+		longitude, err := ParseLatLong(lng)
+		if err != nil {
+			continue
+		}
+		coords[ideess] = [2]float64{latitude, longitude}
+	}
+	return coords, rows.Err()
+}
+
+// jumpAnomalies compares the stations and prices stored for date with the
+// ones stored for prevDate, leaving out the prices quarantined on prevDate,
+// and returns the stations that moved too far and the prices that changed
+// too much, by station and fuel.
+func jumpAnomalies(ctx context.Context, tx *sql.Tx, prevDate, date string) ([]Anomaly, error) {
+	if prevDate == "" {
+		return nil, nil
+	}
+	prevPrices, err := dayPrices(ctx, tx, prevDate, true)
+	if err != nil {
+		return nil, err
+	}
+	prevCoords, err := dayCoordinates(ctx, tx, prevDate)
+	if err != nil {
+		return nil, err
+	}
+	prices, err := dayPrices(ctx, tx, date, false)
+	if err != nil {
+		return nil, err
+	}
+	coords, err := dayCoordinates(ctx, tx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	var anomalies []Anomaly
+	for ideess, cur := range coords {
+		prev, ok := prevCoords[ideess]
+		if !ok {
+			continue
+		}
+		if moved := gpx.Distance2D(prev[0], prev[1], cur[0], cur[1], true); moved > maxCoordinatesJump {
+			anomalies = append(anomalies, Anomaly{
+				IDEESS: ideess, Reason: AnomalyCoordinatesJump, Value: moved,
+				Detail: fmt.Sprintf("moved %.0f km", moved/1000),
+			})
+		}
+	}
+	for key, price := range prices {
+		prev, ok := prevPrices[key]
+		if !ok || !plausiblePrice(Fuel(key[1]), price) {
+			continue
+		}
+		if math.Abs(price-prev)/prev > maxPriceChange {
+			anomalies = append(anomalies, Anomaly{
+				IDEESS: key[0], Fuel: Fuel(key[1]), Reason: AnomalyPriceJump, Value: price, Expected: prev,
+				Detail: fmt.Sprintf("%.3f, previously %.3f", price, prev),
+			})
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].IDEESS != anomalies[j].IDEESS {
+			return anomalies[i].IDEESS < anomalies[j].IDEESS
+		}
+		return anomalies[i].Fuel < anomalies[j].Fuel
+	})
+	return anomalies, nil
+}
+
+// quarantine records anomalies on date. Anomalies already recorded, such as
+// dismissed ones, are left as they are.
+func quarantine(ctx context.Context, tx *sql.Tx, date string, anomalies []Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO quarantine (date, ideess, fuel, reason, value, expected, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing quarantine insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, a := range anomalies {
+		if _, err := stmt.ExecContext(ctx, date, a.IDEESS, string(a.Fuel), string(a.Reason), a.Value, a.Expected, a.Detail); err != nil {
+			return fmt.Errorf("error quarantining %s: %w", a.IDEESS, err)
+		}
+	}
+	return nil
+}
+
+// detectAnomalies quarantines the implausible prices of a day, the prices
+// far from the median of their province or from the previous price of the
+// station, and the stations whose coordinates jumped. Anomalies dismissed
+// in a previous ingestion of the day are kept as dismissed. It returns the
+// anomalies not recorded by a previous ingestion of the day.
+//
+// Days can be saved in any order, so the jumps of the next stored day are
+// checked again against this one, and its aggregates refreshed if they
+// changed.
+func (s *Storage) detectAnomalies(ctx context.Context, tx *sql.Tx, date string, stations []*api.GasStation) ([]Anomaly, error) {
+	known, err := quarantinedKeys(ctx, tx, date)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM quarantine WHERE date = ? AND NOT dismissed", date); err != nil {
+		return nil, fmt.Errorf("error clearing quarantine for %s: %w", date, err)
+	}
+
+	var prevDate, nextDate string
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE((SELECT MAX(date) FROM station_days WHERE date < ?), ''),
+			COALESCE((SELECT MIN(date) FROM station_days WHERE date > ?), '')
+	`, date, date).Scan(&prevDate, &nextDate)
+	if err != nil {
+		return nil, fmt.Errorf("error querying days around %s: %w", date, err)
+	}
+
+	// Medians per province and fuel, and per fuel for small provinces
+	regional := make(map[[2]string][]float64)
+	national := make(map[Fuel][]float64)
+	for _, station := range stations {
+		for _, f := range fuelFields {
+			if price, ok := parsePrice(f.get(station)); ok && plausiblePrice(f.fuel, price) {
+				key := [2]string{station.IDProvincia, string(f.fuel)}
+				regional[key] = append(regional[key], price)
+				national[f.fuel] = append(national[f.fuel], price)
+			}
+		}
+	}
+	medians := make(map[[2]string]float64, len(regional))
+	for key, prices := range regional {
+		if len(prices) < minRegionalSamples {
+			prices = national[Fuel(key[1])]
+		}
+		sorted := append([]float64(nil), prices...)
+		sort.Float64s(sorted)
+		medians[key] = median(sorted)
+	}
+
+	var anomalies []Anomaly
+	for _, station := range stations {
+		for _, f := range fuelFields {
+			price, ok := parsePrice(f.get(station))
+			if !ok {
+				continue
+			}
+			if !plausiblePrice(f.fuel, price) {
+				anomalies = append(anomalies, Anomaly{
+					IDEESS: station.IDEESS, Fuel: f.fuel, Reason: AnomalyImplausiblePrice, Value: price,
+					Detail: fmt.Sprintf("%.3f is outside the plausible range", price),
+				})
+				continue
+			}
+			if m, ok := medians[[2]string{station.IDProvincia, string(f.fuel)}]; ok && math.Abs(price-m)/m > maxRegionalDeviation {
+				anomalies = append(anomalies, Anomaly{
+					IDEESS: station.IDEESS, Fuel: f.fuel, Reason: AnomalyRegionalOutlier, Value: price, Expected: m,
+					Detail: fmt.Sprintf("%.3f, regional median %.3f", price, m),
+				})
+			}
+		}
+	}
+	jumps, err := jumpAnomalies(ctx, tx, prevDate, date)
+	if err != nil {
+		return nil, err
+	}
+	anomalies = append(anomalies, jumps...)
+
+	if err := quarantine(ctx, tx, date, anomalies); err != nil {
+		return nil, err
+	}
+	var detected []Anomaly
+	for _, a := range anomalies {
+		if !known[[3]string{a.IDEESS, string(a.Fuel), string(a.Reason)}] {
+			a.Date = date
+			detected = append(detected, a)
+		}
+	}
+
+	if nextDate != "" {
+		if err := requarantineJumps(ctx, tx, date, nextDate); err != nil {
+			return nil, err
+		}
+	}
+	return detected, nil
+}
+
+// requarantineJumps checks the jumps of nextDate again against date, which
+// was just saved before it, and refreshes the aggregates of nextDate when
+// its quarantine changed.
+func requarantineJumps(ctx context.Context, tx *sql.Tx, date, nextDate string) error {
+	const jumpReasons = "reason IN ('" + string(AnomalyPriceJump) + "', '" + string(AnomalyCoordinatesJump) + "')"
+
+	before, err := quarantinedKeys(ctx, tx, nextDate)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM quarantine WHERE date = ? AND NOT dismissed AND "+jumpReasons, nextDate); err != nil {
+		return fmt.Errorf("error clearing quarantine for %s: %w", nextDate, err)
+	}
+	jumps, err := jumpAnomalies(ctx, tx, date, nextDate)
+	if err != nil {
+		return err
+	}
+	if err := quarantine(ctx, tx, nextDate, jumps); err != nil {
+		return err
+	}
+	after, err := quarantinedKeys(ctx, tx, nextDate)
+	if err != nil {
+		return err
+	}
+
+	if maps.Equal(before, after) {
+		return nil
+	}
+	next, err := time.Parse("2006-01-02", nextDate)
+	if err != nil {
+		return fmt.Errorf("error parsing date %s: %w", nextDate, err)
+	}
+	return updateAggregates(ctx, tx, next)
+}
+
+// quarantinedKeys returns the station, fuel and reason of the anomalies
+// recorded for date.
+func quarantinedKeys(ctx context.Context, tx *sql.Tx, date string) (map[[3]string]bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT ideess, fuel, reason FROM quarantine WHERE date = ?", date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quarantine for %s: %w", date, err)
+	}
+	defer rows.Close()
+
+	keys := make(map[[3]string]bool)
+	for rows.Next() {
+		var key [3]string
+		if err := rows.Scan(&key[0], &key[1], &key[2]); err != nil {
+			return nil, fmt.Errorf("error scanning quarantine: %w", err)
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// Anomalies returns the quarantined prices and stations matching q, by date
+// and station.
+func (s *Storage) Anomalies(ctx context.Context, q AnomalyQuery) ([]Anomaly, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !q.From.IsZero() {
+		fromStr = formatDate(q.From)
+	}
+	if !q.To.IsZero() {
+		toStr = formatDate(q.To)
+	}
+
+	query := `
+		SELECT date, ideess, fuel, reason, value, expected, detail, dismissed
+		FROM quarantine
+		WHERE date BETWEEN ? AND ?`
+	args := []any{fromStr, toStr}
+	if q.IDEESS != "" {
+		query += " AND ideess = ?"
+		args = append(args, q.IDEESS)
+	}
+	if q.Reason != "" {
+		query += " AND reason = ?"
+		args = append(args, string(q.Reason))
+	}
+	if !q.Dismissed {
+		query += " AND NOT dismissed"
+	}
+	query += " ORDER BY date, ideess, fuel, reason"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	var anomalies []Anomaly
+	for rows.Next() {
+		var a Anomaly
+		var fuel, reason string
+		if err := rows.Scan(&a.Date, &a.IDEESS, &fuel, &reason, &a.Value, &a.Expected, &a.Detail, &a.Dismissed); err != nil {
+			return nil, fmt.Errorf("error scanning anomaly: %w", err)
+		}
+		a.Fuel, a.Reason = Fuel(fuel), AnomalyReason(reason)
+		anomalies = append(anomalies, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating anomalies: %w", err)
+	}
+	return anomalies, nil
+}
+
+// DismissAnomalies marks the anomalies of a station on date as reviewed, so
+// its prices are shown again. An empty fuel dismisses every fuel.
+func (s *Storage) DismissAnomalies(ctx context.Context, date time.Time, ideess string, fuel Fuel) (int64, error) {
+	query := "UPDATE quarantine SET dismissed = 1 WHERE date = ? AND ideess = ? AND NOT dismissed"
+	args := []any{formatDate(date), ideess}
+	if fuel != "" {
+		query += " AND fuel IN (?, '')"
+		args = append(args, string(fuel))
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error dismissing anomalies: %w", err)
+	}
+	dismissed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// The dismissed prices now count in the summaries of the day
+	if dismissed > 0 {
+		if err := s.UpdateAggregates(ctx, BusinessDate(date)); err != nil {
+			return dismissed, err
+		}
+	}
+	return dismissed, nil
+}
+
+type anomaliesKey struct{}
+
+// WithAnomalies returns a context that includes or hides the quarantined
+// prices and stations in the nearby searches made with it. They are hidden
+// by default.
+func WithAnomalies(ctx context.Context, include bool) context.Context {
+	return context.WithValue(ctx, anomaliesKey{}, include)
+}
+
+// hideAnomalies removes the quarantined stations of date from stations and
+// blanks their quarantined prices, so they are not ranked. An empty date is
+// the latest snapshot. stations is not modified.
+func (s *Storage) hideAnomalies(ctx context.Context, date string, stations []*api.GasStation) ([]*api.GasStation, error) {
+	if include, _ := ctx.Value(anomaliesKey{}).(bool); include {
+		return stations, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ideess, fuel FROM quarantine
+		WHERE date = COALESCE(NULLIF(?, ''), (SELECT MAX(date) FROM fuel_prices)) AND NOT dismissed
+	`, date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quarantine: %w", err)
+	}
+	defer rows.Close()
+
+	quarantined := make(map[string][]Fuel)
+	for rows.Next() {
+		var ideess, fuel string
+		if err := rows.Scan(&ideess, &fuel); err != nil {
+			return nil, fmt.Errorf("error scanning quarantine: %w", err)
+		}
+		quarantined[ideess] = append(quarantined[ideess], Fuel(fuel))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating quarantine: %w", err)
+	}
+	if len(quarantined) == 0 {
+		return stations, nil
+	}
+
+	visible := make([]*api.GasStation, 0, len(stations))
+	for _, station := range stations {
+		fuels, ok := quarantined[station.IDEESS]
+		if !ok {
+			visible = append(visible, station)
+			continue
+		}
+
+		if slices.Contains(fuels, "") {
+			continue
+		}
+		hidden := *station
+		for _, f := range fuelFields {
+			if slices.Contains(fuels, f.fuel) {
+				*f.field(&hidden) = ""
+			}
+		}
+		visible = append(visible, &hidden)
+	}
+	return visible, nil
+}
@@ -0,0 +1,165 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestAnomalies(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	save := func(date time.Time, stations []api.GasStation) {
+		t.Helper()
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, date, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	var stations []api.GasStation
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		stations = append(stations, testStation(id, "REPSOL", "1,459", "1,599"))
+	}
+	save(day1, stations)
+
+	stations[0].PrecioGasoleoA = "0,001"
+	stations[1].PrecioGasoleoA = "2,950"
+	// Moved to Barcelona
+	stations[2].Latitud, stations[2].Longitud = "41,385100", "2,173400"
+	// A price that moves a lot but stays close to the regional median
+	stations[3].PrecioGasolina95E5 = "2,199"
+	save(day2, stations)
+
+	anomalies, err := s.Anomalies(ctx, AnomalyQuery{})
+	if err != nil {
+		t.Fatalf("Anomalies() failed: %v", err)
+	}
+	got := make(map[string]AnomalyReason)
+	for _, a := range anomalies {
+		if a.Date != "2024-01-02" {
+			t.Errorf("unexpected anomaly on %s: %+v", a.Date, a)
+		}
+		got[a.IDEESS+"/"+string(a.Fuel)+"/"+string(a.Reason)] = a.Reason
+	}
+	for _, want := range []string{
+		"1/gasoleo_a/implausible_price",
+		"2/gasoleo_a/regional_outlier",
+		"2/gasoleo_a/price_jump",
+		"3//coordinates_jump",
+		"4/gasolina_95_e5/price_jump",
+	} {
+		if _, ok := got[want]; !ok {
+			t.Errorf("missing anomaly %s in %v", want, got)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("expected 5 anomalies, got %v", got)
+	}
+
+	// Quarantined stations and prices are hidden from nearby searches
+	nearby, err := s.NearbyPricesAt(ctx, day2, 40.4168, -3.7038, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := make(map[string]string)
+	for _, st := range nearby {
+		prices[st.IDEESS] = st.PrecioGasoleoA
+	}
+	if len(nearby) != 5 || prices["1"] != "" || prices["2"] != "" || prices["5"] != "1,459" {
+		t.Errorf("anomalies not hidden: %v", prices)
+	}
+	nearby, err = s.NearbyPricesAt(ctx, day2, 41.3851, 2.1734, 5000)
+	if err != nil || len(nearby) != 0 {
+		t.Errorf("a station with jumping coordinates was not hidden: %v, %v", nearby, err)
+	}
+	nearby, err = s.NearbyPrices(WithAnomalies(ctx, true), 40.4168, -3.7038, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nearby) != 5 || nearby[0].PrecioGasoleoA != "0,001" {
+		t.Errorf("anomalies hidden despite WithAnomalies: %+v", nearby[0])
+	}
+
+	latest, err := s.LatestStations(ctx, []string{"5", "1", "3"})
+	if err != nil {
+		t.Fatalf("LatestStations() failed: %v", err)
+	}
+	if len(latest) != 2 || latest[0].IDEESS != "5" || latest[1].IDEESS != "1" || latest[1].PrecioGasoleoA != "" {
+		t.Errorf("anomalies not hidden from the latest stations: %+v", latest)
+	}
+
+	// Quarantined prices are left out of the aggregates until dismissed
+	dieselDay2 := func() AggregateRow {
+		t.Helper()
+		rows, err := s.Aggregate(ctx, AggregateQuery{Fuel: FuelGasoleoA, From: day2, To: day2})
+		if err != nil || len(rows) != 1 {
+			t.Fatalf("Aggregate() = %+v, %v", rows, err)
+		}
+		return rows[0]
+	}
+	if row := dieselDay2(); row.Min != 1.459 || row.Max != 1.459 || row.Stations != 3 {
+		t.Errorf("quarantined prices aggregated: %+v", row)
+	}
+
+	// Dismissed anomalies survive a new ingestion of the day
+	dismissed, err := s.DismissAnomalies(ctx, day2, "2", FuelGasoleoA)
+	if err != nil || dismissed != 2 {
+		t.Fatalf("DismissAnomalies() = %d, %v", dismissed, err)
+	}
+	if row := dieselDay2(); row.Max != 2.95 || row.Stations != 4 {
+		t.Errorf("dismissed price not aggregated: %+v", row)
+	}
+	save(day2, stations)
+	anomalies, err = s.Anomalies(ctx, AnomalyQuery{IDEESS: "2"})
+	if err != nil || len(anomalies) != 0 {
+		t.Errorf("dismissed anomalies listed again: %+v, %v", anomalies, err)
+	}
+	anomalies, err = s.Anomalies(ctx, AnomalyQuery{IDEESS: "2", Dismissed: true})
+	if err != nil || len(anomalies) != 2 || !anomalies[0].Dismissed {
+		t.Errorf("unexpected dismissed anomalies: %+v, %v", anomalies, err)
+	}
+}
+
+func TestAnomaliesOutOfOrder(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := map[time.Time]string{day1: "1,459", day1.AddDate(0, 0, 1): "2,100", day1.AddDate(0, 0, 2): "2,100"}
+
+	// Backfill workers save the days as they complete, the jumps found must
+	// not depend on it
+	for _, order := range [][]int{{0, 1, 2}, {0, 2, 1}, {2, 1, 0}} {
+		ctx := context.Background()
+		s := newTestStorage(t)
+		for _, i := range order {
+			date := day1.AddDate(0, 0, i)
+			data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", days[date], "")}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SavePrices(ctx, date, data); err != nil {
+				t.Fatalf("SavePrices() failed: %v", err)
+			}
+		}
+
+		anomalies, err := s.Anomalies(ctx, AnomalyQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(anomalies) != 1 || anomalies[0].Date != "2024-01-02" || anomalies[0].Reason != AnomalyPriceJump {
+			t.Errorf("order %v: unexpected anomalies: %+v", order, anomalies)
+		}
+		rows, err := s.Aggregate(ctx, AggregateQuery{Fuel: FuelGasoleoA})
+		if err != nil || len(rows) != 2 || rows[1].Max != 2.1 {
+			t.Errorf("order %v: unexpected aggregates: %+v, %v", order, rows, err)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+const (
+	defaultBackfillConcurrency = 4
+	defaultBackfillRetries     = 2
+	defaultBackfillDelay       = 200 * time.Millisecond
+)
+
+// Checkpoint states recorded in backfill_checkpoints.
+const (
+	checkpointDone   = "done"
+	checkpointFailed = "failed"
+)
+
+// firstHistoricDate is the first day published by the ministry.
+var firstHistoricDate = time.Date(2007, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Fetcher fetches the snapshot published for a past day. api.FuelPriceAPI
+// implements it.
+type Fetcher interface {
+	FetchPricesForDate(date time.Time) (*api.GasStationList, error)
+}
+
+// BackfillOptions configures Backfill. Zero values use the defaults.
+type BackfillOptions struct {
+	// From and To bound the days fetched, from 2007-01-01 to yesterday by
+	// default.
+	From time.Time
+	To   time.Time
+	// OnlyMissing fetches exactly the days MissingDates reports, including
+	// days checkpointed as done whose data was deleted since.
+	OnlyMissing bool
+	// Concurrency is the number of days fetched in parallel.
+	Concurrency int
+	// Retries is the number of extra passes over the days that failed, 2 by
+	// default. A negative value disables them.
+	Retries int
+	// Delay is the pause of each worker between two requests.
+	Delay time.Duration
+	// Fetcher fetches the snapshots, api.NewFuelPriceAPI() by default.
+	Fetcher Fetcher
+	// Progress is called after every day is saved or fails.
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress reports the state of a running Backfill.
+type BackfillProgress struct {
+	// Date is the day just processed, and Err why it failed.
+	Date time.Time
+	Err  error
+	// Done and Failed count the days processed so far out of Total. A day
+	// failing more than once is only counted once it succeeds or runs out of
+	// retries.
+	Done    int
+	Failed  int
+	Total   int
+	Elapsed time.Duration
+	// ETA estimates the time left from the average pace so far.
+	ETA time.Duration
+}
+
+// BackfillReport summarizes a Backfill run.
+type BackfillReport struct {
+	// Skipped counts the days of the range that were not fetched because
+	// they are stored or checkpointed as done.
+	Skipped int
+	// Fetched lists the days saved, Failed the days still missing after
+	// every retry.
+	Fetched []time.Time
+	Failed  []time.Time
+}
+
+func (o BackfillOptions) withDefaults() BackfillOptions {
+	if o.From.IsZero() {
+		o.From = firstHistoricDate
+	}
+	if o.To.IsZero() {
+		o.To = Today().AddDate(0, 0, -1)
+	}
+	o.From = BusinessDate(o.From)
+	o.To = BusinessDate(o.To)
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultBackfillConcurrency
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	} else if o.Retries == 0 {
+		o.Retries = defaultBackfillRetries
+	}
+	if o.Delay == 0 {
+		o.Delay = defaultBackfillDelay
+	}
+	if o.Fetcher == nil {
+		o.Fetcher = api.NewFuelPriceAPI()
+	}
+	return o
+}
+
+// createBackfillTable creates backfill_checkpoints, which records the days
+// Backfill saved or failed to fetch, so an interrupted run resumes where it
+// stopped and days deleted later by retention are not fetched again.
+func (s *Storage) createBackfillTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS backfill_checkpoints (
+		date TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating backfill_checkpoints table: %w", err)
+	}
+	return nil
+}
+
+// checkpoint records the outcome of fetching date.
+func (s *Storage) checkpoint(ctx context.Context, date string, fetchErr error) error {
+	status, msg := checkpointDone, ""
+	if fetchErr != nil {
+		status, msg = checkpointFailed, fetchErr.Error()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO backfill_checkpoints (date, status, attempts, error, updated_at)
+		VALUES (?, ?, 1, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (date) DO UPDATE SET
+			status = excluded.status,
+			attempts = attempts + 1,
+			error = excluded.error,
+			updated_at = excluded.updated_at
+	`, date, status, msg)
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint for %s: %w", date, err)
+	}
+	return nil
+}
+
+// dateSet returns the dates returned by query.
+func (s *Storage) dateSet(ctx context.Context, query string, args ...any) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := make(map[string]bool)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		dates[date] = true
+	}
+	return dates, rows.Err()
+}
+
+// MissingDates returns the business dates between from and to, both
+// included, without any stored data. A day pruned by retention is not
+// missing: it still has its station_days rows, or a weekly_prices average
+// once downsampled. A zero from starts on 2007-01-01, the first day
+// published, and a zero to ends today.
+func (s *Storage) MissingDates(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	if from.IsZero() {
+		from = firstHistoricDate
+	}
+	if to.IsZero() {
+		to = Today()
+	}
+
+	stored, err := s.dateSet(ctx, "SELECT date FROM fuel_prices UNION SELECT DISTINCT date FROM station_days")
+	if err != nil {
+		return nil, fmt.Errorf("error querying stored days: %w", err)
+	}
+	weeks, err := s.dateSet(ctx, "SELECT DISTINCT week FROM weekly_prices")
+	if err != nil {
+		return nil, fmt.Errorf("error querying stored weeks: %w", err)
+	}
+
+	var missing []time.Time
+	for date := BusinessDate(from); !date.After(BusinessDate(to)); date = date.AddDate(0, 0, 1) {
+		monday := date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+		if !stored[date.Format("2006-01-02")] && !weeks[monday.Format("2006-01-02")] {
+			missing = append(missing, date)
+		}
+	}
+	return missing, nil
+}
+
+// fetchResult is a day fetched by a backfill worker.
+type fetchResult struct {
+	date time.Time
+	data []byte
+	err  error
+}
+
+// fetchDay fetches and encodes the snapshot of date.
+func fetchDay(fetcher Fetcher, date time.Time) ([]byte, error) {
+	prices, err := fetcher.FetchPricesForDate(date)
+	if err != nil {
+		return nil, err
+	}
+	if prices.ResultadoConsulta != api.ApiResultOK {
+		return nil, fmt.Errorf("API returned non-OK result: %s", prices.ResultadoConsulta)
+	}
+	data, err := json.Marshal(prices)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data: %w", err)
+	}
+	return data, nil
+}
+
+// Backfill fetches every missing day between opts.From and opts.To with a
+// pool of opts.Concurrency workers. Snapshots are saved by the calling
+// goroutine only, so SQLite sees a single writer. Each day is checkpointed as
+// done or failed as soon as it is processed, and failed days are retried in
+// up to opts.Retries further passes once every other day was fetched.
+// Backfilled days raise no watch alerts nor webhook events, even the last
+// day of a gap.
+//
+// Cancelling ctx stops the run; the days saved so far are kept and the
+// next run resumes with the rest.
+func (s *Storage) Backfill(ctx context.Context, opts BackfillOptions) (*BackfillReport, error) {
+	opts = opts.withDefaults()
+	report := &BackfillReport{}
+
+	// Stops the workers if saving fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	missing, err := s.MissingDates(ctx, opts.From, opts.To)
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[string]bool{}
+	if !opts.OnlyMissing {
+		done, err = s.dateSet(ctx, "SELECT date FROM backfill_checkpoints WHERE status = ?", checkpointDone)
+		if err != nil {
+			return nil, fmt.Errorf("error querying checkpoints: %w", err)
+		}
+	}
+
+	var pending []time.Time
+	for _, date := range missing {
+		if !done[date.Format("2006-01-02")] {
+			pending = append(pending, date)
+		}
+	}
+	days := max(int(opts.To.Sub(opts.From).Hours()/24)+1, 0)
+	report.Skipped = days - len(pending)
+
+	progress := BackfillProgress{Total: len(pending)}
+	start := time.Now()
+
+	for pass := 0; pass <= opts.Retries && len(pending) > 0; pass++ {
+		lastPass := pass == opts.Retries
+		var failed []time.Time
+
+		for result := range s.fetchDays(ctx, opts, pending) {
+			dateStr := result.date.Format("2006-01-02")
+			fetchErr := result.err
+			if fetchErr == nil {
+				fetchErr = s.savePrices(ctx, result.date, result.data, false)
+			}
+			if err := s.checkpoint(ctx, dateStr, fetchErr); err != nil {
+				return report, err
+			}
+
+			if fetchErr != nil {
+				s.log.Debug("Error backfilling day", "date", dateStr, "pass", pass, "error", fetchErr)
+				failed = append(failed, result.date)
+				if !lastPass {
+					continue
+				}
+				progress.Failed++
+			} else {
+				report.Fetched = append(report.Fetched, result.date)
+				progress.Done++
+			}
+
+			if opts.Progress != nil {
+				progress.Date, progress.Err = result.date, fetchErr
+				progress.Elapsed = time.Since(start)
+				processed := progress.Done + progress.Failed
+				progress.ETA = progress.Elapsed / time.Duration(processed) * time.Duration(progress.Total-processed)
+				opts.Progress(progress)
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		pending = failed
+	}
+
+	report.Failed = pending
+	s.log.Info("Backfill completed", "fetched", len(report.Fetched), "failed", len(report.Failed), "skipped", report.Skipped)
+	return report, nil
+}
+
+// fetchDays fetches dates with a pool of workers and streams the results.
+// The channel is closed once every date was fetched or ctx is cancelled.
+func (s *Storage) fetchDays(ctx context.Context, opts BackfillOptions, dates []time.Time) <-chan fetchResult {
+	jobs := make(chan time.Time)
+	results := make(chan fetchResult)
+
+	go func() {
+		defer close(jobs)
+		for _, date := range dates {
+			select {
+			case jobs <- date:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(opts.Concurrency, len(dates)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for date := range jobs {
+				data, err := fetchDay(opts.Fetcher, date)
+				select {
+				case results <- fetchResult{date: date, data: data, err: err}:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case <-time.After(opts.Delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
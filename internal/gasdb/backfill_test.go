@@ -0,0 +1,176 @@
+package gasdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// fakeFetcher serves testStation snapshots and fails the dates in failures
+// that many times.
+type fakeFetcher struct {
+	mu       sync.Mutex
+	failures map[string]int
+	calls    map[string]int
+}
+
+func (f *fakeFetcher) FetchPricesForDate(date time.Time) (*api.GasStationList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	day := date.Format("2006-01-02")
+	f.calls[day]++
+	if f.failures[day] > 0 {
+		f.failures[day]--
+		return nil, errors.New("unexpected status code: 503")
+	}
+	return &api.GasStationList{
+		ListaEESSPrecio:   []api.GasStation{testStation("1", "REPSOL", "1,459", "")},
+		ResultadoConsulta: api.ApiResultOK,
+	}, nil
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	fetcher := &fakeFetcher{
+		failures: map[string]int{"2024-01-03": 1, "2024-01-05": 100},
+		calls:    make(map[string]int),
+	}
+	var updates []BackfillProgress
+	opts := BackfillOptions{
+		From:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC),
+		Concurrency: 3,
+		Retries:     1,
+		Delay:       time.Millisecond,
+		Fetcher:     fetcher,
+		Progress:    func(p BackfillProgress) { updates = append(updates, p) },
+	}
+
+	report, err := s.Backfill(ctx, opts)
+	if err != nil {
+		t.Fatalf("Backfill() failed: %v", err)
+	}
+	if len(report.Fetched) != 5 || len(report.Failed) != 1 || report.Failed[0].Format("2006-01-02") != "2024-01-05" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if fetcher.calls["2024-01-03"] != 2 || fetcher.calls["2024-01-05"] != 2 || fetcher.calls["2024-01-01"] != 1 {
+		t.Errorf("unexpected fetches: %v", fetcher.calls)
+	}
+
+	if len(updates) != 6 {
+		t.Fatalf("expected 6 progress updates, got %d", len(updates))
+	}
+	last := updates[len(updates)-1]
+	if last.Done != 5 || last.Failed != 1 || last.Total != 6 || last.ETA != 0 {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+
+	var status string
+	var attempts int
+	err = s.db.QueryRow("SELECT status, attempts FROM backfill_checkpoints WHERE date = '2024-01-05'").Scan(&status, &attempts)
+	if err != nil || status != checkpointFailed || attempts != 2 {
+		t.Errorf("unexpected checkpoint: %s, %d, %v", status, attempts, err)
+	}
+
+	// Days deleted since are not fetched again
+	if _, err := s.db.Exec("DELETE FROM fuel_prices WHERE date = '2024-01-01'; DELETE FROM station_days WHERE date = '2024-01-01'"); err != nil {
+		t.Fatal(err)
+	}
+	fetcher.failures["2024-01-05"] = 0
+	opts.Progress = nil
+	report, err = s.Backfill(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 5 || len(report.Fetched) != 1 || len(report.Failed) != 0 {
+		t.Errorf("unexpected resumed report: %+v", report)
+	}
+	if fetcher.calls["2024-01-01"] != 1 {
+		t.Errorf("a day checkpointed as done was fetched again")
+	}
+}
+
+func TestMissingDatesOnlyMissing(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	fetcher := &fakeFetcher{calls: make(map[string]int)}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)
+	opts := BackfillOptions{From: from, To: to, Delay: time.Millisecond, Fetcher: fetcher}
+	if _, err := s.Backfill(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`
+		DELETE FROM fuel_prices WHERE date IN ('2024-01-02', '2024-01-03');
+		DELETE FROM station_days WHERE date IN ('2024-01-02', '2024-01-03');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := s.MissingDates(ctx, from, to)
+	if err != nil {
+		t.Fatalf("MissingDates() failed: %v", err)
+	}
+	if len(missing) != 2 || missing[0].Format("2006-01-02") != "2024-01-02" || missing[1].Format("2006-01-02") != "2024-01-03" {
+		t.Errorf("unexpected missing days: %v", missing)
+	}
+
+	opts.OnlyMissing = true
+	report, err := s.Backfill(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 2 || len(report.Fetched) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if fetcher.calls["2024-01-02"] != 2 || fetcher.calls["2024-01-01"] != 1 {
+		t.Errorf("unexpected fetches: %v", fetcher.calls)
+	}
+
+	missing, err = s.MissingDates(ctx, from, to)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("expected no missing days, got %v, %v", missing, err)
+	}
+}
+
+func TestMissingDatesAfterPrune(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	fetcher := &fakeFetcher{calls: make(map[string]int)}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)
+	opts := BackfillOptions{From: from, To: to, OnlyMissing: true, Delay: time.Millisecond, Fetcher: fetcher}
+	if _, err := s.Backfill(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pruned snapshots leave the daily rows, downsampled days their weekly
+	// averages, and neither is fetched again
+	for _, policy := range []RetentionPolicy{{Snapshots: 30}, {DailyPrices: 30}} {
+		if _, err := s.Prune(ctx, policy, false); err != nil {
+			t.Fatalf("Prune() failed: %v", err)
+		}
+		missing, err := s.MissingDates(ctx, from, to)
+		if err != nil || len(missing) != 0 {
+			t.Errorf("pruned days reported missing: %v, %v", missing, err)
+		}
+		report, err := s.Backfill(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Skipped != 4 || len(report.Fetched) != 0 {
+			t.Errorf("unexpected report after pruning %+v: %+v", policy, report)
+		}
+	}
+	if fetcher.calls["2024-01-01"] != 1 || fetcher.calls["2024-01-04"] != 1 {
+		t.Errorf("unexpected fetches: %v", fetcher.calls)
+	}
+}
@@ -0,0 +1,267 @@
+package gasdb
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ncruces/go-sqlite3/driver"
+)
+
+// archiveExt marks gzip-compressed backups.
+const archiveExt = ".gz"
+
+// BackupOption configures Backup.
+type BackupOption func(*backupOptions)
+
+type backupOptions struct {
+	from, to time.Time
+}
+
+// BackupRange only keeps the dated rows between from and to in the backup.
+// Zero times leave that end of the range open.
+func BackupRange(from, to time.Time) BackupOption {
+	return func(o *backupOptions) { o.from, o.to = from, to }
+}
+
+// Backup writes a consistent copy of the database to destPath with
+// VACUUM INTO, which is safe while other connections keep writing. A
+// destPath ending in .gz is written as a gzip archive. The copy is checked
+// with PRAGMA integrity_check before Backup returns.
+func (s *Storage) Backup(ctx context.Context, destPath string, opts ...BackupOption) error {
+	var o backupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup destination %s already exists", destPath)
+	}
+
+	dbPath := destPath
+	compress := strings.HasSuffix(destPath, archiveExt)
+	if compress {
+		tmp, err := os.CreateTemp(filepath.Dir(destPath), ".gasdb-backup-*.db")
+		if err != nil {
+			return fmt.Errorf("error creating temporary backup: %w", err)
+		}
+		tmp.Close()
+		dbPath = tmp.Name()
+		// VACUUM INTO refuses to overwrite a file
+		if err := os.Remove(dbPath); err != nil {
+			return fmt.Errorf("error preparing temporary backup: %w", err)
+		}
+		defer os.Remove(dbPath)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", dbPath); err != nil {
+		return fmt.Errorf("error copying database: %w", err)
+	}
+
+	if !o.from.IsZero() || !o.to.IsZero() {
+		if err := filterBackup(ctx, dbPath, o.from, o.to); err != nil {
+			os.Remove(dbPath)
+			return err
+		}
+	}
+
+	if err := VerifyDatabase(ctx, dbPath); err != nil {
+		os.Remove(dbPath)
+		return err
+	}
+
+	if compress {
+		if err := gzipFile(dbPath, destPath); err != nil {
+			return err
+		}
+	}
+
+	s.log.Info("Database backed up", "path", destPath)
+	return nil
+}
+
+// filterBackup deletes the dated rows outside from and to from a backup copy.
+func filterBackup(ctx context.Context, path string, from, to time.Time) error {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = from.Format("2006-01-02")
+	}
+	if !to.IsZero() {
+		toStr = to.Format("2006-01-02")
+	}
+
+	db, err := driver.Open("file:"+path, registerFunctions)
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer db.Close()
+
+	statements := []string{
+		"DELETE FROM fuel_prices WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM prices WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM station_days WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM stations WHERE valid_to < ?1 OR valid_from > ?2",
+		"DELETE FROM weekly_prices WHERE week NOT BETWEEN date(?1, 'weekday 0', '-6 days') AND ?2",
+		"DELETE FROM downsampled_days WHERE date NOT BETWEEN date(?1, 'weekday 0', '-6 days') AND ?2",
+		"DELETE FROM price_aggregates WHERE period NOT BETWEEN date(?1, 'start of month') AND ?2",
+		"DELETE FROM backfill_checkpoints WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM intraday_prices WHERE snapshot_id NOT IN (SELECT id FROM intraday_snapshots WHERE date BETWEEN ?1 AND ?2)",
+		"DELETE FROM intraday_snapshots WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM ingestion_stats WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM quarantine WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM station_events WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM alerts WHERE date NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM webhook_deliveries WHERE date(created_at) NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM location_logs WHERE date(last_search) NOT BETWEEN ?1 AND ?2",
+		"DELETE FROM location_log_events WHERE date(bucket) NOT BETWEEN ?1 AND ?2",
+	}
+	for _, stmt := range statements {
+		if _, err := db.ExecContext(ctx, stmt, fromStr, toStr); err != nil {
+			return fmt.Errorf("error filtering backup: %w", err)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM station_locations WHERE id NOT IN (SELECT id FROM stations)"); err != nil {
+		return fmt.Errorf("error filtering backup: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("error compacting backup: %w", err)
+	}
+	return nil
+}
+
+// VerifyDatabase runs PRAGMA integrity_check on the database at path.
+func VerifyDatabase(ctx context.Context, path string) error {
+	db, err := driver.Open("file:"+path+"?mode=ro", registerFunctions)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer db.Close()
+
+	return checkIntegrity(ctx, db)
+}
+
+// checkIntegrity returns an error listing the problems PRAGMA integrity_check reports.
+func checkIntegrity(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("error checking integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("error reading integrity check: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error checking integrity: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Restore replaces the database at dbPath with the backup at srcPath,
+// decompressing .gz archives. The backup is verified before anything is
+// replaced. No Storage may have dbPath open while it runs.
+func Restore(ctx context.Context, srcPath, dbPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".gasdb-restore-*.db")
+	if err != nil {
+		return fmt.Errorf("error creating temporary database: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer src.Close()
+
+	var r io.Reader = src
+	if strings.HasSuffix(srcPath, archiveExt) {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("error opening backup archive: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error copying backup: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing temporary database: %w", err)
+	}
+	// CreateTemp files are private, restore the usual database permissions
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return fmt.Errorf("error setting database permissions: %w", err)
+	}
+
+	if err := VerifyDatabase(ctx, tmpPath); err != nil {
+		return fmt.Errorf("backup %s is not usable: %w", srcPath, err)
+	}
+
+	// Stale WAL files would be replayed on top of the restored database
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing %s: %w", dbPath+suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return fmt.Errorf("error replacing database: %w", err)
+	}
+	return nil
+}
+
+// gzipFile compresses src into a new file at dest.
+func gzipFile(src, dest string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", dest, err)
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("error writing %s: %w", dest, cerr)
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return fmt.Errorf("error compressing backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error compressing backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package gasdb
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	// Rows of the other tables keyed by date, outside the backup range
+	for _, stmt := range []string{
+		"INSERT INTO backfill_checkpoints (date, status) VALUES ('2023-12-31', 'done')",
+		"INSERT INTO intraday_snapshots (id, fetched_at, date, hash, data) VALUES (100, '2023-12-31T10:00:00Z', '2023-12-31', 'h', x'00')",
+		"INSERT INTO intraday_prices (snapshot_id, ideess, fuel, price) VALUES (100, '1', 'gasoleo_a', 1.459)",
+		"INSERT INTO quarantine (date, ideess, fuel, reason, value, expected) VALUES ('2024-01-01', '1', 'gasoleo_a', 'price_jump', 2.1, 1.459)",
+		"INSERT OR IGNORE INTO station_events (date, ideess, kind) VALUES ('2024-01-01', '1', 'opened')",
+		"INSERT INTO alerts (rule_id, date, ideess, fuel, price, condition) VALUES (1, '2024-01-01', '1', 'gasoleo_a', 1.459, 'below')",
+		"INSERT INTO webhook_deliveries (webhook_id, event, payload, created_at) VALUES (1, 'snapshot', x'7b7d', '2024-01-01 10:00:00')",
+		"INSERT INTO location_logs (latitude, longitude, distance, search_time, last_search, geohash) VALUES (40.4, -3.7, 5000, '2024-01-01 09:00:00', '2024-01-01 09:00:00', 'ezjmg')",
+		"INSERT INTO location_log_events (geohash, bucket, latitude, longitude) VALUES ('ezjmg', '2024-01-01 09:00:00', 40.4, -3.7)",
+		"INSERT INTO downsampled_days (date) VALUES ('2023-12-24')",
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	day2, _ := time.Parse("2006-01-02", "2024-01-02")
+	archive := filepath.Join(dir, "backup.db.gz")
+	if err := s.Backup(ctx, archive, BackupRange(day2, time.Time{})); err != nil {
+		t.Fatalf("Backup() failed: %v", err)
+	}
+	if err := s.Backup(ctx, archive); err == nil {
+		t.Error("Backup() overwrote an existing file")
+	}
+
+	dbPath := filepath.Join(dir, "restored.db")
+	if err := Restore(ctx, archive, dbPath); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+
+	// Keep the retention purge from hiding location logs the backup kept
+	restored, err := NewStorage(ctx, dbPath, slog.New(slog.DiscardHandler),
+		WithLocationLogging(LocationLogPolicy{Retention: 100 * 365 * 24 * time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	dates, err := restored.GetAllDates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dates) != 1 || !dates[0].Equal(day2) {
+		t.Errorf("expected only 2024-01-02 in the backup, got %v", dates)
+	}
+
+	rows := historicRows(t, restored.db)
+	if want := expectedHistoricRows()[2:]; len(rows) != len(want) {
+		t.Errorf("expected %d historic rows, got %d", len(want), len(rows))
+	}
+
+	for _, query := range []string{
+		"SELECT COUNT(*) FROM backfill_checkpoints WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM intraday_snapshots WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM intraday_prices WHERE snapshot_id = 100",
+		"SELECT COUNT(*) FROM ingestion_stats WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM quarantine WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM station_events WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM alerts WHERE date < '2024-01-02'",
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE created_at < '2024-01-02'",
+		"SELECT COUNT(*) FROM location_logs WHERE last_search < '2024-01-02'",
+		"SELECT COUNT(*) FROM location_log_events WHERE bucket < '2024-01-02'",
+		"SELECT COUNT(*) FROM downsampled_days WHERE date < '2024-01-01'",
+	} {
+		var n int
+		if err := restored.db.QueryRow(query).Scan(&n); err != nil || n != 0 {
+			t.Errorf("%s: %d rows outside the range were kept: %v", query, n, err)
+		}
+	}
+
+	var cepsa int
+	if err := restored.db.QueryRow("SELECT COUNT(*) FROM stations WHERE rotulo = 'CEPSA'").Scan(&cepsa); err != nil || cepsa != 0 {
+		t.Errorf("station versions outside the range were kept: %d, %v", cepsa, err)
+	}
+}
+
+func TestRestoreRejectsCorruptBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(src, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, src, filepath.Join(dir, "target.db")); err == nil {
+		t.Error("Restore() accepted a corrupt backup")
+	}
+}
@@ -0,0 +1,42 @@
+package gasdb
+
+import (
+	"time"
+
+	// Embeds the timezone database, hosts without one would fail to load
+	// Europe/Madrid
+	_ "time/tzdata"
+)
+
+// BusinessLocation is the timezone the ministry publishes prices in. A
+// snapshot belongs to the calendar day it was taken on in Madrid, whatever
+// the timezone of the host.
+var BusinessLocation = mustLoadLocation("Europe/Madrid")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// BusinessDate returns the Madrid calendar day of the instant t, as midnight
+// UTC like every date read from the database. Dates already at midnight UTC,
+// such as the ones parsed from YYYY-MM-DD, are returned unchanged since
+// Madrid is always ahead of UTC.
+func BusinessDate(t time.Time) time.Time {
+	t = t.In(BusinessLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Today returns the current business date.
+func Today() time.Time {
+	return BusinessDate(time.Now())
+}
+
+// formatDate returns the YYYY-MM-DD business date of t, the key snapshots
+// are stored under.
+func formatDate(t time.Time) string {
+	return BusinessDate(t).Format("2006-01-02")
+}
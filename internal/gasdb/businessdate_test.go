@@ -0,0 +1,84 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestBusinessDate(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"date at midnight UTC", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), "2024-01-02"},
+		{"before midnight in winter", time.Date(2024, 1, 1, 22, 59, 0, 0, time.UTC), "2024-01-01"},
+		{"after midnight in winter", time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC), "2024-01-02"},
+		{"before midnight in summer", time.Date(2024, 7, 1, 21, 59, 0, 0, time.UTC), "2024-07-01"},
+		{"after midnight in summer", time.Date(2024, 7, 1, 22, 0, 0, 0, time.UTC), "2024-07-02"},
+		{"midnight before DST starts", time.Date(2024, 3, 30, 23, 0, 0, 0, time.UTC), "2024-03-31"},
+		{"skipped hour when DST starts", time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), "2024-03-31"},
+		{"midnight before DST ends", time.Date(2024, 10, 26, 22, 0, 0, 0, time.UTC), "2024-10-27"},
+		{"repeated hour when DST ends", time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), "2024-10-27"},
+		{"midnight after DST ends", time.Date(2024, 10, 27, 23, 0, 0, 0, time.UTC), "2024-10-28"},
+		{"evening west of Madrid", time.Date(2024, 1, 1, 20, 0, 0, 0, newYork), "2024-01-02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BusinessDate(tt.t)
+			if got.Format("2006-01-02") != tt.want || got.Location() != time.UTC || got.Hour() != 0 {
+				t.Errorf("BusinessDate(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSavePricesBusinessDate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", "1,459", "")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half past midnight in Madrid is still the previous day in UTC
+	if err := s.SavePrices(ctx, time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC), data); err != nil {
+		t.Fatal(err)
+	}
+
+	dates, err := s.GetAllDates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if len(dates) != 1 || !dates[0].Equal(want) {
+		t.Fatalf("unexpected dates: %v", dates)
+	}
+
+	for _, date := range []time.Time{want, time.Date(2024, 1, 2, 12, 0, 0, 0, BusinessLocation)} {
+		ok, err := s.HasDate(ctx, date)
+		if err != nil || !ok {
+			t.Errorf("HasDate(%s) = %v, %v", date, ok, err)
+		}
+	}
+	if ok, _ := s.HasDate(ctx, time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("the snapshot was stored under the UTC date")
+	}
+
+	missing, err := s.MissingDates(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].Format("2006-01-02") != "2024-01-01" {
+		t.Errorf("unexpected missing days: %v", missing)
+	}
+}
@@ -0,0 +1,229 @@
+package gasdb
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheEntries = 1024
+	// defaultSnapshotSlots bounds the parsed snapshots cached at once: the
+	// latest one and a few past days.
+	defaultSnapshotSlots = 4
+	defaultSnapshotTTL   = 10 * time.Minute
+	defaultQueryTTL      = 10 * time.Minute
+	// latestGeneration is the generation namespace of entries derived from
+	// whatever snapshot is currently the most recent one.
+	latestGeneration = "latest"
+)
+
+// Cache stores query results, or parsed snapshots in the Storage's own small
+// snapshot cache. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (any, bool)
+	// Set stores value under key. A zero ttl never expires.
+	Set(key string, value any, ttl time.Duration)
+	Delete(key string)
+	Purge()
+	Stats() CacheStats
+}
+
+// CacheStats reports cache usage counters.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// HitRatio returns the fraction of lookups served from the cache.
+func (cs CacheStats) HitRatio() float64 {
+	if cs.Hits+cs.Misses == 0 {
+		return 0
+	}
+	return float64(cs.Hits) / float64(cs.Hits+cs.Misses)
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// LRUCache is a Cache holding at most a fixed number of entries, evicting
+// the least recently used one when full.
+type LRUCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	stats      CacheStats
+	now        func() time.Time
+}
+
+// NewLRUCache returns an LRUCache holding up to maxEntries entries.
+func NewLRUCache(maxEntries int) *LRUCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &LRUCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+func (c *LRUCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && c.now().After(entry.expires) {
+		c.removeElement(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.now().Add(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *LRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.ll.Len()
+	return stats
+}
+
+func (c *LRUCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
+
+// WithCache replaces the default LRU cache of query results.
+func WithCache(c Cache) Option {
+	return func(o *options) { o.cache = c }
+}
+
+// WithCacheSize sets the maximum number of entries of the default LRU cache
+// of query results.
+func WithCacheSize(entries int) Option {
+	return func(o *options) { o.cacheSize = entries }
+}
+
+// WithSnapshotCacheSize sets how many parsed snapshots are cached at once,
+// 4 by default. Each one holds every station of a day.
+func WithSnapshotCacheSize(snapshots int) Option {
+	return func(o *options) { o.snapshotSlots = snapshots }
+}
+
+// WithSnapshotTTL sets how long parsed snapshots stay cached.
+func WithSnapshotTTL(ttl time.Duration) Option {
+	return func(o *options) { o.snapshotTTL = ttl }
+}
+
+// WithQueryTTL sets how long nearby query results stay cached.
+func WithQueryTTL(ttl time.Duration) Option {
+	return func(o *options) { o.queryTTL = ttl }
+}
+
+// generations tracks a counter per snapshot date. Cache keys embed the
+// counter of the snapshot they were derived from, so saving a snapshot only
+// orphans the entries derived from it, which then age out of the LRU.
+type generations struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (g *generations) get(name string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.counts[name]
+}
+
+func (g *generations) bump(names ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.counts == nil {
+		g.counts = make(map[string]uint64)
+	}
+	for _, name := range names {
+		g.counts[name]++
+	}
+}
+
+// cacheKey builds a key for an entry derived from the snapshot named by generation.
+func (s *Storage) cacheKey(generation, kind string, parts ...any) string {
+	key := fmt.Sprintf("%s:%s@%d", kind, generation, s.generations.get(generation))
+	for _, p := range parts {
+		key += fmt.Sprintf(":%v", p)
+	}
+	return key
+}
+
+// invalidateSnapshot orphans every cache entry derived from the snapshot of
+// dateStr or from the latest snapshot, which a save may have changed.
+func (s *Storage) invalidateSnapshot(dateStr string) {
+	s.generations.bump(dateStr, latestGeneration)
+}
+
+// CacheStats returns the hit and miss counters of the Storage caches, query
+// results and snapshots combined.
+func (s *Storage) CacheStats() CacheStats {
+	stats, snapshots := s.cache.Stats(), s.snapshots.Stats()
+	stats.Hits += snapshots.Hits
+	stats.Misses += snapshots.Misses
+	stats.Evictions += snapshots.Evictions
+	stats.Entries += snapshots.Entries
+	return stats
+}
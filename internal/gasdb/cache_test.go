@@ -0,0 +1,119 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1, 0)
+	c.Set("b", 2, time.Minute)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// b is now the least recently used entry
+	c.Set("c", 3, 0)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v.(int) != 3 {
+		t.Errorf("Get(c) = %v, %v", v, ok)
+	}
+
+	c.Set("d", 4, time.Minute)
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("d"); ok {
+		t.Error("expected d to be expired")
+	}
+
+	stats := c.Stats()
+	want := CacheStats{Hits: 2, Misses: 2, Evictions: 2, Entries: 1}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestSavePricesInvalidatesDerivedEntries(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	day1, _ := time.Parse("2006-01-02", "2024-01-01")
+	day2, _ := time.Parse("2006-01-02", "2024-01-02")
+	lat, lng := 40.4168, -3.7038
+
+	query := func() {
+		t.Helper()
+		if _, err := s.NearbyPricesAt(ctx, day1, lat, lng, 1000); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.NearbyPrices(ctx, lat, lng, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query()
+	before := s.CacheStats()
+	query()
+	if hits := s.CacheStats().Hits - before.Hits; hits != 2 {
+		t.Fatalf("expected 2 cache hits on repeated queries, got %d", hits)
+	}
+
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: testSnapshots()["2024-01-02"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, day2, data); err != nil {
+		t.Fatal(err)
+	}
+
+	before = s.CacheStats()
+	query()
+	after := s.CacheStats()
+	if hits := after.Hits - before.Hits; hits != 1 {
+		t.Errorf("expected only the 2024-01-01 query to stay cached, got %d hits", hits)
+	}
+}
+
+func TestSnapshotCacheSize(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler),
+		WithSnapshotCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", "1,459", "")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		if err := s.SavePrices(ctx, day.AddDate(0, 0, i), data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.NearbyPricesAt(ctx, day.AddDate(0, 0, i), 40.4168, -3.7038, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the snapshots are evicted, the query results of every day stay
+	if stats := s.snapshots.Stats(); stats.Entries != 2 || stats.Evictions != 3 {
+		t.Errorf("unexpected snapshot cache stats: %+v", stats)
+	}
+	if stats := s.cache.Stats(); stats.Entries != 5 || stats.Evictions != 0 {
+		t.Errorf("unexpected query cache stats: %+v", stats)
+	}
+}
@@ -0,0 +1,152 @@
+package gasdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/ncruces/go-sqlite3"
+)
+
+// Codecs recorded in fuel_prices.codec.
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+)
+
+// decodeFunctionName is the SQL function that returns the JSON text of a
+// stored snapshot, so triggers and queries can read compressed blobs.
+const decodeFunctionName = "gasdb_decode"
+
+// encodeSnapshot compresses a raw JSON snapshot for storage.
+func encodeSnapshot(data []byte) ([]byte, string, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating gzip writer: %w", err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, "", fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	return buf.Bytes(), CodecGzip, nil
+}
+
+// decodeSnapshot returns the raw JSON of a stored snapshot.
+func decodeSnapshot(data []byte, codec string) ([]byte, error) {
+	switch codec {
+	case CodecNone, "":
+		return data, nil
+	case CodecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error opening gzip snapshot: %w", err)
+		}
+		defer zr.Close()
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing snapshot: %w", err)
+		}
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot codec %q", codec)
+	}
+}
+
+// registerFunctions registers the gasdb SQL functions on a new connection.
+func registerFunctions(conn *sqlite3.Conn) error {
+	return conn.CreateFunction(decodeFunctionName, 2, sqlite3.DETERMINISTIC|sqlite3.INNOCUOUS,
+		func(ctx sqlite3.Context, arg ...sqlite3.Value) {
+			raw, err := decodeSnapshot(arg[0].RawBlob(), arg[1].Text())
+			if err != nil {
+				ctx.ResultError(err)
+				return
+			}
+			ctx.ResultRawText(raw)
+		})
+}
+
+// migrateSnapshotCodec adds the codec column to fuel_prices tables created
+// before snapshots were compressed. Existing rows are marked as uncompressed.
+func migrateSnapshotCodec(ctx context.Context, db *sql.DB) error {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('fuel_prices') WHERE name = 'codec'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error inspecting fuel_prices: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE fuel_prices ADD COLUMN codec TEXT NOT NULL DEFAULT '%s'", CodecNone))
+	if err != nil {
+		return fmt.Errorf("error adding codec column: %w", err)
+	}
+	return nil
+}
+
+// CompactStats reports the outcome of CompactSnapshots.
+type CompactStats struct {
+	Rows        int
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+// CompactSnapshots compresses every fuel_prices row still stored
+// uncompressed. Rows are rewritten one at a time with UPDATE, so the
+// ingestion trigger does not fire again.
+func (s *Storage) CompactSnapshots(ctx context.Context) (*CompactStats, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM fuel_prices WHERE codec = ? ORDER BY id", CodecNone)
+	if err != nil {
+		return nil, fmt.Errorf("error querying uncompressed snapshots: %w", err)
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot ids: %w", err)
+	}
+
+	stats := &CompactStats{}
+	for _, id := range ids {
+		var data []byte
+		if err := s.db.QueryRowContext(ctx, "SELECT data FROM fuel_prices WHERE id = ?", id).Scan(&data); err != nil {
+			return stats, fmt.Errorf("error reading snapshot %d: %w", id, err)
+		}
+
+		encoded, codec, err := encodeSnapshot(data)
+		if err != nil {
+			return stats, err
+		}
+
+		_, err = s.db.ExecContext(ctx, "UPDATE fuel_prices SET data = ?, codec = ? WHERE id = ?", encoded, codec, id)
+		if err != nil {
+			return stats, fmt.Errorf("error updating snapshot %d: %w", id, err)
+		}
+
+		stats.Rows++
+		stats.BytesBefore += int64(len(data))
+		stats.BytesAfter += int64(len(encoded))
+		s.log.Debug("Compacted snapshot", "id", id, "before", len(data), "after", len(encoded))
+	}
+
+	return stats, nil
+}
+
+// scanIDs reads a single integer column and closes rows.
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,66 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestCompactSnapshots(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	list := api.GasStationList{
+		ListaEESSPrecio:   testSnapshots()["2024-01-01"],
+		ResultadoConsulta: api.ApiResultOK,
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written before compression existed are stored as plain JSON.
+	_, err = s.db.ExecContext(ctx, "INSERT INTO fuel_prices (date, data, codec) VALUES (?, ?, ?)", "2024-01-01", data, CodecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows inserted with SQL are only ingested by a migration
+	if err := s.MigrateToHistoricPrices(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.CompactSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("CompactSnapshots() failed: %v", err)
+	}
+	if stats.Rows != 1 || stats.BytesAfter >= stats.BytesBefore {
+		t.Errorf("unexpected compaction stats: %+v", stats)
+	}
+
+	var codec string
+	if err := s.db.QueryRowContext(ctx, "SELECT codec FROM fuel_prices WHERE date = '2024-01-01'").Scan(&codec); err != nil {
+		t.Fatal(err)
+	}
+	if codec != CodecGzip {
+		t.Errorf("expected codec %q, got %q", CodecGzip, codec)
+	}
+
+	prices, err := s.GetPrices(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetPrices() failed: %v", err)
+	}
+	if len(prices.ListaEESSPrecio) != len(list.ListaEESSPrecio) {
+		t.Errorf("expected %d stations, got %d", len(list.ListaEESSPrecio), len(prices.ListaEESSPrecio))
+	}
+
+	var ingested int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM historic_prices WHERE date = '2024-01-01'").Scan(&ingested); err != nil {
+		t.Fatal(err)
+	}
+	if ingested != len(list.ListaEESSPrecio) {
+		t.Errorf("expected %d historic_prices rows, got %d", len(list.ListaEESSPrecio), ingested)
+	}
+}
@@ -0,0 +1,326 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// ProblemKind classifies the problems found by Doctor.
+type ProblemKind string
+
+const (
+	// ProblemIntegrity is a failed PRAGMA integrity_check.
+	ProblemIntegrity ProblemKind = "integrity"
+	// ProblemDerivedRows means the station_days or prices rows of a day do
+	// not match its snapshot.
+	ProblemDerivedRows ProblemKind = "derived_rows"
+	// ProblemCorruptSnapshot is a snapshot that cannot be decoded.
+	ProblemCorruptSnapshot ProblemKind = "corrupt_snapshot"
+	// ProblemDuplicateStation is a station listed more than once in a snapshot.
+	ProblemDuplicateStation ProblemKind = "duplicate_station"
+	// ProblemImpossiblePrice is a stored price outside the plausible range.
+	ProblemImpossiblePrice ProblemKind = "impossible_price"
+)
+
+// Plausible prices in euros per litre, or per kilogram for hydrogen.
+const (
+	minPlausiblePrice         = 0.1
+	maxPlausiblePrice         = 5.0
+	maxPlausibleHydrogenPrice = 30.0
+)
+
+// Problem is an inconsistency found by Doctor.
+type Problem struct {
+	Kind   ProblemKind
+	Date   string
+	IDEESS string
+	Detail string
+	// Repairable is set when Doctor can fix the problem with repair enabled.
+	Repairable bool
+}
+
+// DoctorReport lists the problems found by Doctor and the repairs made.
+type DoctorReport struct {
+	Snapshots int
+	Problems  []Problem
+	// Repaired lists the dates whose derived rows were rebuilt.
+	Repaired []string
+}
+
+// Doctor checks the database for corruption and for derived rows that no
+// longer match the fuel_prices snapshots they come from. With repair set,
+// the station_days, prices and price_aggregates rows of every mismatched day
+// are written again from its snapshot. Quarantined anomalies, station events
+// and ingestion stats are not derived again. Corrupt snapshots, duplicate
+// stations and impossible prices are only reported.
+//
+// Days whose daily rows were downsampled into weekly_prices by Prune are
+// expected to have no derived rows and are not reported.
+func (s *Storage) Doctor(ctx context.Context, repair bool) (*DoctorReport, error) {
+	report := &DoctorReport{}
+
+	if err := checkIntegrity(ctx, s.db); err != nil {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemIntegrity, Detail: err.Error()})
+	}
+
+	mismatched, err := s.checkSnapshots(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPrices(ctx, report); err != nil {
+		return nil, err
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, date := range mismatched {
+		if err := s.rederiveDay(ctx, date); err != nil {
+			return report, fmt.Errorf("error repairing %s: %w", date, err)
+		}
+		report.Repaired = append(report.Repaired, date)
+		s.log.Info("Rebuilt derived rows", "date", date)
+	}
+
+	return report, nil
+}
+
+// dateCounts returns the number of rows per date returned by query.
+func (s *Storage) dateCounts(ctx context.Context, query string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var date string
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			return nil, err
+		}
+		counts[date] = count
+	}
+	return counts, rows.Err()
+}
+
+// checkSnapshots decodes every snapshot and compares it with the rows
+// derived from it, returning the dates whose rows do not match.
+func (s *Storage) checkSnapshots(ctx context.Context, report *DoctorReport) ([]string, error) {
+	dayCounts, err := s.dateCounts(ctx, "SELECT date, COUNT(*) FROM station_days GROUP BY date")
+	if err != nil {
+		return nil, fmt.Errorf("error counting station days: %w", err)
+	}
+	priceCounts, err := s.dateCounts(ctx, "SELECT date, COUNT(*) FROM prices GROUP BY date")
+	if err != nil {
+		return nil, fmt.Errorf("error counting prices: %w", err)
+	}
+	downsampled, err := s.dateCounts(ctx, `
+		SELECT f.date, 1 FROM fuel_prices f
+		WHERE EXISTS (SELECT 1 FROM weekly_prices w WHERE w.week = date(f.date, 'weekday 0', '-6 days'))`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying downsampled days: %w", err)
+	}
+
+	dates, err := s.snapshotDates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatched []string
+	for _, date := range dates {
+		report.Snapshots++
+
+		stations, err := s.loadSnapshot(ctx, date)
+		if err != nil {
+			report.Problems = append(report.Problems, Problem{Kind: ProblemCorruptSnapshot, Date: date, Detail: err.Error()})
+			continue
+		}
+
+		// Stations rejected by the validators are not expected to be stored
+		accepted, _ := s.prepare(date, stations)
+		seen := make(map[string]*api.GasStation, len(accepted))
+		for _, station := range accepted {
+			if _, ok := seen[station.IDEESS]; ok {
+				report.Problems = append(report.Problems, Problem{
+					Kind:   ProblemDuplicateStation,
+					Date:   date,
+					IDEESS: station.IDEESS,
+					Detail: "station listed more than once in the snapshot",
+				})
+			}
+			// The last listing wins, as it does when the rows are derived
+			seen[station.IDEESS] = station
+		}
+
+		wantPrices := 0
+		for _, station := range seen {
+			for _, f := range fuelFields {
+				if _, ok := parsePrice(f.get(station)); ok {
+					wantPrices++
+				}
+			}
+		}
+
+		gotDays, gotPrices := dayCounts[date], priceCounts[date]
+		if gotDays == 0 && gotPrices == 0 && downsampled[date] > 0 {
+			continue
+		}
+		if gotDays != len(seen) || gotPrices != wantPrices {
+			report.Problems = append(report.Problems, Problem{
+				Kind: ProblemDerivedRows,
+				Date: date,
+				Detail: fmt.Sprintf("%d station days and %d prices, snapshot has %d stations and %d prices",
+					gotDays, gotPrices, len(seen), wantPrices),
+				Repairable: true,
+			})
+			mismatched = append(mismatched, date)
+		}
+	}
+
+	return mismatched, nil
+}
+
+// snapshotDates returns the date of every stored snapshot in order.
+func (s *Storage) snapshotDates(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT date FROM fuel_prices ORDER BY date")
+	if err != nil {
+		return nil, fmt.Errorf("error querying snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, fmt.Errorf("error scanning snapshot date: %w", err)
+		}
+		dates = append(dates, date)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating snapshots: %w", err)
+	}
+	return dates, nil
+}
+
+// loadSnapshot decodes the stations of the snapshot stored for date.
+func (s *Storage) loadSnapshot(ctx context.Context, date string) ([]api.GasStation, error) {
+	var blob []byte
+	var codec string
+	err := s.db.QueryRowContext(ctx, "SELECT data, codec FROM fuel_prices WHERE date = ?", date).Scan(&blob, &codec)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot: %w", err)
+	}
+
+	jsonData, err := decodeSnapshot(blob, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	var list api.GasStationList
+	if err := json.Unmarshal(jsonData, &list); err != nil {
+		return nil, fmt.Errorf("error unmarshaling data: %w", err)
+	}
+	return list.ListaEESSPrecio, nil
+}
+
+// parsePrice parses a price as published by the ministry, with a decimal
+// comma. Empty and malformed values are reported as missing.
+func parsePrice(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	price, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil {
+		return 0, false
+	}
+	return price, true
+}
+
+// checkPrices reports the stored prices outside the plausible range.
+func (s *Storage) checkPrices(ctx context.Context, report *DoctorReport) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date, ideess, fuel, price FROM prices
+		WHERE price < ? OR price > CASE fuel WHEN ? THEN ? ELSE ? END
+		ORDER BY date, ideess, fuel
+	`, minPlausiblePrice, string(FuelHidrogeno), maxPlausibleHydrogenPrice, maxPlausiblePrice)
+	if err != nil {
+		return fmt.Errorf("error querying impossible prices: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var date, ideess, fuel string
+		var price float64
+		if err := rows.Scan(&date, &ideess, &fuel, &price); err != nil {
+			return fmt.Errorf("error scanning price: %w", err)
+		}
+		report.Problems = append(report.Problems, Problem{
+			Kind:   ProblemImpossiblePrice,
+			Date:   date,
+			IDEESS: ideess,
+			Detail: fmt.Sprintf("%s costs %.3f", fuel, price),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating impossible prices: %w", err)
+	}
+	return nil
+}
+
+// rederiveDay writes the stations of the snapshot of date again, rebuilding
+// its station_days, prices and price_aggregates rows. Unlike an ingestion, it
+// leaves the quarantine, station events and ingestion stats alone, as well as
+// the rows of the next day they depend on.
+func (s *Storage) rederiveDay(ctx context.Context, date string) error {
+	stations, err := s.loadSnapshot(ctx, date)
+	if err != nil {
+		return err
+	}
+	accepted, _ := s.prepare(date, stations)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	writer, err := newSnapshotWriter(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	if _, err := writer.writeDay(ctx, date, accepted); err != nil {
+		return err
+	}
+
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("error parsing date %s: %w", date, err)
+	}
+	if err := updateAggregates(ctx, tx, day); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	s.invalidateSnapshot(date)
+	return nil
+}
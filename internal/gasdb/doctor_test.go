@@ -0,0 +1,139 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestDoctor(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	report, err := s.Doctor(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Snapshots != 2 || len(report.Problems) != 0 {
+		t.Fatalf("expected a clean report, got %+v", report)
+	}
+
+	statements := []string{
+		"DELETE FROM prices WHERE date = '2024-01-01' AND ideess = '1'",
+		"DELETE FROM station_days WHERE date = '2024-01-01' AND ideess = '2'",
+		"UPDATE prices SET price = 145.9 WHERE date = '2024-01-02' AND ideess = '2' AND fuel = 'gasoleo_a'",
+		"INSERT INTO fuel_prices (date, data, codec) VALUES ('2024-01-03', x'00', 'gzip')",
+	}
+	for _, stmt := range statements {
+		if _, err := s.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	report, err = s.Doctor(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[ProblemKind][]Problem)
+	for _, p := range report.Problems {
+		kinds[p.Kind] = append(kinds[p.Kind], p)
+	}
+	if p := kinds[ProblemDerivedRows]; len(p) != 1 || p[0].Date != "2024-01-01" || !p[0].Repairable {
+		t.Errorf("unexpected derived rows problems: %+v", p)
+	}
+	if p := kinds[ProblemImpossiblePrice]; len(p) != 1 || p[0].IDEESS != "2" {
+		t.Errorf("unexpected impossible prices: %+v", p)
+	}
+	if p := kinds[ProblemCorruptSnapshot]; len(p) != 1 || p[0].Date != "2024-01-03" {
+		t.Errorf("unexpected corrupt snapshots: %+v", p)
+	}
+	if len(report.Repaired) != 0 {
+		t.Errorf("check without repair modified %v", report.Repaired)
+	}
+
+	// A repair only rebuilds the daily rows and aggregates of the day
+	derived := func() string {
+		t.Helper()
+		var state string
+		err := s.db.QueryRow(`
+			SELECT COALESCE((SELECT group_concat(date || ideess || reason) FROM quarantine), '') || ';' ||
+				COALESCE((SELECT group_concat(date || ingested_at) FROM ingestion_stats), '') || ';' ||
+				COALESCE((SELECT group_concat(date || ideess || kind) FROM station_events), '')`).Scan(&state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+	if _, err := s.db.Exec("INSERT INTO quarantine (date, ideess, fuel, reason, value, expected) VALUES ('2024-01-01', '1', 'gasoleo_a', 'price_jump', 1.459, 1.2)"); err != nil {
+		t.Fatal(err)
+	}
+	before := derived()
+
+	report, err = s.Doctor(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Repaired) != 1 || report.Repaired[0] != "2024-01-01" {
+		t.Errorf("unexpected repairs: %+v", report)
+	}
+	if after := derived(); after != before {
+		t.Errorf("repair derived anomalies, events or stats again\n got: %s\nwant: %s", after, before)
+	}
+	if _, err := s.db.Exec("DELETE FROM quarantine"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM fuel_prices WHERE date = '2024-01-03'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("UPDATE prices SET price = 1.469 WHERE date = '2024-01-02' AND ideess = '2' AND fuel = 'gasoleo_a'"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.Doctor(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("problems left after repair: %+v", report.Problems)
+	}
+
+	rows := historicRows(t, s.db)
+	want := expectedHistoricRows()
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d historic rows, got %d", len(want), len(rows))
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("row %d column %d: got %q, want %q", i, j, rows[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestDoctorDuplicateStation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{
+		testStation("1", "REPSOL", "1,459", ""),
+		testStation("1", "REPSOL", "1,449", ""),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), data); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Doctor(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemDuplicateStation {
+		t.Errorf("expected a duplicate station, got %+v", report.Problems)
+	}
+}
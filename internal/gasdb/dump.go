@@ -0,0 +1,437 @@
+package gasdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// DumpFormat is the file format written by Dump.
+type DumpFormat string
+
+const (
+	DumpCSV     DumpFormat = "csv"
+	DumpParquet DumpFormat = "parquet"
+)
+
+// DumpManifestName is the manifest Dump writes after every data file.
+const DumpManifestName = "manifest.json"
+
+// DumpQuery selects the prices exported by Dump. Empty lists match
+// everything, and zero From or To leave the range open.
+type DumpQuery struct {
+	// Stations are IDEESS station ids.
+	Stations []string
+	Fuels    []Fuel
+	// CCAA, Provinces and Municipalities are ministry region ids, as in
+	// the IDCCAA, IDProvincia and IDMunicipio station fields.
+	CCAA           []string
+	Provinces      []string
+	Municipalities []string
+	From           time.Time
+	To             time.Time
+}
+
+// DumpFile describes a data file written by Dump. Path is relative to the
+// dump directory.
+type DumpFile struct {
+	Path   string `json:"path"`
+	Fuel   Fuel   `json:"fuel"`
+	Month  string `json:"month"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// DumpManifest lists the files of a dump and the filters used to write it.
+type DumpManifest struct {
+	CreatedAt      time.Time  `json:"created_at"`
+	Format         DumpFormat `json:"format"`
+	From           string     `json:"from,omitempty"`
+	To             string     `json:"to,omitempty"`
+	Stations       []string   `json:"stations,omitempty"`
+	Fuels          []Fuel     `json:"fuels,omitempty"`
+	CCAA           []string   `json:"ccaa,omitempty"`
+	Provinces      []string   `json:"provinces,omitempty"`
+	Municipalities []string   `json:"municipalities,omitempty"`
+	Columns        []string   `json:"columns"`
+	Files          []DumpFile `json:"files"`
+}
+
+// dumpRow is a price with the station details of its day. Parquet column
+// names match dumpColumns.
+type dumpRow struct {
+	Date        string  `parquet:"date"`
+	IDEESS      string  `parquet:"ideess"`
+	CP          string  `parquet:"cp"`
+	Direccion   string  `parquet:"direccion"`
+	Horario     string  `parquet:"horario"`
+	Latitud     string  `parquet:"latitud"`
+	Localidad   string  `parquet:"localidad"`
+	Longitud    string  `parquet:"longitud"`
+	Margen      string  `parquet:"margen"`
+	Municipio   string  `parquet:"municipio"`
+	Provincia   string  `parquet:"provincia"`
+	Rotulo      string  `parquet:"rotulo"`
+	TipoVenta   string  `parquet:"tipo_venta"`
+	Fuel        string  `parquet:"fuel"`
+	Price       float64 `parquet:"price"`
+	IDMunicipio string  `parquet:"idmunicipio"`
+	IDProvincia string  `parquet:"idprovincia"`
+	IDCCAA      string  `parquet:"idccaa"`
+}
+
+var dumpColumns = []string{
+	"date", "ideess", "cp", "direccion", "horario", "latitud", "localidad", "longitud", "margen",
+	"municipio", "provincia", "rotulo", "tipo_venta", "fuel", "price", "idmunicipio", "idprovincia", "idccaa",
+}
+
+func (r *dumpRow) record() []string {
+	return []string{
+		r.Date, r.IDEESS, r.CP, r.Direccion, r.Horario, r.Latitud, r.Localidad, r.Longitud, r.Margen,
+		r.Municipio, r.Provincia, r.Rotulo, r.TipoVenta, r.Fuel, strconv.FormatFloat(r.Price, 'f', -1, 64),
+		r.IDMunicipio, r.IDProvincia, r.IDCCAA,
+	}
+}
+
+// ParseDumpFormat returns the DumpFormat named by name.
+func ParseDumpFormat(name string) (DumpFormat, error) {
+	switch format := DumpFormat(strings.ToLower(name)); format {
+	case DumpCSV, DumpParquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown dump format %q", name)
+}
+
+// Dump exports the daily prices selected by q into dir, one file per fuel
+// and month named <fuel>/<YYYY-MM>.<format>. Each file is written to a
+// temporary name and renamed into place once complete, and the manifest is
+// written last, so a dump without an up to date manifest did not finish.
+func (s *Storage) Dump(ctx context.Context, dir string, format DumpFormat, q DumpQuery) (*DumpManifest, error) {
+	if _, err := ParseDumpFormat(string(format)); err != nil {
+		return nil, err
+	}
+
+	manifest := &DumpManifest{
+		CreatedAt:      time.Now().UTC(),
+		Format:         format,
+		Stations:       q.Stations,
+		Fuels:          q.Fuels,
+		CCAA:           q.CCAA,
+		Provinces:      q.Provinces,
+		Municipalities: q.Municipalities,
+		Columns:        dumpColumns,
+		Files:          []DumpFile{},
+	}
+
+	from, to, err := s.dumpRange(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		return manifest, writeManifest(dir, manifest)
+	}
+	manifest.From = from.Format("2006-01-02")
+	manifest.To = to.Format("2006-01-02")
+
+	for month := periodStart(from, GranularityMonth); !month.After(to); month = month.AddDate(0, 1, 0) {
+		start := month
+		if start.Before(from) {
+			start = from
+		}
+		end := periodEnd(month, GranularityMonth)
+		if end.After(to) {
+			end = to
+		}
+
+		files, err := s.dumpMonth(ctx, dir, format, q, month.Format("2006-01"), start, end)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+
+	if err := writeManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	s.log.Info("Dump written", "dir", dir, "files", len(manifest.Files))
+	return manifest, nil
+}
+
+// dumpRange returns the dates to dump, clamped to the stored daily prices.
+// Both are zero when there is nothing to dump.
+func (s *Storage) dumpRange(ctx context.Context, q DumpQuery) (from, to time.Time, err error) {
+	var first, last sql.NullString
+	err = s.db.QueryRowContext(ctx, "SELECT MIN(date), MAX(date) FROM station_days").Scan(&first, &last)
+	if err != nil {
+		return from, to, fmt.Errorf("error querying stored dates: %w", err)
+	}
+	if !first.Valid {
+		return from, to, nil
+	}
+
+	from, _ = time.Parse("2006-01-02", first.String)
+	to, _ = time.Parse("2006-01-02", last.String)
+	if !q.From.IsZero() && q.From.After(from) {
+		from = periodStart(q.From, GranularityDay)
+	}
+	if !q.To.IsZero() && q.To.Before(to) {
+		to = periodStart(q.To, GranularityDay)
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, nil
+	}
+	return from, to, nil
+}
+
+// inCondition returns an IN condition on column matching values.
+func inCondition(column string, values []string) (string, []any) {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	return fmt.Sprintf("%s IN (%s)", column, placeholders), args
+}
+
+// dumpMonth writes the files of a single month, reading its prices in
+// primary key order.
+func (s *Storage) dumpMonth(ctx context.Context, dir string, format DumpFormat, q DumpQuery, month string, start, end time.Time) ([]DumpFile, error) {
+	conditions := []string{"p.date BETWEEN ? AND ?"}
+	args := []any{start.Format("2006-01-02"), end.Format("2006-01-02")}
+
+	fuels := make([]string, len(q.Fuels))
+	for i, f := range q.Fuels {
+		fuels[i] = string(f)
+	}
+	filters := []struct {
+		column string
+		values []string
+	}{
+		{"p.ideess", q.Stations},
+		{"p.fuel", fuels},
+		{"s.idccaa", q.CCAA},
+		{"s.idprovincia", q.Provinces},
+		{"s.idmunicipio", q.Municipalities},
+	}
+	for _, f := range filters {
+		if len(f.values) == 0 {
+			continue
+		}
+		cond, condArgs := inCondition(f.column, f.values)
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.date, p.ideess, s.cp, s.direccion, s.horario, s.latitud, s.localidad, s.longitud,
+			s.margen, s.municipio, s.provincia, s.rotulo, s.tipo_venta, p.fuel, p.price,
+			s.idmunicipio, s.idprovincia, s.idccaa
+		FROM prices p
+		JOIN station_days d ON d.date = p.date AND d.ideess = p.ideess
+		JOIN stations s ON s.id = d.station_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY p.date, p.ideess, p.fuel
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying prices for %s: %w", month, err)
+	}
+	defer rows.Close()
+
+	writers := make(map[string]*dumpWriter)
+	var order []string
+	abort := func() {
+		for _, w := range writers {
+			w.abort()
+		}
+	}
+
+	for rows.Next() {
+		var r dumpRow
+		if err := rows.Scan(&r.Date, &r.IDEESS, &r.CP, &r.Direccion, &r.Horario, &r.Latitud, &r.Localidad,
+			&r.Longitud, &r.Margen, &r.Municipio, &r.Provincia, &r.Rotulo, &r.TipoVenta, &r.Fuel, &r.Price,
+			&r.IDMunicipio, &r.IDProvincia, &r.IDCCAA); err != nil {
+			abort()
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+
+		w, ok := writers[r.Fuel]
+		if !ok {
+			path := filepath.Join(r.Fuel, month+"."+string(format))
+			w, err = newDumpWriter(dir, path, format)
+			if err != nil {
+				abort()
+				return nil, err
+			}
+			w.file.Fuel = Fuel(r.Fuel)
+			w.file.Month = month
+			writers[r.Fuel] = w
+			order = append(order, r.Fuel)
+		}
+		if err := w.write(&r); err != nil {
+			abort()
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		abort()
+		return nil, fmt.Errorf("error iterating prices for %s: %w", month, err)
+	}
+
+	var files []DumpFile
+	for i, fuel := range order {
+		w := writers[fuel]
+		if err := w.commit(); err != nil {
+			for _, pending := range order[i+1:] {
+				writers[pending].abort()
+			}
+			return nil, err
+		}
+		files = append(files, w.file)
+	}
+	return files, nil
+}
+
+// dumpWriter writes one data file to a temporary name, hashing it on the
+// way, and renames it into place on commit.
+type dumpWriter struct {
+	file    DumpFile
+	dest    string
+	out     *os.File
+	hash    hash.Hash
+	csv     *csv.Writer
+	parquet *parquet.GenericWriter[dumpRow]
+}
+
+func newDumpWriter(dir, path string, format DumpFormat) (*dumpWriter, error) {
+	dest := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating dump directory: %w", err)
+	}
+	out, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", dest, err)
+	}
+
+	w := &dumpWriter{
+		file: DumpFile{Path: filepath.ToSlash(path)},
+		dest: dest,
+		out:  out,
+		hash: sha256.New(),
+	}
+	target := io.MultiWriter(out, w.hash)
+	switch format {
+	case DumpParquet:
+		w.parquet = parquet.NewGenericWriter[dumpRow](target, parquet.Compression(&parquet.Zstd))
+	default:
+		w.csv = csv.NewWriter(target)
+		if err := w.csv.Write(dumpColumns); err != nil {
+			w.abort()
+			return nil, fmt.Errorf("error writing %s: %w", dest, err)
+		}
+	}
+	return w, nil
+}
+
+func (w *dumpWriter) write(r *dumpRow) error {
+	var err error
+	if w.parquet != nil {
+		_, err = w.parquet.Write([]dumpRow{*r})
+	} else {
+		err = w.csv.Write(r.record())
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", w.dest, err)
+	}
+	w.file.Rows++
+	return nil
+}
+
+func (w *dumpWriter) commit() error {
+	var err error
+	if w.parquet != nil {
+		err = w.parquet.Close()
+	} else {
+		w.csv.Flush()
+		err = w.csv.Error()
+	}
+	if err == nil {
+		err = w.out.Sync()
+	}
+	if err != nil {
+		w.abort()
+		return fmt.Errorf("error writing %s: %w", w.dest, err)
+	}
+
+	info, err := w.out.Stat()
+	if err != nil {
+		w.abort()
+		return fmt.Errorf("error writing %s: %w", w.dest, err)
+	}
+	if err := w.out.Close(); err != nil {
+		os.Remove(w.out.Name())
+		return fmt.Errorf("error writing %s: %w", w.dest, err)
+	}
+	// CreateTemp files are private, dumps are meant to be shared
+	if err := os.Chmod(w.out.Name(), 0o644); err != nil {
+		os.Remove(w.out.Name())
+		return fmt.Errorf("error setting permissions of %s: %w", w.dest, err)
+	}
+	if err := os.Rename(w.out.Name(), w.dest); err != nil {
+		os.Remove(w.out.Name())
+		return fmt.Errorf("error replacing %s: %w", w.dest, err)
+	}
+
+	w.file.Bytes = info.Size()
+	w.file.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	return nil
+}
+
+func (w *dumpWriter) abort() {
+	w.out.Close()
+	os.Remove(w.out.Name())
+}
+
+// writeManifest atomically writes the manifest of a dump into dir.
+func writeManifest(dir string, manifest *DumpManifest) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating dump directory: %w", err)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+DumpManifestName+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("error setting manifest permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, DumpManifestName)); err != nil {
+		return fmt.Errorf("error replacing manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestDumpCSV(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	dir := t.TempDir()
+	manifest, err := s.Dump(ctx, dir, DumpCSV, DumpQuery{})
+	if err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	if manifest.From != "2024-01-01" || manifest.To != "2024-01-02" {
+		t.Errorf("unexpected range %s - %s", manifest.From, manifest.To)
+	}
+
+	want := map[string]int64{"gasoleo_a/2024-01.csv": 4, "gasolina_95_e5/2024-01.csv": 3}
+	if len(manifest.Files) != len(want) {
+		t.Fatalf("expected %d files, got %+v", len(want), manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		if want[f.Path] != f.Rows || f.Month != "2024-01" || len(f.SHA256) != 64 {
+			t.Errorf("unexpected file %+v", f)
+		}
+	}
+
+	file, err := os.Open(filepath.Join(dir, "gasoleo_a", "2024-01.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || len(records[0]) != len(dumpColumns) {
+		t.Fatalf("unexpected CSV shape: %d rows", len(records))
+	}
+	first := records[1]
+	if first[0] != "2024-01-01" || first[1] != "1" || first[11] != "REPSOL" || first[14] != "1.459" || first[17] != "13" {
+		t.Errorf("unexpected first row %v", first)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, DumpManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk DumpManifest
+	if err := json.Unmarshal(data, &onDisk); err != nil || len(onDisk.Files) != 2 {
+		t.Errorf("unexpected manifest on disk: %s, %v", data, err)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*", ".*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestDumpParquetFilters(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	dir := t.TempDir()
+	from, _ := time.Parse("2006-01-02", "2024-01-02")
+	manifest, err := s.Dump(ctx, dir, DumpParquet, DumpQuery{
+		Stations:  []string{"2"},
+		Fuels:     []Fuel{FuelGasoleoA},
+		Provinces: []string{"28"},
+		From:      from,
+	})
+	if err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "gasoleo_a/2024-01.parquet" || manifest.Files[0].Rows != 1 {
+		t.Fatalf("unexpected files %+v", manifest.Files)
+	}
+
+	rows, err := parquet.ReadFile[dumpRow](filepath.Join(dir, "gasoleo_a", "2024-01.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Date != "2024-01-02" || rows[0].Rotulo != "MOEVE" || rows[0].Price != 1.469 {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+
+	manifest, err = s.Dump(ctx, t.TempDir(), DumpCSV, DumpQuery{CCAA: []string{"01"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 0 {
+		t.Errorf("region filter not applied: %+v", manifest.Files)
+	}
+}
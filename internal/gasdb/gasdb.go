@@ -4,47 +4,87 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
-	"math"
-	"sort"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/ncruces/go-sqlite3/driver"
+	"github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
-	"github.com/patrickmn/go-cache"
 	"github.com/rubiojr/gasdb/pkg/api"
 	"github.com/tkrajina/gpxgo/gpx"
 )
 
 const (
-	cacheDefaultExpiry = 5 * time.Minute
-	cacheCleanupTime   = 10 * time.Minute
-	decimalBase        = 10
-	squareExponent     = 2
-	deleteRecordsPause = 50
+	defaultCacheSize   = -1024 * 1024 // negative value for pages
+	defaultPageSize    = 4096
+	migrationCacheSize = 1000000000
 )
 
-const (
-	defaultCacheExpirationMinutes      = 10
-	defaultCacheCleanupMinutes         = 30
-	defaultReducePrecisionDecimalPlace = 2
-	defaultSleepMs                     = 200
-	defaultCacheSize                   = -1024 * 1024 // negative value for pages
-	defaultPageSize                    = 4096
-	migrationCacheSize                 = 1000000000
-)
+// ErrNoData is returned when no snapshot is stored for the requested date.
+var ErrNoData = errors.New("no data available")
 
 type Storage struct {
 	db    *sql.DB
-	cache *cache.Cache
-	log   *slog.Logger
+	cache Cache
+	// snapshots holds the few parsed snapshots in use, apart from cache, as
+	// each one is the size of thousands of query results.
+	snapshots   Cache
+	generations generations
+	snapshotTTL time.Duration
+	queryTTL    time.Duration
+	locationLog LocationLogPolicy
+	purge       purgeSchedule
+	log         *slog.Logger
+
+	transformers []Transformer
+	validators   []Validator
+
+	webhookClient   *http.Client
+	webhookAttempts int
+	webhookBackoff  time.Duration
+	// webhookMu keeps concurrent deliveries from sending a delivery twice.
+	webhookMu sync.Mutex
 }
 
-// GetAllDates returns all dates present in the fuel_prices table, sorted ascending.
+// newStorage applies opts and wraps an open database.
+func newStorage(db *sql.DB, logger *slog.Logger, opts []Option) *Storage {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.cache == nil {
+		o.cache = NewLRUCache(o.cacheSize)
+	}
+	if o.snapshotSlots <= 0 {
+		o.snapshotSlots = defaultSnapshotSlots
+	}
+
+	return &Storage{
+		db:          db,
+		cache:       o.cache,
+		snapshots:   NewLRUCache(o.snapshotSlots),
+		snapshotTTL: o.snapshotTTL,
+		queryTTL:    o.queryTTL,
+		locationLog: o.locationLog,
+		log:         logger,
+
+		transformers: o.transformers,
+		validators:   o.validators,
+
+		webhookClient:   o.webhookClient,
+		webhookAttempts: max(o.webhookAttempts, 1),
+		webhookBackoff:  o.webhookBackoff,
+	}
+}
+
+// GetAllDates returns all dates present in the fuel_prices table, sorted
+// ascending. They are business dates at midnight UTC, see BusinessDate.
 func (s *Storage) GetAllDates(ctx context.Context) ([]time.Time, error) {
 	rows, err := s.db.QueryContext(ctx, "SELECT date FROM fuel_prices ORDER BY date ASC")
 	if err != nil {
@@ -70,8 +110,8 @@ func (s *Storage) GetAllDates(ctx context.Context) ([]time.Time, error) {
 	return dates, nil
 }
 
-func NewStorage(ctx context.Context, dbPath string, logger *slog.Logger) (*Storage, error) {
-	db, err := sql.Open("sqlite3", "file:"+dbPath)
+func NewStorage(ctx context.Context, dbPath string, logger *slog.Logger, opts ...Option) (*Storage, error) {
+	db, err := driver.Open("file:"+dbPath, registerFunctions)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
@@ -86,38 +126,21 @@ func NewStorage(ctx context.Context, dbPath string, logger *slog.Logger) (*Stora
 		return nil, fmt.Errorf("error creating tables: %w", err)
 	}
 
-	// Initialize the cache with default expiration of 5 minutes and cleanup interval of 10 minutes
-	c := cache.New(defaultCacheExpirationMinutes*time.Minute, defaultCacheCleanupMinutes*time.Minute)
-
-	s := &Storage{
-		db:    db,
-		cache: c,
-		log:   logger,
-	}
+	s := newStorage(db, logger, opts)
 
-	err = s.CreateHistoricPricesTable(ctx)
-	if err != nil {
+	if err := s.createSchema(ctx); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("error creating historic_prices table: %w", err)
+		return nil, err
 	}
 
-	err = s.CreateTrigger(ctx)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error creating trigger: %w", err)
-	}
-
-	err = s.CreateLocationLogsTable(ctx)
-	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error creating location_logs table: %w", err)
-	}
+	// Cells logged before are purged even if logging is now disabled
+	s.purgeLocationLogsIfDue(ctx)
 
 	return s, nil
 }
 
-func NewStorageMigrate(ctx context.Context, dbPath string, logger *slog.Logger) (*Storage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+func NewStorageMigrate(ctx context.Context, dbPath string, logger *slog.Logger, opts ...Option) (*Storage, error) {
+	db, err := driver.Open(dbPath, registerFunctions)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
@@ -142,21 +165,49 @@ func NewStorageMigrate(ctx context.Context, dbPath string, logger *slog.Logger)
 		return nil, fmt.Errorf("error creating tables: %w", err)
 	}
 
-	c := cache.New(cacheDefaultExpiry, cacheCleanupTime)
+	s := newStorage(db, logger, opts)
 
-	s := &Storage{
-		db:    db,
-		cache: c,
-		log:   logger,
+	if err := s.createSchema(ctx); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	// Create historic prices table
+	return s, nil
+}
+
+// createSchema creates the tables, indexes, views and triggers derived from
+// or living next to fuel_prices, migrating the ones created by older
+// versions. NewStorage and NewStorageMigrate share it so either opens a
+// complete database.
+func (s *Storage) createSchema(ctx context.Context) error {
 	if err := s.CreateHistoricPricesTable(ctx); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("error creating historic prices table: %w", err)
+		return fmt.Errorf("error creating historic prices table: %w", err)
+	}
+	if err := s.CreateSpatialIndex(ctx); err != nil {
+		return fmt.Errorf("error creating spatial index: %w", err)
+	}
+	if err := s.CreateLocationLogsTable(ctx); err != nil {
+		return fmt.Errorf("error creating location_logs table: %w", err)
 	}
 
-	return s, nil
+	for _, create := range []func(context.Context) error{
+		s.dropLegacyTrigger,
+		s.createIngestionStatsTable,
+		s.createQuarantineTable,
+		s.createStationEventsTable,
+		s.createWatchTables,
+		s.createWebhookTables,
+		s.createSearchIndex,
+		s.createAggregateTables,
+		s.createWeeklyPricesTable,
+		s.createBackfillTable,
+		s.createIntradayTables,
+	} {
+		if err := create(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func createTables(ctx context.Context, db *sql.DB) error {
@@ -164,7 +215,8 @@ func createTables(ctx context.Context, db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS fuel_prices (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		date TEXT UNIQUE NOT NULL,
-		data BLOB NOT NULL
+		data BLOB NOT NULL,
+		codec TEXT NOT NULL DEFAULT 'none'
 	);
 	CREATE INDEX IF NOT EXISTS idx_fuel_prices_date ON fuel_prices(date);
 	`
@@ -173,71 +225,15 @@ func createTables(ctx context.Context, db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("error creating table: %w", err)
 	}
-	return nil
-}
-
-func (s *Storage) CreateTrigger(ctx context.Context) error {
-	createTriggerSQL := `
-	CREATE TRIGGER IF NOT EXISTS insert_historic_prices
-	AFTER INSERT ON fuel_prices
-	BEGIN
-		INSERT OR REPLACE INTO historic_prices (
-			date, ideess, cp, direccion, horario, latitud, localidad, longitud,
-			margen, municipio, provincia, rotulo, tipo_venta, precio_biodiesel,
-			precio_bioetanol, precio_gas_natural_comp, precio_gas_natural_licuado,
-			precio_gases_licuados, precio_gasoleo_a, precio_gasoleo_b, precio_gasoleo_premium,
-			precio_gasolina_95_e10, precio_gasolina_95_e5, precio_gasolina_95_e5_prem,
-			precio_gasolina_98_e10, precio_gasolina_98_e5, precio_hidrogeno,
-			porcentaje_bioetanol, porcentaje_ester_metilico, idmunicipio, idprovincia, idccaa
-		)
-		SELECT
-			NEW.date,
-			json_extract(station.value, '$.IDEESS'),
-			json_extract(station.value, '$."C.P."'),
-			json_extract(station.value, '$."Dirección"'),
-			json_extract(station.value, '$.Horario'),
-			json_extract(station.value, '$.Latitud'),
-			json_extract(station.value, '$.Localidad'),
-			json_extract(station.value, '$."Longitud (WGS84)"'),
-			json_extract(station.value, '$.Margen'),
-			json_extract(station.value, '$.Municipio'),
-			json_extract(station.value, '$.Provincia'),
-			json_extract(station.value, '$."Rótulo"'),
-			json_extract(station.value, '$."Tipo Venta"'),
-			json_extract(station.value, '$."Precio Biodiesel"'),
-			json_extract(station.value, '$."Precio Bioetanol"'),
-			json_extract(station.value, '$."Precio Gas Natural Comprimido"'),
-			json_extract(station.value, '$."Precio Gas Natural Licuado"'),
-			json_extract(station.value, '$."Precio Gases licuados del petróleo"'),
-			json_extract(station.value, '$."Precio Gasoleo A"'),
-			json_extract(station.value, '$."Precio Gasoleo B"'),
-			json_extract(station.value, '$."Precio Gasoleo Premium"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E10"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E5"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E5 Premium"'),
-			json_extract(station.value, '$."Precio Gasolina 98 E10"'),
-			json_extract(station.value, '$."Precio Gasolina 98 E5"'),
-			json_extract(station.value, '$."Precio Hidrogeno"'),
-			json_extract(station.value, '$."% BioEtanol"'),
-			json_extract(station.value, '$."% Éster metílico"'),
-			json_extract(station.value, '$.IDMunicipio'),
-			json_extract(station.value, '$.IDProvincia'),
-			json_extract(station.value, '$.IDCCAA')
-		FROM json_each(json_extract(NEW.data, '$.ListaEESSPrecio')) AS station;
-	END;
-	`
-
-	_, err := s.db.ExecContext(ctx, createTriggerSQL)
-	if err != nil {
-		return fmt.Errorf("error creating trigger: %w", err)
-	}
-
-	return nil
+	return migrateSnapshotCodec(ctx, db)
 }
 
+// MigrateToHistoricPrices derives the normalized rows of every stored
+// snapshot again, in a single transaction. Snapshots that cannot be decoded
+// are skipped.
 func (s *Storage) MigrateToHistoricPrices(ctx context.Context) error {
 	s.log.Debug("Migrating to historic_prices table")
-	rows, err := s.db.QueryContext(ctx, "SELECT date, data FROM fuel_prices ORDER BY date")
+	rows, err := s.db.QueryContext(ctx, "SELECT date, data, codec FROM fuel_prices ORDER BY date")
 	if err != nil {
 		return fmt.Errorf("error querying fuel_prices: %w", err)
 	}
@@ -253,53 +249,34 @@ func (s *Storage) MigrateToHistoricPrices(ctx context.Context) error {
 		}
 	}()
 
-	stmt, err := tx.PrepareContext(ctx, `
-		INSERT OR REPLACE INTO historic_prices (
-			date, ideess, cp, direccion, horario, latitud, localidad, longitud,
-			margen, municipio, provincia, rotulo, tipo_venta, precio_biodiesel,
-			precio_bioetanol, precio_gas_natural_comp, precio_gas_natural_licuado,
-			precio_gases_licuados, precio_gasoleo_a, precio_gasoleo_b, precio_gasoleo_premium,
-			precio_gasolina_95_e10, precio_gasolina_95_e5, precio_gasolina_95_e5_prem,
-			precio_gasolina_98_e10, precio_gasolina_98_e5, precio_hidrogeno,
-			porcentaje_bioetanol, porcentaje_ester_metilico, idmunicipio, idprovincia, idccaa
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+	writer, err := newSnapshotWriter(ctx, tx)
 	if err != nil {
-		return fmt.Errorf("error preparing statement: %w", err)
+		return err
 	}
-	defer stmt.Close()
+	defer writer.Close()
 
 	for rows.Next() {
 		s.log.Debug("Processing row...")
-		var dateStr string
-		var jsonData []byte
-		if err := rows.Scan(&dateStr, &jsonData); err != nil {
+		var dateStr, codec string
+		var blob []byte
+		if err := rows.Scan(&dateStr, &blob, &codec); err != nil {
 			return fmt.Errorf("error scanning row: %w", err)
 		}
 
+		jsonData, err := decodeSnapshot(blob, codec)
+		if err != nil {
+			s.log.Warn("Warning: error decoding data for date", "date", dateStr, "error", err)
+			continue
+		}
+
 		var stationList api.GasStationList
 		if err := json.Unmarshal(jsonData, &stationList); err != nil {
 			s.log.Warn("Warning: error unmarshaling data for date", "date", dateStr, "error", err)
 			continue
 		}
 
-		for i := range stationList.ListaEESSPrecio {
-			station := &stationList.ListaEESSPrecio[i]
-			_, err := stmt.ExecContext(ctx,
-				dateStr, station.IDEESS, station.CP, station.Direccion, station.Horario,
-				station.Latitud, station.Localidad, station.Longitud, station.Margen,
-				station.Municipio, station.Provincia, station.Rotulo, station.TipoVenta,
-				station.PrecioBiodiesel, station.PrecioBioetanol, station.PrecioGasNaturalComp,
-				station.PrecioGasNaturalLicuado, station.PrecioGasesLicuados, station.PrecioGasoleoA,
-				station.PrecioGasoleoB, station.PrecioGasoleoPremium, station.PrecioGasolina95E10,
-				station.PrecioGasolina95E5, station.PrecioGasolina95E5Prem, station.PrecioGasolina98E10,
-				station.PrecioGasolina98E5, station.PrecioHidrogeno, station.PorcentajeBioEtanol,
-				station.PorcentajeEsterMetilico, station.IDMunicipio, station.IDProvincia, station.IDCCAA,
-			)
-			if err != nil {
-				s.log.Warn("Warning: error inserting station", "ideess", station.IDEESS, "error", err)
-				continue
-			}
+		if _, _, err := s.ingest(ctx, tx, writer, dateStr, stationList.ListaEESSPrecio); err != nil {
+			return err
 		}
 	}
 
@@ -315,68 +292,66 @@ func (s *Storage) MigrateToHistoricPrices(ctx context.Context) error {
 	return nil
 }
 
+// CreateHistoricPricesTable creates the normalized stations and prices tables
+// and the historic_prices compatibility view on top of them. Databases still
+// using the legacy wide historic_prices table are migrated first.
 func (s *Storage) CreateHistoricPricesTable(ctx context.Context) error {
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS historic_prices (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date TEXT NOT NULL,
-		ideess TEXT NOT NULL,
-		cp TEXT,
-		direccion TEXT,
-		horario TEXT,
-		latitud TEXT,
-		localidad TEXT,
-		longitud TEXT,
-		margen TEXT,
-		municipio TEXT,
-		provincia TEXT,
-		rotulo TEXT,
-		tipo_venta TEXT,
-		precio_biodiesel TEXT,
-		precio_bioetanol TEXT,
-		precio_gas_natural_comp TEXT,
-		precio_gas_natural_licuado TEXT,
-		precio_gases_licuados TEXT,
-		precio_gasoleo_a TEXT,
-		precio_gasoleo_b TEXT,
-		precio_gasoleo_premium TEXT,
-		precio_gasolina_95_e10 TEXT,
-		precio_gasolina_95_e5 TEXT,
-		precio_gasolina_95_e5_prem TEXT,
-		precio_gasolina_98_e10 TEXT,
-		precio_gasolina_98_e5 TEXT,
-		precio_hidrogeno TEXT,
-		porcentaje_bioetanol TEXT,
-		porcentaje_ester_metilico TEXT,
-		idmunicipio TEXT,
-		idprovincia TEXT,
-		idccaa TEXT,
-		UNIQUE(date, ideess)
-	);
-	CREATE INDEX IF NOT EXISTS idx_historic_prices_date ON historic_prices(date);
-	CREATE INDEX IF NOT EXISTS idx_historic_prices_ideess ON historic_prices(ideess);
-	CREATE INDEX IF NOT EXISTS idx_historic_prices_latitud_longitud ON historic_prices(latitud, longitud);
-	`
-
-	_, err := s.db.ExecContext(ctx, createTableSQL)
+	legacy, err := s.historicPricesIsTable(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating historic_prices table: %w", err)
+		return err
 	}
 
-	return nil
+	if err := s.createNormalizedTables(ctx); err != nil {
+		return err
+	}
+
+	if legacy {
+		if err := s.migrateLegacyHistoricPrices(ctx); err != nil {
+			return err
+		}
+	}
+
+	return s.createHistoricPricesView(ctx)
 }
 
 func (s *Storage) Close() error {
 	// Clear the cache before closing
 	if s.cache != nil {
-		s.cache.Flush()
+		s.cache.Purge()
+	}
+	if s.snapshots != nil {
+		s.snapshots.Purge()
 	}
 	return s.db.Close()
 }
 
+// SavePrices stores the snapshot taken at date under its business date, see
+// BusinessDate, and ingests its stations into the normalized tables in the
+// same transaction. It is meant for snapshots just published: when no later
+// day is stored, the watch rules are evaluated on its prices too, and the new
+// snapshot, anomalies and alerts are queued for the subscribed webhooks.
+// Backfill saves past days without either.
 func (s *Storage) SavePrices(ctx context.Context, date time.Time, data []byte) error {
+	return s.savePrices(ctx, date, data, true)
+}
+
+// savePrices does the work of SavePrices. Only live snapshots, as opposed
+// to backfilled ones, are evaluated by the watch rules and announced to the
+// webhooks.
+func (s *Storage) savePrices(ctx context.Context, date time.Time, data []byte, live bool) error {
+	date = BusinessDate(date)
 	dateStr := date.Format("2006-01-02")
 
+	var stationList api.GasStationList
+	if err := json.Unmarshal(data, &stationList); err != nil {
+		return fmt.Errorf("error unmarshaling data: %w", err)
+	}
+
+	blob, codec, err := encodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %w", err)
@@ -387,24 +362,78 @@ func (s *Storage) SavePrices(ctx context.Context, date time.Time, data []byte) e
 		}
 	}()
 
-	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO fuel_prices (date, data) VALUES (?, ?)", dateStr, data)
+	var existed bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM fuel_prices WHERE date = ?)", dateStr).Scan(&existed)
+	if err != nil {
+		return fmt.Errorf("error checking date existence: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO fuel_prices (date, data, codec) VALUES (?, ?, ?)", dateStr, blob, codec)
 	if err != nil {
 		return fmt.Errorf("error inserting data: %w", err)
 	}
 
+	writer, err := newSnapshotWriter(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	stats, anomalies, err := s.ingest(ctx, tx, writer, dateStr, stationList.ListaEESSPrecio)
+	if err != nil {
+		return err
+	}
+
+	if err := updateAggregates(ctx, tx, date); err != nil {
+		return err
+	}
+
+	latest := false
+	if live {
+		if latest, err = isLatestDay(ctx, tx, dateStr); err != nil {
+			return err
+		}
+	}
+	if latest {
+		alerts, err := s.evaluateWatchRules(ctx, tx, dateStr)
+		if err != nil {
+			return err
+		}
+		if len(alerts) > 0 {
+			s.log.Info("Raised price alerts", "date", dateStr, "alerts", len(alerts))
+		}
+
+		var messages []webhookMessage
+		if !existed {
+			messages = append(messages, webhookMessage{EventSnapshot, SnapshotEvent{Date: dateStr, Stations: stats.Written, Prices: stats.Prices}})
+		}
+		for _, a := range anomalies {
+			messages = append(messages, webhookMessage{EventAnomaly, a})
+		}
+		for _, a := range alerts {
+			messages = append(messages, webhookMessage{EventAlert, a})
+		}
+		queued, err := enqueueWebhooks(ctx, tx, messages)
+		if err != nil {
+			return err
+		}
+		if queued > 0 {
+			s.log.Info("Queued webhook deliveries", "date", dateStr, "deliveries", queued)
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("error committing transaction: %w", err)
 	}
 
-	// Clear cache for this date and related keys
-	s.cache.Delete("last_price")
-	s.cache.Flush()
+	s.invalidateSnapshot(dateStr)
 
 	return nil
 }
 
+// HasDate reports whether the snapshot of the business date of date is stored.
 func (s *Storage) HasDate(ctx context.Context, date time.Time) (bool, error) {
-	dateStr := date.Format("2006-01-02")
+	dateStr := formatDate(date)
 	var count int
 	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fuel_prices WHERE date = ?", dateStr).Scan(&count)
 	if err != nil {
@@ -414,33 +443,38 @@ func (s *Storage) HasDate(ctx context.Context, date time.Time) (bool, error) {
 }
 
 func (s *Storage) GetLastPrices(ctx context.Context) (*api.GasStationList, error) {
-	// Use a static cache key for the last price
-	const cacheKey = "last_price"
+	cacheKey := s.cacheKey(latestGeneration, "snapshot")
 
 	// Try to get data from cache
-	if cachedData, found := s.cache.Get(cacheKey); found {
+	if cachedData, found := s.snapshots.Get(cacheKey); found {
 		// Return the cached data if found
 		s.log.Debug("Using cached data", "key", cacheKey)
 		return cachedData.(*api.GasStationList), nil
 	}
 
 	// If not in cache, fetch from database
-	var jsonData []byte
-	err := s.db.QueryRowContext(ctx, "SELECT data FROM fuel_prices ORDER BY date DESC LIMIT 1").Scan(&jsonData)
+	var blob []byte
+	var codec string
+	err := s.db.QueryRowContext(ctx, "SELECT data, codec FROM fuel_prices ORDER BY date DESC LIMIT 1").Scan(&blob, &codec)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no data available")
+			return nil, ErrNoData
 		}
 		return nil, fmt.Errorf("error querying database: %w", err)
 	}
 
+	jsonData, err := decodeSnapshot(blob, codec)
+	if err != nil {
+		return nil, err
+	}
+
 	var pricesResponse api.GasStationList
 	if err := json.Unmarshal(jsonData, &pricesResponse); err != nil {
 		return nil, fmt.Errorf("error unmarshaling data: %w", err)
 	}
 
 	// Store the result in cache for future use
-	s.cache.Set(cacheKey, &pricesResponse, cache.DefaultExpiration)
+	s.snapshots.Set(cacheKey, &pricesResponse, s.snapshotTTL)
 
 	return &pricesResponse, nil
 }
@@ -464,25 +498,20 @@ func (s *Storage) GetLastUpdateDate(ctx context.Context) (*time.Time, error) {
 	return &lastUpdate, nil
 }
 
+// NearbyPrices returns the gas stations within distance (meters) of the given
+// coordinates, with the latest prices. Quarantined stations and prices are
+// hidden unless ctx includes them, see WithAnomalies.
 func (s *Storage) NearbyPrices(ctx context.Context, lat, lng, distance float64) ([]*api.GasStation, error) {
 	// Create a cache key based on the parameters
-	cacheKey := fmt.Sprintf("nearby_prices_%f_%f_%f", lat, lng, distance)
+	cacheKey := s.cacheKey(latestGeneration, "nearby", lat, lng, distance)
 
-	// Log the search location
-	newLat, newLong := reduceLocationPrecision(lat, lng, defaultReducePrecisionDecimalPlace)
-	err := s.LogSearchLocation(ctx, newLat, newLong, distance)
-	if err != nil {
-		// Log error but don't fail the search if logging fails
-		s.log.Error("Failed to log search location", "error", err)
-	} else {
-		s.log.Debug("Search location logged", "latitude", lat, "longitude", lng)
-	}
+	s.logSearch(ctx, lat, lng, distance)
 
 	// Try to get data from cache
 	if cachedData, found := s.cache.Get(cacheKey); found {
 		// Return the cached data if found
 		s.log.Debug("Using cached data", "key", cacheKey)
-		return cachedData.([]*api.GasStation), nil
+		return s.hideAnomalies(ctx, "", cachedData.([]*api.GasStation))
 	}
 	s.log.Debug("Fetching data from database, cached data not found", "key", cacheKey)
 
@@ -492,9 +521,80 @@ func (s *Storage) NearbyPrices(ctx context.Context, lat, lng, distance float64)
 		return nil, fmt.Errorf("error getting last price: %w", err)
 	}
 
+	nearbyStations := filterNearby(pricesResponse, lat, lng, distance)
+
+	// Store the result in cache for future use
+	s.cache.Set(cacheKey, nearbyStations, s.queryTTL)
+
+	return s.hideAnomalies(ctx, "", nearbyStations)
+}
+
+// LatestStations returns the stations of ideess listed in the latest
+// snapshot, in the given order, with their latest prices. Quarantined
+// stations and prices are hidden as in NearbyPrices.
+func (s *Storage) LatestStations(ctx context.Context, ideess []string) ([]*api.GasStation, error) {
+	latest, err := s.GetLastPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]*api.GasStation, len(latest.ListaEESSPrecio))
+	for i := range latest.ListaEESSPrecio {
+		station := &latest.ListaEESSPrecio[i]
+		listed[station.IDEESS] = station
+	}
+
+	stations := make([]*api.GasStation, 0, len(ideess))
+	for _, id := range ideess {
+		if station, ok := listed[id]; ok {
+			stations = append(stations, station)
+		}
+	}
+	return s.hideAnomalies(ctx, "", stations)
+}
+
+// NearbyPricesAt returns the gas stations within distance (meters) of the
+// given coordinates, with the prices stored for date. Days whose snapshot was
+// pruned are rebuilt from the normalized tables. Anomalies are hidden as in
+// NearbyPrices.
+func (s *Storage) NearbyPricesAt(ctx context.Context, date time.Time, lat, lng, distance float64) ([]*api.GasStation, error) {
+	dateStr := formatDate(date)
+	cacheKey := s.cacheKey(dateStr, "nearby", lat, lng, distance)
+
+	s.logSearch(ctx, lat, lng, distance)
+
+	if cachedData, found := s.cache.Get(cacheKey); found {
+		s.log.Debug("Using cached data", "key", cacheKey)
+		return s.hideAnomalies(ctx, dateStr, cachedData.([]*api.GasStation))
+	}
+
+	snapshotKey := s.cacheKey(dateStr, "snapshot")
+	var pricesResponse *api.GasStationList
+	if cachedData, found := s.snapshots.Get(snapshotKey); found {
+		pricesResponse = cachedData.(*api.GasStationList)
+	} else {
+		var err error
+		pricesResponse, err = s.GetPrices(ctx, date)
+		if errors.Is(err, ErrNoData) {
+			// Snapshots pruned by retention leave the normalized rows
+			pricesResponse, err = s.normalizedPrices(ctx, dateStr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting prices for %s: %w", dateStr, err)
+		}
+		s.snapshots.Set(snapshotKey, pricesResponse, s.snapshotTTL)
+	}
+
+	nearbyStations := filterNearby(pricesResponse, lat, lng, distance)
+	s.cache.Set(cacheKey, nearbyStations, s.queryTTL)
+
+	return s.hideAnomalies(ctx, dateStr, nearbyStations)
+}
+
+// filterNearby returns the stations of a snapshot within distance (meters) of the given coordinates.
+func filterNearby(prices *api.GasStationList, lat, lng, distance float64) []*api.GasStation {
 	var nearbyStations []*api.GasStation
-	for i := range pricesResponse.ListaEESSPrecio {
-		station := &pricesResponse.ListaEESSPrecio[i]
+	for i := range prices.ListaEESSPrecio {
+		station := &prices.ListaEESSPrecio[i]
 		stationLat, err := ParseLatLong(station.Latitud)
 		if err != nil {
 			continue
@@ -511,24 +611,27 @@ func (s *Storage) NearbyPrices(ctx context.Context, lat, lng, distance float64)
 		}
 	}
 
-	// Store the result in cache for future use
-	s.cache.Set(cacheKey, nearbyStations, cache.DefaultExpiration)
-
-	return nearbyStations, nil
+	return nearbyStations
 }
 
 func (s *Storage) GetPrices(ctx context.Context, date time.Time) (*api.GasStationList, error) {
-	dateStr := date.Format("2006-01-02")
+	dateStr := formatDate(date)
 
-	var jsonData []byte
-	err := s.db.QueryRowContext(ctx, "SELECT data FROM fuel_prices WHERE date = ?", dateStr).Scan(&jsonData)
+	var blob []byte
+	var codec string
+	err := s.db.QueryRowContext(ctx, "SELECT data, codec FROM fuel_prices WHERE date = ?", dateStr).Scan(&blob, &codec)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no data available for date %s", dateStr)
+			return nil, fmt.Errorf("%w for date %s", ErrNoData, dateStr)
 		}
 		return nil, fmt.Errorf("error querying database: %w", err)
 	}
 
+	jsonData, err := decodeSnapshot(blob, codec)
+	if err != nil {
+		return nil, err
+	}
+
 	var pricesResponse api.GasStationList
 	if err := json.Unmarshal(jsonData, &pricesResponse); err != nil {
 		return nil, fmt.Errorf("error unmarshaling data: %w", err)
@@ -542,65 +645,94 @@ func (s *Storage) GetPrices(ctx context.Context, date time.Time) (*api.GasStatio
 	return &pricesResponse, nil
 }
 
-func (s *Storage) UpdateDB(ctx context.Context) error {
-	fuelAPI := api.NewFuelPriceAPI()
-	pricesResponse, err := fuelAPI.FetchPrices()
+// normalizedPrices rebuilds the stations and prices listed on date from the
+// station_days, stations and prices tables, in the order they were saved.
+func (s *Storage) normalizedPrices(ctx context.Context, date string) (*api.GasStationList, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT d.ideess, %s FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		WHERE d.date = ?
+		ORDER BY d.id
+	`, "s."+strings.Join(stationColumns(), ", s.")), date)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error querying stations: %w", err)
 	}
+	defer rows.Close()
 
-	if pricesResponse.ResultadoConsulta != api.ApiResultOK {
-		return fmt.Errorf("API returned non-OK result: %s", pricesResponse.ResultadoConsulta)
+	list := &api.GasStationList{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var station api.GasStation
+		dest := []any{&station.IDEESS}
+		for _, f := range stationFields {
+			dest = append(dest, f.field(&station))
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error scanning station: %w", err)
+		}
+		index[station.IDEESS] = len(list.ListaEESSPrecio)
+		list.ListaEESSPrecio = append(list.ListaEESSPrecio, station)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stations: %w", err)
+	}
+	if len(list.ListaEESSPrecio) == 0 {
+		return nil, fmt.Errorf("%w for date %s", ErrNoData, date)
 	}
 
-	data, err := json.Marshal(pricesResponse)
+	fields := make(map[Fuel]fuelField, len(fuelFields))
+	for _, f := range fuelFields {
+		fields[f.fuel] = f
+	}
+	priceRows, err := s.db.QueryContext(ctx, "SELECT ideess, fuel, price FROM prices WHERE date = ?", date)
 	if err != nil {
-		return fmt.Errorf("error marshaling data: %w", err)
+		return nil, fmt.Errorf("error querying prices: %w", err)
+	}
+	defer priceRows.Close()
+	for priceRows.Next() {
+		var ideess, fuel string
+		var price float64
+		if err := priceRows.Scan(&ideess, &fuel, &price); err != nil {
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+		i, ok := index[ideess]
+		f, known := fields[Fuel(fuel)]
+		if !ok || !known {
+			continue
+		}
+		// Same format as the snapshot and the historic_prices view
+		*f.field(&list.ListaEESSPrecio[i]) = strings.Replace(fmt.Sprintf("%.3f", price), ".", ",", 1)
+	}
+	if err := priceRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating prices: %w", err)
 	}
 
-	return s.SavePrices(ctx, time.Now(), data)
+	list.ResultadoConsulta = api.ApiResultOK
+	return list, nil
 }
 
-func (s *Storage) GetPopularLocationsMap(limit int) ([]map[string]interface{}, error) {
-	query := `
-	SELECT lat, lng, distance, count, last_search
-	FROM search_locations
-	ORDER BY count DESC
-	LIMIT ?
-	`
-
-	rows, err := s.db.QueryContext(context.Background(), query, limit)
+func (s *Storage) UpdateDB(ctx context.Context) error {
+	fuelAPI := api.NewFuelPriceAPI()
+	pricesResponse, err := fuelAPI.FetchPrices()
 	if err != nil {
-		return nil, fmt.Errorf("error querying popular locations: %w", err)
+		return err
 	}
-	defer rows.Close()
-
-	var popularLocations []map[string]interface{}
-	for rows.Next() {
-		var lat, lng, distance float64
-		var count int
-		var lastSearch string
 
-		err := rows.Scan(&lat, &lng, &distance, &count, &lastSearch)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-
-		location := map[string]interface{}{
-			"lat":         lat,
-			"lng":         lng,
-			"distance":    distance,
-			"count":       count,
-			"last_search": lastSearch,
-		}
-		popularLocations = append(popularLocations, location)
+	if pricesResponse.ResultadoConsulta != api.ApiResultOK {
+		return fmt.Errorf("API returned non-OK result: %s", pricesResponse.ResultadoConsulta)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+	data, err := json.Marshal(pricesResponse)
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
 	}
 
-	return popularLocations, nil
+	// Every distinct snapshot of the day is kept, the last one is the day's
+	now := time.Now()
+	if _, err := s.SaveIntraday(ctx, now, data); err != nil {
+		return err
+	}
+	return s.SavePrices(ctx, now, data)
 }
 
 func ParseLatLong(s string) (float64, error) {
@@ -613,65 +745,18 @@ func ParseLatLong(s string) (float64, error) {
 	return m, nil
 }
 
+// UpdateDBAll backfills every missing day since 2007 with the default
+// BackfillOptions, then saves today's snapshot.
 func (s *Storage) UpdateDBAll(ctx context.Context) error {
-	fuelAPI := api.NewFuelPriceAPI()
-
-	startDate := time.Date(2007, 1, 1, 0, 0, 0, 0, time.UTC)
-	endDate := time.Now().AddDate(0, 0, -1)
-
-	for date := startDate; date.Before(endDate) || date.Equal(endDate); date = date.AddDate(0, 0, 1) {
-		hasDate, err := s.HasDate(ctx, date)
-		if err != nil {
-			s.log.Debug("error checking if date exists", "date", date.Format("2006-01-02"), "error", err)
-			continue
-		}
-		if hasDate {
-			continue
-		}
-
-		s.log.Debug("fetching data for", "date", date.Format("2006-01-02"))
-
-		pricesResponse, err := fuelAPI.FetchPricesForDate(date)
-		if err != nil {
-			s.log.Debug("Error fetching prices for date", "date", date.Format("2006-01-02"), "error", err)
-			continue
-		}
-
-		if pricesResponse.ResultadoConsulta != api.ApiResultOK {
-			s.log.Debug("API returned non-OK result for", "date", date.Format("2006-01-02"), "result", pricesResponse.ResultadoConsulta)
-			continue
-		}
-
-		jsonData, err := json.Marshal(pricesResponse)
-		if err != nil {
-			s.log.Debug("Error marshaling JSON for", "date", date.Format("2006-01-02"), "error", err)
-			continue
-		}
-
-		if err := s.SavePrices(ctx, date, jsonData); err != nil {
-			s.log.Debug("error saving data for", "date", date.Format("2006-01-02"), "error", err)
-			continue
-		}
-		s.log.Debug("Saved data for", "date", date.Format("2006-01-02"))
-		time.Sleep(time.Duration(defaultSleepMs) * time.Millisecond)
-	}
-
-	// Fetch latest data
-	pricesResponse, err := fuelAPI.FetchPrices()
+	report, err := s.Backfill(ctx, BackfillOptions{})
 	if err != nil {
-		return fmt.Errorf("error fetching latest data: %w", err)
-	}
-
-	if pricesResponse.ResultadoConsulta != api.ApiResultOK {
-		return fmt.Errorf("API returned non-OK result: %s", pricesResponse.ResultadoConsulta)
+		return err
 	}
-
-	jsonData, err := json.Marshal(pricesResponse)
-	if err != nil {
-		return fmt.Errorf("error marshaling JSON: %w", err)
+	if len(report.Failed) > 0 {
+		s.log.Warn("Some days could not be fetched", "failed", len(report.Failed))
 	}
 
-	if err := s.SavePrices(ctx, endDate.AddDate(0, 0, 1), jsonData); err != nil {
+	if err := s.UpdateDB(ctx); err != nil {
 		return fmt.Errorf("error saving data for today: %w", err)
 	}
 
@@ -688,7 +773,8 @@ func (s *Storage) CreateLocationLogsTable(ctx context.Context) error {
 		distance REAL NOT NULL,
 		search_count INTEGER NOT NULL DEFAULT 1,
 		search_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		last_search TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		last_search TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		geohash TEXT NOT NULL DEFAULT ''
 	);
 
 	-- Index for faster searches on location coordinates
@@ -700,17 +786,23 @@ func (s *Storage) CreateLocationLogsTable(ctx context.Context) error {
 		return fmt.Errorf("error creating location_logs table: %w", err)
 	}
 
+	if err := s.migrateLocationLogs(ctx); err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS idx_location_logs_geohash ON location_logs (geohash)")
+	if err != nil {
+		return fmt.Errorf("error creating geohash index: %w", err)
+	}
+
+	if err := s.createSearchEventsTable(ctx); err != nil {
+		return err
+	}
+
 	s.log.Debug("Location logs table created or verified")
 	return nil
 }
 
-func reduceLocationPrecision(lat, lng float64, decimalPlaces int) (roundedLat, roundedLng float64) {
-	factor := math.Pow(decimalBase, float64(decimalPlaces))
-	roundedLat = math.Round(lat*factor) / factor
-	roundedLng = math.Round(lng*factor) / factor
-	return
-}
-
 func configureSQLitePragmas(ctx context.Context, db *sql.DB, forMigration bool, cacheSize int) error {
 	if _, err := db.ExecContext(ctx, "PRAGMA busy_timeout = 10000;"); err != nil {
 		return fmt.Errorf("error setting busy timeout: %w", err)
@@ -756,21 +848,14 @@ func configureSQLitePragmas(ctx context.Context, db *sql.DB, forMigration bool,
 	return nil
 }
 
+// LogSearchLocation records a search in the geohash cell containing the
+// given coordinates. Only the cell center is stored.
 func (s *Storage) LogSearchLocation(ctx context.Context, latitude, longitude, distance float64) error {
-	// First check if a similar location (with small tolerance) exists
+	hash := geohashEncode(latitude, longitude, s.locationLog.GeohashPrecision)
+	cellLat, cellLng := geohashCenter(hash)
 
 	var id int64
-	var count int
-
-	newLat, newLong := reduceLocationPrecision(latitude, longitude, defaultReducePrecisionDecimalPlace)
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, search_count FROM location_logs
-		WHERE latitude = ?
-		AND longitude = ?
-		ORDER BY ABS(latitude - ?) + ABS(longitude - ?) ASC
-		LIMIT 1
-	`, newLat, newLong, newLat, newLong).Scan(&id, &count)
-
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM location_logs WHERE geohash = ? LIMIT 1", hash).Scan(&id)
 	if err != nil && err != sql.ErrNoRows {
 		return fmt.Errorf("error checking for existing location: %w", err)
 	}
@@ -778,9 +863,9 @@ func (s *Storage) LogSearchLocation(ctx context.Context, latitude, longitude, di
 	if err == sql.ErrNoRows {
 		// Insert new location
 		_, err := s.db.ExecContext(ctx, `
-			INSERT INTO location_logs (latitude, longitude, distance)
-			VALUES (?, ?, ?)
-		`, latitude, longitude, distance)
+			INSERT INTO location_logs (geohash, latitude, longitude, distance)
+			VALUES (?, ?, ?, ?)
+		`, hash, cellLat, cellLng, distance)
 
 		if err != nil {
 			return fmt.Errorf("error logging search location: %w", err)
@@ -798,12 +883,13 @@ func (s *Storage) LogSearchLocation(ctx context.Context, latitude, longitude, di
 		}
 	}
 
-	return nil
+	return s.recordSearchEvent(ctx, hash, cellLat, cellLng, time.Now())
 }
 
-// LocationLog represents a row in the location_logs table
+// LocationLog represents a geohash cell of the location_logs table
 type LocationLog struct {
 	ID          int64
+	Geohash     string
 	Latitude    float64
 	Longitude   float64
 	Distance    float64
@@ -812,19 +898,27 @@ type LocationLog struct {
 	LastSearch  time.Time
 }
 
-// GetLocationLogs retrieves location logs from the database
+// GetLocationLogs retrieves the searched cells, most searched first.
+// Cells searched fewer times than the k-anonymity threshold are left out.
 // limit: maximum number of rows to return (0 for all)
-// orderBy: "count" for most searched or "time" for most recent
 func (s *Storage) GetLocationLogs(ctx context.Context, limit int) ([]LocationLog, error) {
-	query := `SELECT id, latitude, longitude, distance, search_count, search_time, last_search
+	query := fmt.Sprintf(`SELECT MIN(id), geohash, latitude, longitude, MAX(distance), SUM(search_count),
+			  MIN(search_time), MAX(last_search)
 			  FROM location_logs
-			  ORDER BY search_count DESC `
+			  GROUP BY geohash
+			  HAVING SUM(search_count) >= %d
+			  ORDER BY SUM(search_count) DESC `, s.locationLog.MinCount)
 
 	if limit > 0 {
 		query += fmt.Sprintf("LIMIT %d", limit)
 	}
 
-	rows, err := s.db.QueryContext(ctx, query)
+	return s.queryLocationLogs(ctx, query)
+}
+
+// queryLocationLogs scans location log rows selected in LocationLog field order.
+func (s *Storage) queryLocationLogs(ctx context.Context, query string, args ...any) ([]LocationLog, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving location logs: %w", err)
 	}
@@ -833,17 +927,21 @@ func (s *Storage) GetLocationLogs(ctx context.Context, limit int) ([]LocationLog
 	var logs []LocationLog
 	for rows.Next() {
 		var logEntry LocationLog
+		var searchTime, lastSearch string
 		if err := rows.Scan(
 			&logEntry.ID,
+			&logEntry.Geohash,
 			&logEntry.Latitude,
 			&logEntry.Longitude,
 			&logEntry.Distance,
 			&logEntry.SearchCount,
-			&logEntry.SearchTime,
-			&logEntry.LastSearch,
+			&searchTime,
+			&lastSearch,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning location log: %w", err)
 		}
+		logEntry.SearchTime = parseSQLiteTime(searchTime)
+		logEntry.LastSearch = parseSQLiteTime(lastSearch)
 		logs = append(logs, logEntry)
 	}
 
@@ -854,164 +952,28 @@ func (s *Storage) GetLocationLogs(ctx context.Context, limit int) ([]LocationLog
 	return logs, nil
 }
 
-// PopularLocation represents a clustered area of searches with its popularity
-type PopularLocation struct {
-	Latitude    float64 `json:"lat"`
-	Longitude   float64 `json:"lng"`
-	SearchCount int64   `json:"weight"` // Used as weight in heatmaps
-	Radius      float64 `json:"radius"` // Estimated radius of the cluster in km
+// DeleteOldRecords prunes raw snapshots and daily prices older than daysOld,
+// downsampling the daily prices into weekly averages.
+//
+// Deprecated: use Prune with a RetentionPolicy.
+func (s *Storage) DeleteOldRecords(ctx context.Context, daysOld int) error {
+	_, err := s.Prune(ctx, RetentionPolicy{Snapshots: daysOld, DailyPrices: daysOld}, false)
+	return err
 }
 
-// GetPopularLocationHeatmap returns data suitable for generating a heatmap
-// of popular search locations, with nearby searches clustered together
-func (s *Storage) GetPopularLocationHeatmap(ctx context.Context, limit int) ([]PopularLocation, error) {
-	// Get all location logs ordered by search count
-	logs, err := s.GetLocationLogs(ctx, 0)
+func (s *Storage) VacuumDatabase(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "PRAGMA incremental_vacuum(1000)")
 	if err != nil {
-		return nil, err
-	}
-
-	// Clustering parameters
-	const clusterDistance = 0.01 // Approximately 1km
-
-	// Map to track processed logs
-	processed := make(map[int64]bool)
-
-	var popularLocations []PopularLocation
-
-	// Process logs and create clusters
-	for i, log := range logs {
-		if processed[log.ID] {
-			continue
-		}
-
-		// Mark this log as processed
-		processed[log.ID] = true
-
-		// Create a new cluster with this log as center
-		cluster := PopularLocation{
-			Latitude:    log.Latitude,
-			Longitude:   log.Longitude,
-			SearchCount: log.SearchCount,
-			Radius:      log.Distance, // Start with search distance as radius
-		}
-
-		// Check for nearby logs to merge into this cluster
-		for j, otherLog := range logs {
-			if i == j || processed[otherLog.ID] {
-				continue
-			}
-
-			// Calculate distance between points
-			distance := math.Sqrt(
-				math.Pow(log.Latitude-otherLog.Latitude, squareExponent) +
-					math.Pow(log.Longitude-otherLog.Longitude, squareExponent))
-
-			if distance <= clusterDistance {
-				// Merge this log into the cluster
-				processed[otherLog.ID] = true
-
-				// Update cluster properties based on weighted average
-				totalWeight := cluster.SearchCount + otherLog.SearchCount
-				cluster.Latitude = (cluster.Latitude*float64(cluster.SearchCount) +
-					otherLog.Latitude*float64(otherLog.SearchCount)) / float64(totalWeight)
-				cluster.Longitude = (cluster.Longitude*float64(cluster.SearchCount) +
-					otherLog.Longitude*float64(otherLog.SearchCount)) / float64(totalWeight)
-
-				// Update search count and expand radius if needed
-				cluster.SearchCount += otherLog.SearchCount
-				if otherLog.Distance > cluster.Radius {
-					cluster.Radius = otherLog.Distance
-				}
-			}
-		}
-
-		popularLocations = append(popularLocations, cluster)
-	}
-
-	// Sort by search count (most popular first)
-	sort.Slice(popularLocations, func(i, j int) bool {
-		return popularLocations[i].SearchCount > popularLocations[j].SearchCount
-	})
-
-	return popularLocations, nil
-}
-
-func (s *Storage) DeleteOldRecords(ctx context.Context, daysOld int) error {
-	cutoffDate := time.Now().AddDate(0, 0, -daysOld).Format("2006-01-02")
-
-	// Very conservative approach - delete one record at a time
-	batchSize := 1000
-
-	s.log.Info("Starting cleanup of old records", "cutoff_date", cutoffDate)
-
-	// Delete fuel_prices one at a time to avoid memory issues
-	deletedCount := 0
-	for {
-		// Get one ROWID at a time
-		var rowid int64
-		err := s.db.QueryRowContext(ctx, "SELECT ROWID FROM fuel_prices WHERE date < ? ORDER BY ROWID LIMIT 1", cutoffDate).Scan(&rowid)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				break // No more records to delete
-			}
-			return fmt.Errorf("error querying fuel_prices ROWID: %w", err)
-		}
-
-		// Delete this record
-		_, err = s.db.ExecContext(ctx, "DELETE FROM fuel_prices WHERE ROWID = ?", rowid)
-		if err != nil {
-			return fmt.Errorf("error deleting fuel_prices record: %w", err)
-		}
-
-		deletedCount++
-
-		// Log progress and add delay every batch
-		if deletedCount%batchSize == 0 {
-			s.log.Debug("Deleted fuel_prices records", "count", deletedCount)
-			time.Sleep(deleteRecordsPause * time.Millisecond) // Longer delay
-		}
-	}
-
-	s.log.Info("Completed fuel_prices cleanup", "deleted_count", deletedCount)
-
-	// Delete historic_prices one at a time
-	deletedCount = 0
-	for {
-		// Get one ROWID at a time
-		var rowid int64
-		err := s.db.QueryRowContext(ctx, "SELECT ROWID FROM historic_prices WHERE date < ? ORDER BY ROWID LIMIT 1", cutoffDate).Scan(&rowid)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				break // No more records to delete
-			}
-			return fmt.Errorf("error querying historic_prices ROWID: %w", err)
-		}
-
-		// Delete this record
-		_, err = s.db.ExecContext(ctx, "DELETE FROM historic_prices WHERE ROWID = ?", rowid)
-		if err != nil {
-			return fmt.Errorf("error deleting historic_prices record: %w", err)
-		}
-
-		deletedCount++
-
-		// Log progress and add delay every batch
-		if deletedCount%batchSize == 0 {
-			s.log.Debug("Deleted historic_prices records", "count", deletedCount)
-			time.Sleep(deleteRecordsPause * time.Millisecond) // Longer delay
-		}
+		return fmt.Errorf("error performing incremental vacuum: %w", err)
 	}
 
-	s.log.Info("Completed historic_prices cleanup", "deleted_count", deletedCount)
-
 	return nil
 }
 
-func (s *Storage) VacuumDatabase(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, "PRAGMA incremental_vacuum(1000)")
-	if err != nil {
-		return fmt.Errorf("error performing incremental vacuum: %w", err)
+// Vacuum rebuilds the database file, returning all free pages to the filesystem.
+func (s *Storage) Vacuum(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
 	}
 
 	return nil
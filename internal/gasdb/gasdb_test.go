@@ -0,0 +1,94 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestNearbyPricesAt(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	save := func(date time.Time, stations ...api.GasStation) {
+		t.Helper()
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, date, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	far := testStation("2", "BP", "1,299", "")
+	far.Latitud, far.Longitud = "41,385100", "2,173400"
+	save(day1, testStation("1", "REPSOL", "1,459", "1,559"), far)
+	save(day2, testStation("1", "REPSOL", "1,499", "1,599"), far)
+
+	nearby, err := s.NearbyPricesAt(ctx, day1, 40.4168, -3.7038, 5000)
+	if err != nil {
+		t.Fatalf("NearbyPricesAt() failed: %v", err)
+	}
+	if len(nearby) != 1 || nearby[0].IDEESS != "1" || nearby[0].PrecioGasoleoA != "1,459" {
+		t.Errorf("unexpected stations for a past day: %+v", nearby)
+	}
+
+	if _, err := s.NearbyPricesAt(ctx, day1.AddDate(0, 0, 7), 40.4168, -3.7038, 5000); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData for a day not stored, got %v", err)
+	}
+
+	// Days older than the snapshot retention come from the normalized tables
+	report, err := s.Prune(ctx, RetentionPolicy{Snapshots: 30}, false)
+	if err != nil || report.Snapshots != 2 {
+		t.Fatalf("Prune() = %+v, %v", report, err)
+	}
+	nearby, err = s.NearbyPricesAt(ctx, day2, 40.4168, -3.7038, 5000)
+	if err != nil {
+		t.Fatalf("NearbyPricesAt() failed after pruning: %v", err)
+	}
+	if want := testStation("1", "REPSOL", "1,499", "1,599"); len(nearby) != 1 || !reflect.DeepEqual(*nearby[0], want) {
+		t.Errorf("unexpected stations for a pruned day: %+v", nearby)
+	}
+}
+
+func TestNewStorageMigrateSchema(t *testing.T) {
+	ctx := context.Background()
+	schema := func(s *Storage) []string {
+		t.Helper()
+		rows, err := s.db.Query("SELECT type || ' ' || name FROM sqlite_master ORDER BY name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var objects []string
+		for rows.Next() {
+			var object string
+			if err := rows.Scan(&object); err != nil {
+				t.Fatal(err)
+			}
+			objects = append(objects, object)
+		}
+		return objects
+	}
+
+	s := newTestStorage(t)
+	m, err := NewStorageMigrate(ctx, filepath.Join(t.TempDir(), "migrate.db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewStorageMigrate() failed: %v", err)
+	}
+	defer m.Close()
+
+	if got, want := schema(m), schema(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewStorageMigrate() schema differs from NewStorage()\n got: %v\nwant: %v", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// zoomPrecisions maps the highest map zoom level of each range to the
+// geohash length used to cluster searches at that zoom. Cells are roughly
+// 630 km, 78 km, 20 km, 2.4 km and 610 m wide.
+var zoomPrecisions = []struct {
+	maxZoom   int
+	precision int
+}{
+	{4, 2},
+	{7, 3},
+	{10, 4},
+	{13, 5},
+	{maxMapZoom, 6},
+}
+
+const maxMapZoom = 22
+
+// HeatmapQuery selects the searches aggregated by Heatmap.
+type HeatmapQuery struct {
+	// Zoom is the web map zoom level the points are clustered for.
+	Zoom int
+	// BBox restricts points to the visible area when set.
+	BBox *BBox
+	// Window only counts searches made within this long before now. Zero
+	// counts every stored search.
+	Window time.Duration
+}
+
+// HeatmapPoint is a cluster of searches. It marshals to the
+// [lat, lng, weight] arrays heatmap libraries such as Leaflet.heat expect.
+type HeatmapPoint struct {
+	Latitude  float64
+	Longitude float64
+	Weight    int64
+}
+
+func (p HeatmapPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]float64{p.Latitude, p.Longitude, float64(p.Weight)})
+}
+
+// zoomPrecision returns the geohash length searches are clustered to at zoom.
+func zoomPrecision(zoom int) int {
+	for _, zp := range zoomPrecisions {
+		if zoom <= zp.maxZoom {
+			return zp.precision
+		}
+	}
+	return zoomPrecisions[len(zoomPrecisions)-1].precision
+}
+
+// Heatmap clusters the logged searches into geohash cells sized for the
+// query zoom level, in a single aggregate query. Each point is placed at the
+// search-weighted centroid of the stored cells it merges. Clusters below the
+// k-anonymity threshold are left out.
+func (s *Storage) Heatmap(ctx context.Context, q HeatmapQuery) ([]HeatmapPoint, error) {
+	precision := min(zoomPrecision(q.Zoom), s.locationLog.GeohashPrecision)
+
+	var conditions []string
+	var args []any
+	if q.Window > 0 {
+		since := time.Now().Add(-q.Window).UTC().Truncate(searchEventBucket)
+		conditions = append(conditions, "bucket >= ?")
+		args = append(args, since.Format(sqliteTimeLayout))
+	}
+	if q.BBox != nil {
+		conditions = append(conditions, "latitude BETWEEN ? AND ?", "longitude BETWEEN ? AND ?")
+		args = append(args, q.BBox.MinLat, q.BBox.MaxLat, q.BBox.MinLng, q.BBox.MaxLng)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			SUM(latitude * search_count) / SUM(search_count),
+			SUM(longitude * search_count) / SUM(search_count),
+			SUM(search_count)
+		FROM location_log_events
+		%s
+		GROUP BY substr(geohash, 1, ?)
+		HAVING SUM(search_count) >= ?
+		ORDER BY SUM(search_count) DESC
+	`, where)
+	args = append(args, precision, s.locationLog.MinCount)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	points := []HeatmapPoint{}
+	for rows.Next() {
+		var p HeatmapPoint
+		if err := rows.Scan(&p.Latitude, &p.Longitude, &p.Weight); err != nil {
+			return nil, fmt.Errorf("error scanning heatmap point: %w", err)
+		}
+		points = append(points, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating heatmap points: %w", err)
+	}
+
+	return points, nil
+}
@@ -0,0 +1,59 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestHeatmap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	// Two neighbouring 5 character cells in Madrid and one in Barcelona
+	searches := []struct {
+		lat, lng float64
+		times    int
+	}{
+		{40.4168, -3.7038, 3},
+		{40.4600, -3.6900, 3},
+		{41.3874, 2.1686, 5},
+	}
+	for _, search := range searches {
+		for i := 0; i < search.times; i++ {
+			if err := s.LogSearchLocation(ctx, search.lat, search.lng, 5000); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// At street level the Madrid cells stay apart and fall below k = 5
+	points, err := s.Heatmap(ctx, HeatmapQuery{Zoom: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Weight != 5 {
+		t.Fatalf("expected only the Barcelona cell at zoom 14, got %+v", points)
+	}
+
+	// At country level they merge into a single cluster
+	points, err = s.Heatmap(ctx, HeatmapQuery{Zoom: 6, BBox: &BBox{MinLat: 40, MinLng: -4, MaxLat: 41, MaxLng: -3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Weight != 6 {
+		t.Fatalf("expected a merged Madrid cluster at zoom 6, got %+v", points)
+	}
+	if points[0].Latitude < 40.4 || points[0].Latitude > 40.5 {
+		t.Errorf("cluster centroid outside Madrid: %+v", points[0])
+	}
+
+	data, err := json.Marshal(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded [][3]float64
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded[0][2] != 6 {
+		t.Errorf("unexpected heatmap JSON %s: %v", data, err)
+	}
+}
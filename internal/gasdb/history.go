@@ -0,0 +1,112 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PricePoint is a single day of a station price series.
+type PricePoint struct {
+	Date  time.Time
+	Price float64
+	// Missing is set for days with no stored price for the station and fuel,
+	// either because no snapshot was saved or because the station did not
+	// publish one.
+	Missing bool
+}
+
+// PriceSeries is the daily price history of a station for one fuel.
+type PriceSeries struct {
+	IDEESS string
+	Fuel   Fuel
+	From   time.Time
+	To     time.Time
+	Points []PricePoint
+}
+
+// StationHistory returns one point per day between from and to for the given
+// station and fuel, with gaps marked as missing. A zero from or to defaults to
+// the first or last day with a stored price.
+func (s *Storage) StationHistory(ctx context.Context, ideess string, fuel Fuel, from, to time.Time) (*PriceSeries, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = from.Format("2006-01-02")
+	}
+	if !to.IsZero() {
+		toStr = to.Format("2006-01-02")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date, price FROM prices
+		WHERE ideess = ? AND fuel = ? AND date BETWEEN ? AND ?
+		ORDER BY date
+	`, ideess, string(fuel), fromStr, toStr)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station history: %w", err)
+	}
+	defer rows.Close()
+
+	prices := make(map[string]float64)
+	var first, last string
+	for rows.Next() {
+		var date string
+		var price float64
+		if err := rows.Scan(&date, &price); err != nil {
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+		if first == "" {
+			first = date
+		}
+		last = date
+		prices[date] = price
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating prices: %w", err)
+	}
+
+	series := &PriceSeries{IDEESS: ideess, Fuel: fuel, From: from, To: to}
+	if series.From.IsZero() && first != "" {
+		series.From, _ = time.Parse("2006-01-02", first)
+	}
+	if series.To.IsZero() && last != "" {
+		series.To, _ = time.Parse("2006-01-02", last)
+	}
+	if series.From.IsZero() || series.To.IsZero() {
+		return series, nil
+	}
+
+	for d := series.From; !d.After(series.To); d = d.AddDate(0, 0, 1) {
+		price, ok := prices[d.Format("2006-01-02")]
+		series.Points = append(series.Points, PricePoint{Date: d, Price: price, Missing: !ok})
+	}
+
+	return series, nil
+}
+
+// ChangePoints collapses runs of identical consecutive points, keeping only
+// the days where the price changed or a gap started or ended.
+func (ps *PriceSeries) ChangePoints() []PricePoint {
+	var changes []PricePoint
+	for i, p := range ps.Points {
+		if i > 0 {
+			prev := ps.Points[i-1]
+			if prev.Missing == p.Missing && prev.Price == p.Price {
+				continue
+			}
+		}
+		changes = append(changes, p)
+	}
+	return changes
+}
+
+// Available returns the points that have a price.
+func (ps *PriceSeries) Available() []PricePoint {
+	var available []PricePoint
+	for _, p := range ps.Points {
+		if !p.Missing {
+			available = append(available, p)
+		}
+	}
+	return available
+}
@@ -0,0 +1,65 @@
+package gasdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStationHistory(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	from := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	series, err := s.StationHistory(ctx, "1", FuelGasoleoA, from, to)
+	if err != nil {
+		t.Fatalf("StationHistory() failed: %v", err)
+	}
+
+	want := []PricePoint{
+		{Date: from, Missing: true},
+		{Date: from.AddDate(0, 0, 1), Price: 1.459},
+		{Date: from.AddDate(0, 0, 2), Price: 1.449},
+		{Date: from.AddDate(0, 0, 3), Missing: true},
+	}
+	if len(series.Points) != len(want) {
+		t.Fatalf("expected %d points, got %d", len(want), len(series.Points))
+	}
+	for i, p := range series.Points {
+		if !p.Date.Equal(want[i].Date) || p.Missing != want[i].Missing || p.Price != want[i].Price {
+			t.Errorf("point %d: got %+v, want %+v", i, p, want[i])
+		}
+	}
+
+	// Station 2 only published gasolina 95 on the second day
+	series, err = s.StationHistory(ctx, "2", FuelGasolina95E5, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Points) != 1 || series.Points[0].Price != 1.609 {
+		t.Errorf("unexpected default range series: %+v", series.Points)
+	}
+}
+
+func TestChangePoints(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	series := &PriceSeries{Points: []PricePoint{
+		{Date: day, Price: 1.5},
+		{Date: day.AddDate(0, 0, 1), Price: 1.5},
+		{Date: day.AddDate(0, 0, 2), Missing: true},
+		{Date: day.AddDate(0, 0, 3), Missing: true},
+		{Date: day.AddDate(0, 0, 4), Price: 1.5},
+		{Date: day.AddDate(0, 0, 5), Price: 1.4},
+	}}
+
+	changes := series.ChangePoints()
+	var days []int
+	for _, p := range changes {
+		days = append(days, p.Date.Day())
+	}
+	if len(days) != 4 || days[0] != 1 || days[1] != 3 || days[2] != 5 || days[3] != 6 {
+		t.Errorf("unexpected change points: %v", days)
+	}
+}
@@ -0,0 +1,232 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// Transformer normalizes a station of a snapshot before it is validated and
+// written. It modifies the station in place.
+type Transformer interface {
+	Transform(station *api.GasStation)
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(station *api.GasStation)
+
+func (f TransformerFunc) Transform(station *api.GasStation) { f(station) }
+
+// Validator checks a normalized station. Stations failing any validator are
+// not written and are counted as rejected in the ingestion statistics.
+type Validator interface {
+	Validate(station *api.GasStation) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface.
+type ValidatorFunc func(station *api.GasStation) error
+
+func (f ValidatorFunc) Validate(station *api.GasStation) error { return f(station) }
+
+// TrimFields removes the surrounding whitespace of every mapped station and
+// price field. It is always the first transformer.
+var TrimFields = TransformerFunc(func(station *api.GasStation) {
+	station.IDEESS = strings.TrimSpace(station.IDEESS)
+	for _, f := range stationFields {
+		field := f.field(station)
+		*field = strings.TrimSpace(*field)
+	}
+	for _, f := range fuelFields {
+		field := f.field(station)
+		*field = strings.TrimSpace(*field)
+	}
+})
+
+// RequireIDEESS rejects stations without an id. It is always the first
+// validator.
+var RequireIDEESS = ValidatorFunc(func(station *api.GasStation) error {
+	if station.IDEESS == "" {
+		return errors.New("missing IDEESS")
+	}
+	return nil
+})
+
+// WithTransformers adds transformers run after TrimFields, in order, on
+// every station ingested.
+func WithTransformers(transformers ...Transformer) Option {
+	return func(o *options) { o.transformers = append(o.transformers, transformers...) }
+}
+
+// WithValidators adds validators run after RequireIDEESS on every station
+// ingested.
+func WithValidators(validators ...Validator) Option {
+	return func(o *options) { o.validators = append(o.validators, validators...) }
+}
+
+// IngestionStats describes the ingestion of a snapshot into the normalized
+// tables.
+type IngestionStats struct {
+	Date       string
+	IngestedAt time.Time
+	// Stations is the number of stations listed in the snapshot, Written
+	// the ones stored and Rejected the ones that failed validation.
+	Stations int
+	Written  int
+	Rejected int
+	// Prices is the number of prices stored for the day.
+	Prices   int
+	Duration time.Duration
+}
+
+// createIngestionStatsTable creates ingestion_stats, which records the last
+// ingestion of every snapshot.
+func (s *Storage) createIngestionStatsTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS ingestion_stats (
+		date TEXT PRIMARY KEY,
+		ingested_at TEXT NOT NULL,
+		stations INTEGER NOT NULL,
+		written INTEGER NOT NULL,
+		rejected INTEGER NOT NULL,
+		prices INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL
+	);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating ingestion_stats table: %w", err)
+	}
+	return nil
+}
+
+// dropLegacyTrigger drops the SQL trigger that derived the normalized rows
+// before ingestion moved to Go. Left in place it would ingest every snapshot
+// twice, the second time without validation.
+func (s *Storage) dropLegacyTrigger(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "DROP TRIGGER IF EXISTS insert_historic_prices"); err != nil {
+		return fmt.Errorf("error dropping ingestion trigger: %w", err)
+	}
+	return nil
+}
+
+// prepare normalizes and validates the stations of a snapshot, returning
+// the ones to write.
+func (s *Storage) prepare(date string, stations []api.GasStation) (accepted []*api.GasStation, rejected int) {
+	for i := range stations {
+		station := &stations[i]
+		for _, t := range s.transformers {
+			t.Transform(station)
+		}
+
+		var err error
+		for _, v := range s.validators {
+			if err = v.Validate(station); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			s.log.Debug("Rejected station", "date", date, "ideess", station.IDEESS, "error", err)
+			rejected++
+			continue
+		}
+		accepted = append(accepted, station)
+	}
+	return accepted, rejected
+}
+
+// ingest derives the station_days and prices rows of the snapshot of date
+// within tx: the stations are normalized and validated, the rows previously
+// derived for the day are replaced, anomalies are quarantined, the station
+// lifecycle events are recorded and the statistics of the run are saved.
+// It returns the statistics and the anomalies new to the day.
+func (s *Storage) ingest(ctx context.Context, tx *sql.Tx, w *snapshotWriter, date string, stations []api.GasStation) (*IngestionStats, []Anomaly, error) {
+	start := time.Now()
+	stats := &IngestionStats{Date: date, Stations: len(stations)}
+
+	accepted, rejected := s.prepare(date, stations)
+	stats.Rejected = rejected
+	if rejected > 0 {
+		s.log.Warn("Rejected invalid stations", "date", date, "rejected", rejected)
+	}
+
+	written, err := w.writeDay(ctx, date, accepted)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats.Written = written
+
+	anomalies, err := s.detectAnomalies(ctx, tx, date, accepted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(anomalies) > 0 {
+		s.log.Warn("Quarantined anomalies", "date", date, "anomalies", len(anomalies))
+	}
+
+	events, err := deriveStationEvents(ctx, tx, date)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.log.Debug("Recorded station events", "date", date, "events", events)
+
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM prices WHERE date = ?", date).Scan(&stats.Prices); err != nil {
+		return nil, nil, fmt.Errorf("error counting prices for %s: %w", date, err)
+	}
+
+	stats.IngestedAt = time.Now().UTC()
+	stats.Duration = time.Since(start)
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO ingestion_stats (date, ingested_at, stations, written, rejected, prices, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, stats.Date, stats.IngestedAt.Format(time.RFC3339Nano), stats.Stations, stats.Written, stats.Rejected, stats.Prices, stats.Duration.Milliseconds())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error saving ingestion stats for %s: %w", date, err)
+	}
+	return stats, anomalies, nil
+}
+
+// IngestionStats returns the statistics of the last ingestion of every
+// snapshot between from and to, in date order. A zero from or to leaves that
+// end of the range open.
+func (s *Storage) IngestionStats(ctx context.Context, from, to time.Time) ([]IngestionStats, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = formatDate(from)
+	}
+	if !to.IsZero() {
+		toStr = formatDate(to)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date, ingested_at, stations, written, rejected, prices, duration_ms
+		FROM ingestion_stats
+		WHERE date BETWEEN ? AND ?
+		ORDER BY date
+	`, fromStr, toStr)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ingestion stats: %w", err)
+	}
+	defer rows.Close()
+
+	var result []IngestionStats
+	for rows.Next() {
+		var st IngestionStats
+		var ingestedAt string
+		var durationMs int64
+		if err := rows.Scan(&st.Date, &ingestedAt, &st.Stations, &st.Written, &st.Rejected, &st.Prices, &durationMs); err != nil {
+			return nil, fmt.Errorf("error scanning ingestion stats: %w", err)
+		}
+		st.IngestedAt = parseSQLiteTime(ingestedAt)
+		st.Duration = time.Duration(durationMs) * time.Millisecond
+		result = append(result, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ingestion stats: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestIngestionPipeline(t *testing.T) {
+	ctx := context.Background()
+	prefix := TransformerFunc(func(st *api.GasStation) { st.Rotulo = "BRAND " + st.Rotulo })
+	noCepsa := ValidatorFunc(func(st *api.GasStation) error {
+		if st.Rotulo == "BRAND CEPSA" {
+			return errors.New("blocked brand")
+		}
+		return nil
+	})
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler),
+		WithTransformers(prefix), WithValidators(noCepsa))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	padded := testStation(" 3 ", "  REPSOL ", " 1,429 ", "")
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{
+		testStation("1", "REPSOL", "1,459", "1,599"),
+		testStation("2", "CEPSA", "1,479", ""),
+		testStation("", "BP", "1,489", ""),
+		padded,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.SavePrices(ctx, date, data); err != nil {
+		t.Fatalf("SavePrices() failed: %v", err)
+	}
+
+	var rotulo string
+	var price float64
+	err = s.db.QueryRow(`
+		SELECT s.rotulo, p.price FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		JOIN prices p ON p.date = d.date AND p.ideess = d.ideess
+		WHERE d.ideess = '3'`).Scan(&rotulo, &price)
+	if err != nil {
+		t.Fatalf("trimmed station not stored: %v", err)
+	}
+	if rotulo != "BRAND REPSOL" || price != 1.429 {
+		t.Errorf("unexpected normalized station: %q, %.3f", rotulo, price)
+	}
+
+	stats, err := s.IngestionStats(ctx, date, date)
+	if err != nil {
+		t.Fatalf("IngestionStats() failed: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected stats for one snapshot, got %+v", stats)
+	}
+	got := stats[0]
+	if got.Stations != 4 || got.Written != 2 || got.Rejected != 2 || got.Prices != 3 || got.IngestedAt.IsZero() {
+		t.Errorf("unexpected ingestion stats: %+v", got)
+	}
+
+	// Rejected stations are not reported as missing derived rows
+	report, err := s.Doctor(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("unexpected problems: %+v", report.Problems)
+	}
+
+	// Migrations go through the same pipeline
+	if _, err := s.db.Exec("DELETE FROM prices; DELETE FROM station_days; DELETE FROM ingestion_stats"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MigrateToHistoricPrices(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = s.IngestionStats(ctx, time.Time{}, time.Time{})
+	if err != nil || len(stats) != 1 || stats[0].Written != 2 || stats[0].Prices != 3 {
+		t.Errorf("unexpected stats after migration: %+v, %v", stats, err)
+	}
+
+	var triggers int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'insert_historic_prices'").Scan(&triggers); err != nil || triggers != 0 {
+		t.Errorf("the ingestion trigger still exists")
+	}
+}
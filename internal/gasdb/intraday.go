@@ -0,0 +1,228 @@
+package gasdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// publicationLayout is the format of the Fecha field of the ministry
+// snapshots, in Madrid time.
+const publicationLayout = "02/01/2006 15:04:05"
+
+// IntradayPoint is a price published for a station during the day.
+type IntradayPoint struct {
+	// FetchedAt is when the snapshot was fetched, PublishedAt its
+	// publication time, zero if the snapshot had no valid Fecha.
+	FetchedAt   time.Time
+	PublishedAt time.Time
+	Price       float64
+}
+
+// createIntradayTables creates intraday_snapshots, which keeps every
+// distinct snapshot fetched during the day, and intraday_prices, which holds
+// the prices that changed in each of them. fuel_prices remains the
+// end-of-day view: each fetch replaces the snapshot of the day.
+func (s *Storage) createIntradayTables(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS intraday_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		fetched_at TEXT NOT NULL,
+		published_at TEXT NOT NULL DEFAULT '',
+		date TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		data BLOB NOT NULL,
+		codec TEXT NOT NULL DEFAULT 'none',
+		UNIQUE(fetched_at, published_at)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_intraday_snapshots_date ON intraday_snapshots (date);
+
+	CREATE TABLE IF NOT EXISTS intraday_prices (
+		snapshot_id INTEGER NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		PRIMARY KEY (snapshot_id, ideess, fuel)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_intraday_prices_ideess_fuel ON intraday_prices (ideess, fuel, snapshot_id);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating intraday tables: %w", err)
+	}
+	return nil
+}
+
+// stationsHash returns the hash of the station list of a snapshot. Fecha is
+// left out, so a publication without price changes hashes the same.
+func stationsHash(prices *api.GasStationList) (string, error) {
+	data, err := json.Marshal(prices.ListaEESSPrecio)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling stations: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// stationPrices returns the valid prices of a snapshot keyed by station and
+// fuel.
+func stationPrices(prices *api.GasStationList) map[[2]string]float64 {
+	result := make(map[[2]string]float64)
+	for i := range prices.ListaEESSPrecio {
+		station := &prices.ListaEESSPrecio[i]
+		for _, f := range fuelFields {
+			if price, ok := parsePrice(f.get(station)); ok {
+				result[[2]string{station.IDEESS, string(f.fuel)}] = price
+			}
+		}
+	}
+	return result
+}
+
+// parsePublication returns the publication time of a Fecha value, or an
+// empty string if it cannot be parsed.
+func parsePublication(fecha string) string {
+	t, err := time.ParseInLocation(publicationLayout, fecha, BusinessLocation)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// SaveIntraday stores a snapshot fetched at fetchedAt in the intraday
+// history, unless its stations are the same as in the last one stored. Only
+// the prices that changed since that snapshot are recorded in
+// intraday_prices. It reports whether the snapshot was stored.
+func (s *Storage) SaveIntraday(ctx context.Context, fetchedAt time.Time, data []byte) (bool, error) {
+	var prices api.GasStationList
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return false, fmt.Errorf("error unmarshaling data: %w", err)
+	}
+	hash, err := stationsHash(&prices)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	previous := map[[2]string]float64{}
+	var lastHash, lastCodec string
+	var lastBlob []byte
+	err = tx.QueryRowContext(ctx, "SELECT hash, data, codec FROM intraday_snapshots ORDER BY id DESC LIMIT 1").
+		Scan(&lastHash, &lastBlob, &lastCodec)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return false, fmt.Errorf("error querying last intraday snapshot: %w", err)
+	case lastHash == hash:
+		s.log.Debug("Intraday snapshot unchanged", "hash", hash)
+		return false, nil
+	default:
+		lastData, err := decodeSnapshot(lastBlob, lastCodec)
+		if err != nil {
+			return false, err
+		}
+		var last api.GasStationList
+		if err := json.Unmarshal(lastData, &last); err != nil {
+			return false, fmt.Errorf("error unmarshaling last intraday snapshot: %w", err)
+		}
+		previous = stationPrices(&last)
+	}
+
+	blob, codec, err := encodeSnapshot(data)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO intraday_snapshots (fetched_at, published_at, date, hash, data, codec)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, fetchedAt.UTC().Format(time.RFC3339), parsePublication(prices.Fecha), formatDate(fetchedAt), hash, blob, codec)
+	if err != nil {
+		return false, fmt.Errorf("error inserting intraday snapshot: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("error reading intraday snapshot id: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO intraday_prices (snapshot_id, ideess, fuel, price) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return false, fmt.Errorf("error preparing intraday prices insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for key, price := range stationPrices(&prices) {
+		if last, ok := previous[key]; ok && last == price {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, id, key[0], key[1], price); err != nil {
+			return false, fmt.Errorf("error inserting intraday price: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return true, nil
+}
+
+// IntradayHistory returns the prices published for a station and fuel
+// between the business dates from and to, one point per snapshot where the
+// price changed. A zero from or to leaves that end of the range open.
+func (s *Storage) IntradayHistory(ctx context.Context, ideess string, fuel Fuel, from, to time.Time) ([]IntradayPoint, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = formatDate(from)
+	}
+	if !to.IsZero() {
+		toStr = formatDate(to)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.fetched_at, s.published_at, p.price
+		FROM intraday_prices p
+		JOIN intraday_snapshots s ON s.id = p.snapshot_id
+		WHERE p.ideess = ? AND p.fuel = ? AND s.date BETWEEN ? AND ?
+		ORDER BY p.snapshot_id
+	`, ideess, string(fuel), fromStr, toStr)
+	if err != nil {
+		return nil, fmt.Errorf("error querying intraday history: %w", err)
+	}
+	defer rows.Close()
+
+	var points []IntradayPoint
+	for rows.Next() {
+		var fetchedAt, publishedAt string
+		var p IntradayPoint
+		if err := rows.Scan(&fetchedAt, &publishedAt, &p.Price); err != nil {
+			return nil, fmt.Errorf("error scanning intraday price: %w", err)
+		}
+		if p.FetchedAt, err = time.Parse(time.RFC3339, fetchedAt); err != nil {
+			return nil, fmt.Errorf("error parsing fetch time %s: %w", fetchedAt, err)
+		}
+		if publishedAt != "" {
+			p.PublishedAt, _ = time.Parse(time.RFC3339, publishedAt)
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating intraday prices: %w", err)
+	}
+	return points, nil
+}
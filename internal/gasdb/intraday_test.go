@@ -0,0 +1,86 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestIntraday(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	morning := time.Date(2024, 1, 2, 8, 0, 0, 0, BusinessLocation)
+	fetches := []struct {
+		at     time.Time
+		fecha  string
+		diesel string
+		saved  bool
+	}{
+		{morning, "02/01/2024 07:55:00", "1,459", true},
+		// A new publication with the same prices is not stored
+		{morning.Add(6 * time.Hour), "02/01/2024 13:55:00", "1,459", false},
+		{morning.Add(12 * time.Hour), "02/01/2024 19:55:00", "1,439", true},
+		{morning.Add(18 * time.Hour), "03/01/2024 01:55:00", "1,449", true},
+	}
+	for _, f := range fetches {
+		data, err := json.Marshal(api.GasStationList{
+			Fecha: f.fecha,
+			ListaEESSPrecio: []api.GasStation{
+				testStation("1", "REPSOL", f.diesel, "1,599"),
+				testStation("2", "CEPSA", "1,479", ""),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved, err := s.SaveIntraday(ctx, f.at, data)
+		if err != nil {
+			t.Fatalf("SaveIntraday() failed: %v", err)
+		}
+		if saved != f.saved {
+			t.Errorf("SaveIntraday(%s) = %v, want %v", f.at, saved, f.saved)
+		}
+	}
+
+	points, err := s.IntradayHistory(ctx, "1", FuelGasoleoA, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("IntradayHistory() failed: %v", err)
+	}
+	want := []float64{1.459, 1.439, 1.449}
+	if len(points) != len(want) {
+		t.Fatalf("expected %d points, got %+v", len(want), points)
+	}
+	for i, p := range points {
+		if p.Price != want[i] {
+			t.Errorf("point %d: price %.3f, want %.3f", i, p.Price, want[i])
+		}
+	}
+	if !points[0].FetchedAt.Equal(morning) || !points[0].PublishedAt.Equal(morning.Add(-5*time.Minute)) {
+		t.Errorf("unexpected times: %+v", points[0])
+	}
+
+	// Unchanged prices are only recorded by the first snapshot
+	points, err = s.IntradayHistory(ctx, "2", FuelGasoleoA, time.Time{}, time.Time{})
+	if err != nil || len(points) != 1 {
+		t.Errorf("expected a single point for an unchanged station, got %+v, %v", points, err)
+	}
+
+	// The last fetch is past midnight in Madrid, so it belongs to the next day
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	points, err = s.IntradayHistory(ctx, "1", FuelGasoleoA, day, day)
+	if err != nil || len(points) != 2 {
+		t.Errorf("expected 2 points on %s, got %+v, %v", day.Format("2006-01-02"), points, err)
+	}
+
+	report, err := s.Prune(ctx, RetentionPolicy{Intraday: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.IntradaySnapshots != 3 || report.IntradayPrices != 5 {
+		t.Errorf("unexpected prune report: %+v", report)
+	}
+}
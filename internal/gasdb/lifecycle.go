@@ -0,0 +1,393 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// StationEventKind classifies the changes in the lifecycle of a station.
+type StationEventKind string
+
+const (
+	// StationOpened is a station listed for the first time, or again after
+	// missing from the previous snapshot.
+	StationOpened StationEventKind = "opened"
+	// StationClosed is a station listed in the previous snapshot but not in
+	// this one.
+	StationClosed StationEventKind = "closed"
+	// StationRebranded is a station whose Rotulo changed.
+	StationRebranded StationEventKind = "rebranded"
+	// StationMoved is a station whose coordinates changed.
+	StationMoved StationEventKind = "moved"
+	// StationScheduleChanged is a station whose Horario changed.
+	StationScheduleChanged StationEventKind = "schedule_changed"
+)
+
+// minStationMove is the distance in meters a station must move to record a
+// StationMoved event, so rounding changes in the coordinates are ignored.
+const minStationMove = 50
+
+// StationEvent is a change of a station between a snapshot and the previous
+// one stored.
+type StationEvent struct {
+	Date   string
+	IDEESS string
+	Kind   StationEventKind
+	// Old and New are the values before and after the change: the brand for
+	// openings, closures and rebrandings, the coordinates for moves and the
+	// opening hours for schedule changes.
+	Old string
+	New string
+}
+
+// StationEventFilter filters StationEvents. Zero fields match everything.
+type StationEventFilter struct {
+	IDEESS string
+	Kind   StationEventKind
+	From   time.Time
+	To     time.Time
+}
+
+// createStationEventsTable creates station_events, the lifecycle log of the
+// stations derived from consecutive snapshots.
+func (s *Storage) createStationEventsTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS station_events (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		old_value TEXT NOT NULL DEFAULT '',
+		new_value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (date, ideess, kind)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_station_events_ideess ON station_events (ideess, date);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating station_events table: %w", err)
+	}
+	return nil
+}
+
+// stationState is the part of a station tracked by the lifecycle log.
+type stationState struct {
+	rotulo, horario, latitud, longitud string
+}
+
+// dayStations returns the stations listed on date.
+func dayStations(ctx context.Context, tx *sql.Tx, date string) (map[string]stationState, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT d.ideess, s.rotulo, s.horario, s.latitud, s.longitud FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		WHERE d.date = ?
+	`, date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying stations of %s: %w", date, err)
+	}
+	defer rows.Close()
+
+	stations := make(map[string]stationState)
+	for rows.Next() {
+		var ideess string
+		var st stationState
+		if err := rows.Scan(&ideess, &st.rotulo, &st.horario, &st.latitud, &st.longitud); err != nil {
+			return nil, fmt.Errorf("error scanning station: %w", err)
+		}
+		stations[ideess] = st
+	}
+	return stations, rows.Err()
+}
+
+// moved reports whether a station moved between two sets of coordinates.
+// Unparsable coordinates are compared as text.
+func moved(prev, cur stationState) bool {
+	prevLat, err1 := ParseLatLong(prev.latitud)
+	prevLng, err2 := ParseLatLong(prev.longitud)
+	curLat, err3 := ParseLatLong(cur.latitud)
+	curLng, err4 := ParseLatLong(cur.longitud)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return prev.latitud != cur.latitud || prev.longitud != cur.longitud
+	}
+	return gpx.Distance2D(prevLat, prevLng, curLat, curLng, true) > minStationMove
+}
+
+// stationEvents compares the stations of a day with the ones of the previous
+// day stored. With no previous day every station is recorded as opened.
+func stationEvents(date string, prev, cur map[string]stationState) []StationEvent {
+	var events []StationEvent
+	for ideess, st := range cur {
+		p, ok := prev[ideess]
+		if !ok {
+			events = append(events, StationEvent{Date: date, IDEESS: ideess, Kind: StationOpened, New: st.rotulo})
+			continue
+		}
+		if p.rotulo != st.rotulo {
+			events = append(events, StationEvent{Date: date, IDEESS: ideess, Kind: StationRebranded, Old: p.rotulo, New: st.rotulo})
+		}
+		if moved(p, st) {
+			events = append(events, StationEvent{
+				Date: date, IDEESS: ideess, Kind: StationMoved,
+				Old: p.latitud + " " + p.longitud, New: st.latitud + " " + st.longitud,
+			})
+		}
+		if p.horario != st.horario {
+			events = append(events, StationEvent{Date: date, IDEESS: ideess, Kind: StationScheduleChanged, Old: p.horario, New: st.horario})
+		}
+	}
+	for ideess, p := range prev {
+		if _, ok := cur[ideess]; !ok {
+			events = append(events, StationEvent{Date: date, IDEESS: ideess, Kind: StationClosed, Old: p.rotulo})
+		}
+	}
+	return events
+}
+
+// replaceStationEvents replaces the events of date.
+func replaceStationEvents(ctx context.Context, tx *sql.Tx, date string, events []StationEvent) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM station_events WHERE date = ?", date); err != nil {
+		return fmt.Errorf("error clearing station events for %s: %w", date, err)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO station_events (date, ideess, kind, old_value, new_value)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing station event insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, e := range events {
+		if _, err := stmt.ExecContext(ctx, e.Date, e.IDEESS, string(e.Kind), e.Old, e.New); err != nil {
+			return fmt.Errorf("error saving station event of %s: %w", e.IDEESS, err)
+		}
+	}
+	return nil
+}
+
+// deriveStationEvents records the changes of the stations of date since the
+// previous day stored. Snapshots can be ingested in any order, so the events
+// of the next day stored are derived again against date too.
+func deriveStationEvents(ctx context.Context, tx *sql.Tx, date string) (int, error) {
+	var prevDate, nextDate string
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE((SELECT MAX(date) FROM station_days WHERE date < ?), ''),
+			COALESCE((SELECT MIN(date) FROM station_days WHERE date > ?), '')
+	`, date, date).Scan(&prevDate, &nextDate)
+	if err != nil {
+		return 0, fmt.Errorf("error querying days around %s: %w", date, err)
+	}
+
+	var prev map[string]stationState
+	if prevDate != "" {
+		if prev, err = dayStations(ctx, tx, prevDate); err != nil {
+			return 0, err
+		}
+	}
+	cur, err := dayStations(ctx, tx, date)
+	if err != nil {
+		return 0, err
+	}
+	events := stationEvents(date, prev, cur)
+	if err := replaceStationEvents(ctx, tx, date, events); err != nil {
+		return 0, err
+	}
+
+	if nextDate != "" {
+		next, err := dayStations(ctx, tx, nextDate)
+		if err != nil {
+			return 0, err
+		}
+		if err := replaceStationEvents(ctx, tx, nextDate, stationEvents(nextDate, cur, next)); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), nil
+}
+
+// RebuildStationEvents derives the lifecycle log again from every day
+// stored, for databases created before it was recorded during ingestion.
+func (s *Storage) RebuildStationEvents(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT date FROM station_days ORDER BY date")
+	if err != nil {
+		return fmt.Errorf("error querying days: %w", err)
+	}
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning day: %w", err)
+		}
+		dates = append(dates, date)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating days: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM station_events"); err != nil {
+		return fmt.Errorf("error clearing station events: %w", err)
+	}
+	var prev map[string]stationState
+	for _, date := range dates {
+		cur, err := dayStations(ctx, tx, date)
+		if err != nil {
+			return err
+		}
+		if err := replaceStationEvents(ctx, tx, date, stationEvents(date, prev, cur)); err != nil {
+			return err
+		}
+		prev = cur
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// StationEvents returns the lifecycle events matching filter, by date and
+// station.
+func (s *Storage) StationEvents(ctx context.Context, filter StationEventFilter) ([]StationEvent, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !filter.From.IsZero() {
+		fromStr = formatDate(filter.From)
+	}
+	if !filter.To.IsZero() {
+		toStr = formatDate(filter.To)
+	}
+
+	query := `
+		SELECT date, ideess, kind, old_value, new_value
+		FROM station_events
+		WHERE date BETWEEN ? AND ?`
+	args := []any{fromStr, toStr}
+	if filter.IDEESS != "" {
+		query += " AND ideess = ?"
+		args = append(args, filter.IDEESS)
+	}
+	if filter.Kind != "" {
+		query += " AND kind = ?"
+		args = append(args, string(filter.Kind))
+	}
+	query += " ORDER BY date, ideess, kind"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []StationEvent
+	for rows.Next() {
+		var e StationEvent
+		var kind string
+		if err := rows.Scan(&e.Date, &e.IDEESS, &kind, &e.Old, &e.New); err != nil {
+			return nil, fmt.Errorf("error scanning station event: %w", err)
+		}
+		e.Kind = StationEventKind(kind)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating station events: %w", err)
+	}
+	return events, nil
+}
+
+// StationPriceSummary summarizes the daily prices stored for a station and
+// fuel.
+type StationPriceSummary struct {
+	Fuel     Fuel
+	Last     float64
+	LastDate string
+	Min      float64
+	Max      float64
+	Avg      float64
+	Days     int
+}
+
+// StationProfile is the current state of a station and its price summary.
+type StationProfile struct {
+	// Station holds the details listed on LastSeen, without prices.
+	Station   api.GasStation
+	FirstSeen string
+	LastSeen  string
+	Prices    []StationPriceSummary
+}
+
+// Station returns the details of a station as last listed, the first and
+// last days it was listed and a summary of its daily prices per fuel.
+func (s *Storage) Station(ctx context.Context, ideess string) (*StationProfile, error) {
+	profile := &StationProfile{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MIN(date), ''), COALESCE(MAX(date), '') FROM station_days WHERE ideess = ?
+	`, ideess).Scan(&profile.FirstSeen, &profile.LastSeen)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station days: %w", err)
+	}
+	if profile.LastSeen == "" {
+		return nil, fmt.Errorf("%w for station %s", ErrNoData, ideess)
+	}
+
+	dest := make([]any, len(stationFields))
+	for i, f := range stationFields {
+		dest[i] = f.field(&profile.Station)
+	}
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT %s FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		WHERE d.date = ? AND d.ideess = ?
+	`, "s."+strings.Join(stationColumns(), ", s.")), profile.LastSeen, ideess).Scan(dest...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station details: %w", err)
+	}
+	profile.Station.IDEESS = ideess
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.fuel, COUNT(*), MIN(p.price), MAX(p.price), AVG(p.price), MAX(p.date),
+			(SELECT l.price FROM prices l WHERE l.ideess = p.ideess AND l.fuel = p.fuel ORDER BY l.date DESC LIMIT 1)
+		FROM prices p
+		WHERE p.ideess = ?
+		GROUP BY p.fuel
+		ORDER BY p.fuel
+	`, ideess)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station prices: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sum StationPriceSummary
+		var fuel string
+		if err := rows.Scan(&fuel, &sum.Days, &sum.Min, &sum.Max, &sum.Avg, &sum.LastDate, &sum.Last); err != nil {
+			return nil, fmt.Errorf("error scanning station prices: %w", err)
+		}
+		sum.Fuel = Fuel(fuel)
+		profile.Prices = append(profile.Prices, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating station prices: %w", err)
+	}
+	return profile, nil
+}
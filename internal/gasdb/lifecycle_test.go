@@ -0,0 +1,114 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestStationEvents(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	save := func(date time.Time, stations ...api.GasStation) {
+		t.Helper()
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, date, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2, day3 := day1.AddDate(0, 0, 1), day1.AddDate(0, 0, 2)
+	a := testStation("1", "REPSOL", "1,459", "1,599")
+	b := testStation("2", "CEPSA", "1,479", "")
+	rebranded := a
+	rebranded.Rotulo = "GALP"
+	rebranded.Horario = "L-V: 07:00-22:00"
+	rebranded.Latitud = "40,416801"
+	movedB := b
+	movedB.Latitud = "40,426800"
+	c := testStation("3", "BP", "1,489", "")
+
+	// Ingested out of order: the events of day 3 are derived again once
+	// day 2 is stored.
+	save(day1, a, b)
+	save(day3, rebranded, movedB)
+	save(day2, a, c)
+
+	events, err := s.StationEvents(ctx, StationEventFilter{From: day2})
+	if err != nil {
+		t.Fatalf("StationEvents() failed: %v", err)
+	}
+	got := make(map[string]StationEvent)
+	for _, e := range events {
+		got[e.Date+"/"+e.IDEESS+"/"+string(e.Kind)] = e
+	}
+	want := []string{
+		"2024-01-02/2/closed",
+		"2024-01-02/3/opened",
+		"2024-01-03/1/rebranded",
+		"2024-01-03/1/schedule_changed",
+		"2024-01-03/2/opened",
+		"2024-01-03/3/closed",
+	}
+	for _, key := range want {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing event %s in %v", key, events)
+		}
+	}
+	if len(events) != len(want) {
+		t.Errorf("expected %d events, got %+v", len(want), events)
+	}
+	if e := got["2024-01-03/1/rebranded"]; e.Old != "REPSOL" || e.New != "GALP" {
+		t.Errorf("unexpected rebranding: %+v", e)
+	}
+
+	// A station back after a gap in the snapshots keeps its history
+	save(day1.AddDate(0, 0, 5), movedB)
+	events, err = s.StationEvents(ctx, StationEventFilter{IDEESS: "2", Kind: StationMoved})
+	if err != nil || len(events) != 0 {
+		t.Errorf("unexpected moves: %+v, %v", events, err)
+	}
+	save(day1.AddDate(0, 0, 6), b)
+	events, err = s.StationEvents(ctx, StationEventFilter{IDEESS: "2", Kind: StationMoved})
+	if err != nil || len(events) != 1 || events[0].Date != "2024-01-07" {
+		t.Errorf("expected a move on 2024-01-07: %+v, %v", events, err)
+	}
+
+	// Rebuilding derives the same log
+	before, err := s.StationEvents(ctx, StationEventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildStationEvents(ctx); err != nil {
+		t.Fatalf("RebuildStationEvents() failed: %v", err)
+	}
+	after, err := s.StationEvents(ctx, StationEventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != len(after) {
+		t.Errorf("rebuilt %d events, expected %d", len(after), len(before))
+	}
+
+	profile, err := s.Station(ctx, "1")
+	if err != nil {
+		t.Fatalf("Station() failed: %v", err)
+	}
+	if profile.Station.Rotulo != "GALP" || profile.FirstSeen != "2024-01-01" || profile.LastSeen != "2024-01-03" {
+		t.Errorf("unexpected profile: %+v", profile)
+	}
+	if len(profile.Prices) != 2 || profile.Prices[0].Fuel != FuelGasoleoA || profile.Prices[0].Days != 3 || profile.Prices[0].Last != 1.459 {
+		t.Errorf("unexpected price summary: %+v", profile.Prices)
+	}
+	if _, err := s.Station(ctx, "404"); err == nil {
+		t.Error("expected an error for an unknown station")
+	}
+}
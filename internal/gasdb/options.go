@@ -0,0 +1,42 @@
+package gasdb
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a Storage.
+type Option func(*options)
+
+type options struct {
+	cache         Cache
+	cacheSize     int
+	snapshotSlots int
+	snapshotTTL   time.Duration
+	queryTTL      time.Duration
+	locationLog   LocationLogPolicy
+
+	transformers []Transformer
+	validators   []Validator
+
+	webhookClient   *http.Client
+	webhookAttempts int
+	webhookBackoff  time.Duration
+}
+
+func defaultOptions() *options {
+	return &options{
+		cacheSize:     defaultCacheEntries,
+		snapshotSlots: defaultSnapshotSlots,
+		snapshotTTL:   defaultSnapshotTTL,
+		queryTTL:      defaultQueryTTL,
+		locationLog:   LocationLogPolicy{}.withDefaults(),
+
+		transformers: []Transformer{TrimFields},
+		validators:   []Validator{RequireIDEESS},
+
+		webhookClient:   &http.Client{Timeout: defaultWebhookTimeout},
+		webhookAttempts: defaultWebhookAttempts,
+		webhookBackoff:  defaultWebhookBackoff,
+	}
+}
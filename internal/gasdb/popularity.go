@@ -0,0 +1,134 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// searchEventBucket is the time resolution of location_log_events.
+const searchEventBucket = time.Hour
+
+// PopularityQuery selects the most searched cells.
+type PopularityQuery struct {
+	// Window only counts searches made within this long before now. Zero
+	// counts every stored search.
+	Window time.Duration
+	// BBox restricts results to cells whose center lies inside it.
+	BBox *BBox
+	// Limit caps the number of results. Zero returns every cell.
+	Limit int
+}
+
+// PopularCell is a geohash cell with the number of searches made in it.
+// Search times are truncated to the hour.
+type PopularCell struct {
+	Geohash     string    `json:"geohash"`
+	Latitude    float64   `json:"lat"`
+	Longitude   float64   `json:"lng"`
+	Searches    int64     `json:"searches"`
+	FirstSearch time.Time `json:"first_search"`
+	LastSearch  time.Time `json:"last_search"`
+}
+
+// createSearchEventsTable creates location_log_events, which counts the
+// searches of each cell per hour. Cells logged before it existed are
+// imported as a single event at their last search.
+func (s *Storage) createSearchEventsTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS location_log_events (
+		geohash TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		latitude REAL NOT NULL,
+		longitude REAL NOT NULL,
+		search_count INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (geohash, bucket)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_location_log_events_bucket ON location_log_events (bucket);
+
+	INSERT OR IGNORE INTO location_log_events (geohash, bucket, latitude, longitude, search_count)
+	SELECT geohash, strftime('%Y-%m-%d %H:00:00', last_search), latitude, longitude, search_count
+	FROM location_logs
+	WHERE NOT EXISTS (SELECT 1 FROM location_log_events);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating location_log_events table: %w", err)
+	}
+	return nil
+}
+
+// recordSearchEvent counts a search of the cell in the current hour bucket.
+func (s *Storage) recordSearchEvent(ctx context.Context, hash string, lat, lng float64, at time.Time) error {
+	bucket := at.UTC().Truncate(searchEventBucket).Format(sqliteTimeLayout)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO location_log_events (geohash, bucket, latitude, longitude)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (geohash, bucket) DO UPDATE SET search_count = search_count + 1
+	`, hash, bucket, lat, lng)
+	if err != nil {
+		return fmt.Errorf("error recording search event: %w", err)
+	}
+	return nil
+}
+
+// PopularLocations returns the most searched cells, most searched first.
+// Cells below the k-anonymity threshold within the query window are left out.
+func (s *Storage) PopularLocations(ctx context.Context, q PopularityQuery) ([]PopularCell, error) {
+	var conditions []string
+	var args []any
+	if q.Window > 0 {
+		since := time.Now().Add(-q.Window).UTC().Truncate(searchEventBucket)
+		conditions = append(conditions, "bucket >= ?")
+		args = append(args, since.Format(sqliteTimeLayout))
+	}
+	if q.BBox != nil {
+		conditions = append(conditions, "latitude BETWEEN ? AND ?", "longitude BETWEEN ? AND ?")
+		args = append(args, q.BBox.MinLat, q.BBox.MaxLat, q.BBox.MinLng, q.BBox.MaxLng)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT geohash, latitude, longitude, SUM(search_count), MIN(bucket), MAX(bucket)
+		FROM location_log_events
+		%s
+		GROUP BY geohash
+		HAVING SUM(search_count) >= ?
+		ORDER BY SUM(search_count) DESC, geohash
+	`, where)
+	args = append(args, s.locationLog.MinCount)
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying popular locations: %w", err)
+	}
+	defer rows.Close()
+
+	var cells []PopularCell
+	for rows.Next() {
+		var cell PopularCell
+		var first, last string
+		if err := rows.Scan(&cell.Geohash, &cell.Latitude, &cell.Longitude, &cell.Searches, &first, &last); err != nil {
+			return nil, fmt.Errorf("error scanning popular location: %w", err)
+		}
+		cell.FirstSearch = parseSQLiteTime(first)
+		cell.LastSearch = parseSQLiteTime(last)
+		cells = append(cells, cell)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating popular locations: %w", err)
+	}
+
+	return cells, nil
+}
@@ -0,0 +1,57 @@
+package gasdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPopularLocations(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	search := func(lat, lng float64, times int) {
+		t.Helper()
+		for i := 0; i < times; i++ {
+			if err := s.LogSearchLocation(ctx, lat, lng, 5000); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	search(40.4168, -3.7038, 7) // Madrid
+	search(41.3874, 2.1686, 5)  // Barcelona
+	search(37.3891, -5.9845, 2) // Sevilla, below the threshold
+
+	cells, err := s.PopularLocations(ctx, PopularityQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 2 || cells[0].Searches != 7 || cells[1].Searches != 5 {
+		t.Fatalf("unexpected popular cells: %+v", cells)
+	}
+
+	if cells, err = s.PopularLocations(ctx, PopularityQuery{Limit: 1}); err != nil || len(cells) != 1 {
+		t.Fatalf("expected a single cell with Limit 1, got %+v, %v", cells, err)
+	}
+
+	cells, err = s.PopularLocations(ctx, PopularityQuery{BBox: &BBox{MinLat: 41, MinLng: 2, MaxLat: 42, MaxLng: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 1 || cells[0].Geohash != "sp3e3" {
+		t.Errorf("expected only Barcelona inside the bbox, got %+v", cells)
+	}
+
+	// Move the Barcelona searches out of a 7 day window
+	old := time.Now().AddDate(0, 0, -10).UTC().Format(sqliteTimeLayout)
+	if _, err := s.db.Exec("UPDATE location_log_events SET bucket = ? WHERE geohash = ?", old, cells[0].Geohash); err != nil {
+		t.Fatal(err)
+	}
+	cells, err = s.PopularLocations(ctx, PopularityQuery{Window: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 1 || cells[0].Searches != 7 {
+		t.Errorf("expected only Madrid within the window, got %+v", cells)
+	}
+}
@@ -0,0 +1,290 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultGeohashPrecision cells are about 4.9 x 4.9 km.
+	defaultGeohashPrecision = 5
+	maxGeohashPrecision     = 12
+	defaultLogRetention     = 90 * 24 * time.Hour
+	defaultMinCellCount     = 5
+	// logPurgeInterval throttles the automatic retention purge.
+	logPurgeInterval = time.Hour
+	sqliteTimeLayout = "2006-01-02 15:04:05"
+)
+
+const (
+	geohashAlphabet    = "0123456789bcdefghjkmnpqrstuvwxyz"
+	geohashBitsPerChar = 5
+)
+
+// LocationLogPolicy controls how searches are recorded in location_logs.
+type LocationLogPolicy struct {
+	// Enabled logs every search by default. Individual calls can override it
+	// with WithSearchLogging.
+	Enabled bool
+	// GeohashPrecision is the geohash length searches are coarsened to
+	// before they are stored. Shorter hashes are larger cells.
+	GeohashPrecision int
+	// Retention is how long a cell is kept after its last search.
+	Retention time.Duration
+	// MinCount is the k-anonymity threshold: cells searched fewer times are
+	// never included in reports.
+	MinCount int
+}
+
+func (p LocationLogPolicy) withDefaults() LocationLogPolicy {
+	if p.GeohashPrecision <= 0 || p.GeohashPrecision > maxGeohashPrecision {
+		p.GeohashPrecision = defaultGeohashPrecision
+	}
+	if p.Retention <= 0 {
+		p.Retention = defaultLogRetention
+	}
+	if p.MinCount <= 0 {
+		p.MinCount = defaultMinCellCount
+	}
+	return p
+}
+
+// WithLocationLogging sets the search logging policy. Zero fields take the
+// defaults: 5 character geohash cells, 90 days retention and k = 5.
+func WithLocationLogging(policy LocationLogPolicy) Option {
+	return func(o *options) { o.locationLog = policy.withDefaults() }
+}
+
+type searchLoggingKey struct{}
+
+// WithSearchLogging returns a context that enables or disables search
+// logging for the calls using it, overriding the Storage policy.
+func WithSearchLogging(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, searchLoggingKey{}, enabled)
+}
+
+// searchLoggingEnabled reports whether the search made with ctx should be logged.
+func (s *Storage) searchLoggingEnabled(ctx context.Context) bool {
+	if enabled, ok := ctx.Value(searchLoggingKey{}).(bool); ok {
+		return enabled
+	}
+	return s.locationLog.Enabled
+}
+
+// logSearch records a search if logging is enabled for ctx, and purges the
+// expired cells whether it is or not, so cells logged while it was enabled
+// still expire. Failures are logged and never fail the search.
+func (s *Storage) logSearch(ctx context.Context, lat, lng, distance float64) {
+	s.purgeLocationLogsIfDue(ctx)
+
+	if !s.searchLoggingEnabled(ctx) {
+		return
+	}
+	if err := s.LogSearchLocation(ctx, lat, lng, distance); err != nil {
+		s.log.Error("Failed to log search location", "error", err)
+	}
+}
+
+// purgeLocationLogsIfDue runs PurgeExpiredLocationLogs at most once per
+// logPurgeInterval.
+func (s *Storage) purgeLocationLogsIfDue(ctx context.Context) {
+	if !s.purge.due(time.Now()) {
+		return
+	}
+	if _, err := s.PurgeExpiredLocationLogs(ctx); err != nil {
+		s.log.Error("Failed to purge expired location logs", "error", err)
+	}
+}
+
+// purgeSchedule throttles automatic retention purges.
+type purgeSchedule struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (p *purgeSchedule) due(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.last) < logPurgeInterval {
+		return false
+	}
+	p.last = now
+	return true
+}
+
+// PurgeExpiredLocationLogs deletes the cells not searched within the
+// retention period and returns how many were deleted.
+func (s *Storage) PurgeExpiredLocationLogs(ctx context.Context) (int64, error) {
+	return s.EraseLocationLogs(ctx, time.Now().Add(-s.locationLog.Retention))
+}
+
+// EraseLocationLogs deletes the cells last searched before the given time,
+// along with their search events, or everything when before is zero. It
+// returns how many cells were deleted.
+func (s *Storage) EraseLocationLogs(ctx context.Context, before time.Time) (int64, error) {
+	query, eventsQuery, args := "DELETE FROM location_logs", "DELETE FROM location_log_events", []any{}
+	if !before.IsZero() {
+		query += " WHERE last_search < ?"
+		eventsQuery += " WHERE bucket < ?"
+		args = append(args, before.UTC().Format(sqliteTimeLayout))
+	}
+
+	if _, err := s.db.ExecContext(ctx, eventsQuery, args...); err != nil {
+		return 0, fmt.Errorf("error erasing search events: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error erasing location logs: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error counting erased location logs: %w", err)
+	}
+	return deleted, nil
+}
+
+// ExportLocationLogs returns every stored cell, including those below the
+// k-anonymity threshold, for access requests and audits.
+func (s *Storage) ExportLocationLogs(ctx context.Context) ([]LocationLog, error) {
+	return s.queryLocationLogs(ctx, `
+		SELECT id, geohash, latitude, longitude, distance, search_count, search_time, last_search
+		FROM location_logs
+		ORDER BY id
+	`)
+}
+
+// migrateLocationLogs adds the geohash column to location_logs tables
+// created before searches were coarsened, and coarsens the existing rows.
+func (s *Storage) migrateLocationLogs(ctx context.Context) error {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('location_logs') WHERE name = 'geohash'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error inspecting location_logs: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, "ALTER TABLE location_logs ADD COLUMN geohash TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error adding geohash column: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, latitude, longitude FROM location_logs")
+	if err != nil {
+		return fmt.Errorf("error querying location logs: %w", err)
+	}
+	type legacyLog struct {
+		id       int64
+		lat, lng float64
+	}
+	var logs []legacyLog
+	for rows.Next() {
+		var l legacyLog
+		if err := rows.Scan(&l.id, &l.lat, &l.lng); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning location log: %w", err)
+		}
+		logs = append(logs, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating location logs: %w", err)
+	}
+
+	for _, l := range logs {
+		hash := geohashEncode(l.lat, l.lng, s.locationLog.GeohashPrecision)
+		lat, lng := geohashCenter(hash)
+		_, err := s.db.ExecContext(ctx, "UPDATE location_logs SET geohash = ?, latitude = ?, longitude = ? WHERE id = ?",
+			hash, lat, lng, l.id)
+		if err != nil {
+			return fmt.Errorf("error coarsening location log %d: %w", l.id, err)
+		}
+	}
+	return nil
+}
+
+// geohashEncode returns the geohash of the given coordinates.
+func geohashEncode(lat, lng float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+
+	var sb strings.Builder
+	bit, ch, even := 0, 0, true
+	for sb.Len() < precision {
+		if even {
+			mid := (minLng + maxLng) / 2
+			if lng >= mid {
+				ch = ch<<1 | 1
+				minLng = mid
+			} else {
+				ch <<= 1
+				maxLng = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				minLat = mid
+			} else {
+				ch <<= 1
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bit++; bit == geohashBitsPerChar {
+			sb.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// geohashBounds returns the cell covered by a geohash.
+func geohashBounds(hash string) BBox {
+	box := BBox{MinLat: -90, MaxLat: 90, MinLng: -180, MaxLng: 180}
+	even := true
+	for _, c := range hash {
+		idx := strings.IndexRune(geohashAlphabet, c)
+		for mask := 1 << (geohashBitsPerChar - 1); mask > 0; mask >>= 1 {
+			if even {
+				mid := (box.MinLng + box.MaxLng) / 2
+				if idx&mask != 0 {
+					box.MinLng = mid
+				} else {
+					box.MaxLng = mid
+				}
+			} else {
+				mid := (box.MinLat + box.MaxLat) / 2
+				if idx&mask != 0 {
+					box.MinLat = mid
+				} else {
+					box.MaxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return box
+}
+
+// geohashCenter returns the center of a geohash cell.
+func geohashCenter(hash string) (lat, lng float64) {
+	box := geohashBounds(hash)
+	return (box.MinLat + box.MaxLat) / 2, (box.MinLng + box.MaxLng) / 2
+}
+
+// parseSQLiteTime parses a CURRENT_TIMESTAMP value, returning the zero time
+// if it is not one.
+func parseSQLiteTime(value string) time.Time {
+	for _, layout := range []string{sqliteTimeLayout, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
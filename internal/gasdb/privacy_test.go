@@ -0,0 +1,150 @@
+package gasdb
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGeohash(t *testing.T) {
+	if got := geohashEncode(42.6, -5.6, 5); got != "ezs42" {
+		t.Errorf("geohashEncode() = %s, want ezs42", got)
+	}
+
+	lat, lng := geohashCenter("ezs42")
+	if math.Abs(lat-42.605) > 0.01 || math.Abs(lng+5.603) > 0.01 {
+		t.Errorf("geohashCenter(ezs42) = %f, %f", lat, lng)
+	}
+}
+
+func TestSearchLoggingPolicy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	// Logging is opt-in
+	if _, err := s.NearbyPrices(ctx, 40.4168, -3.7038, 1000); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := s.ExportLocationLogs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("expected no logs without opt-in, got %d", len(logs))
+	}
+
+	logged := WithSearchLogging(ctx, true)
+	for i := 0; i < defaultMinCellCount-1; i++ {
+		if _, err := s.NearbyPrices(logged, 40.4168+float64(i)*0.001, -3.7038, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs, err = s.ExportLocationLogs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Geohash != "ezjmg" || logs[0].SearchCount != defaultMinCellCount-1 {
+		t.Fatalf("expected a single coarsened cell, got %+v", logs)
+	}
+	if logs[0].Latitude == 40.4168 {
+		t.Error("exact coordinates were stored")
+	}
+
+	reported, err := s.GetLocationLogs(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 0 {
+		t.Errorf("cell below the k-anonymity threshold was reported: %+v", reported)
+	}
+
+	if _, err := s.NearbyPrices(logged, 40.4168, -3.7038, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if reported, err = s.GetLocationLogs(ctx, 0); err != nil || len(reported) != 1 {
+		t.Errorf("expected the cell to be reported at the threshold, got %+v, %v", reported, err)
+	}
+
+	deleted, err := s.EraseLocationLogs(ctx, time.Time{})
+	if err != nil || deleted != 1 {
+		t.Errorf("EraseLocationLogs() = %d, %v", deleted, err)
+	}
+}
+
+func TestPurgeExpiredLocationLogs(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler),
+		WithLocationLogging(LocationLogPolicy{Enabled: true, Retention: 24 * time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, lat := range []float64{40.4, 41.4} {
+		if err := s.LogSearchLocation(ctx, lat, -3.7, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(sqliteTimeLayout)
+	if _, err := s.db.Exec("UPDATE location_logs SET last_search = ? WHERE id = 1", old); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.PurgeExpiredLocationLogs(ctx)
+	if err != nil || deleted != 1 {
+		t.Errorf("PurgeExpiredLocationLogs() = %d, %v, want 1 deleted", deleted, err)
+	}
+}
+
+func TestPurgeExpiredLocationLogsWithoutLogging(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewStorage(ctx, path, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cells logged while logging was enabled expire once it is disabled
+	expire := func() {
+		t.Helper()
+		for _, lat := range []float64{40.4, 41.4} {
+			if err := s.LogSearchLocation(ctx, lat, -3.7, 1000); err != nil {
+				t.Fatal(err)
+			}
+		}
+		old := time.Now().Add(-100 * 24 * time.Hour).UTC().Format(sqliteTimeLayout)
+		if _, err := s.db.Exec("UPDATE location_logs SET last_search = ? WHERE latitude > 41", old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM location_logs").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	expire()
+	s.Close()
+	if s, err = NewStorage(ctx, path, slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := count(); n != 1 {
+		t.Errorf("expected the expired cell to be purged when opening the storage, %d left", n)
+	}
+
+	expire()
+	s.purge = purgeSchedule{}
+	s.logSearch(ctx, 40.4, -3.7, 1000)
+	if n := count(); n != 1 {
+		t.Errorf("expected the expired cell to be purged by a search that is not logged, %d left", n)
+	}
+}
@@ -0,0 +1,238 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QualityIssueKind classifies the data quality issues of a station.
+type QualityIssueKind string
+
+const (
+	// QualityMissingCoordinates is a station without latitude or longitude.
+	QualityMissingCoordinates QualityIssueKind = "missing_coordinates"
+	// QualityUnparsableCoordinates is a latitude or longitude that is not a
+	// number.
+	QualityUnparsableCoordinates QualityIssueKind = "unparsable_coordinates"
+	// QualitySwappedCoordinates is a station inside Spain once its latitude
+	// and longitude are swapped.
+	QualitySwappedCoordinates QualityIssueKind = "swapped_coordinates"
+	// QualityOutsideSpain is a station outside every Spanish territory.
+	QualityOutsideSpain QualityIssueKind = "outside_spain"
+	// QualityEmptySchedule is a station without opening hours.
+	QualityEmptySchedule QualityIssueKind = "empty_schedule"
+	// QualityPostalCodeMismatch is a postal code that is malformed or does
+	// not belong to the province of the station.
+	QualityPostalCodeMismatch QualityIssueKind = "postal_code_mismatch"
+	// QualityNoPrices is a station listed without any price.
+	QualityNoPrices QualityIssueKind = "no_prices"
+)
+
+// QualityIssueKinds lists every kind in report order.
+var QualityIssueKinds = []QualityIssueKind{
+	QualityMissingCoordinates,
+	QualityUnparsableCoordinates,
+	QualitySwappedCoordinates,
+	QualityOutsideSpain,
+	QualityEmptySchedule,
+	QualityPostalCodeMismatch,
+	QualityNoPrices,
+}
+
+// boundingBox is a latitude and longitude range in degrees.
+type boundingBox struct {
+	minLat, maxLat, minLng, maxLng float64
+}
+
+func (b boundingBox) contains(lat, lng float64) bool {
+	return lat >= b.minLat && lat <= b.maxLat && lng >= b.minLng && lng <= b.maxLng
+}
+
+// spainBoxes bound the peninsula and the Balearic Islands, the Canary
+// Islands, Ceuta and Melilla, with some margin.
+var spainBoxes = []boundingBox{
+	{minLat: 35.9, maxLat: 43.9, minLng: -9.5, maxLng: 4.5},
+	{minLat: 27.5, maxLat: 29.5, minLng: -18.3, maxLng: -13.3},
+	{minLat: 35.8, maxLat: 35.95, minLng: -5.45, maxLng: -5.25},
+	{minLat: 35.25, maxLat: 35.33, minLng: -3.0, maxLng: -2.9},
+}
+
+func inSpain(lat, lng float64) bool {
+	for _, b := range spainBoxes {
+		if b.contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// QualityIssue is a data quality issue of a station on a day.
+type QualityIssue struct {
+	Kind      QualityIssueKind `json:"kind"`
+	IDEESS    string           `json:"ideess"`
+	Rotulo    string           `json:"rotulo"`
+	Provincia string           `json:"provincia"`
+	Detail    string           `json:"detail"`
+}
+
+// QualitySummary counts the stations of a day and their issues by kind.
+type QualitySummary struct {
+	Date     string                   `json:"date"`
+	Stations int                      `json:"stations"`
+	Counts   map[QualityIssueKind]int `json:"counts"`
+}
+
+// QualityReport lists the data quality issues of the stations of a day.
+type QualityReport struct {
+	QualitySummary
+	Issues []QualityIssue `json:"issues"`
+}
+
+// qualityStation is the station metadata checked by stationQuality.
+type qualityStation struct {
+	ideess, rotulo, provincia, idProvincia, cp, latitud, longitud, horario string
+	prices                                                                 int
+}
+
+// stationQuality returns the issues of a station.
+func stationQuality(st qualityStation) []QualityIssue {
+	var issues []QualityIssue
+	add := func(kind QualityIssueKind, detail string) {
+		issues = append(issues, QualityIssue{Kind: kind, IDEESS: st.ideess, Rotulo: st.rotulo, Provincia: st.provincia, Detail: detail})
+	}
+
+	coords := fmt.Sprintf("%q, %q", st.latitud, st.longitud)
+	if strings.TrimSpace(st.latitud) == "" || strings.TrimSpace(st.longitud) == "" {
+		add(QualityMissingCoordinates, coords)
+	} else if lat, latErr := ParseLatLong(st.latitud); latErr != nil {
+		add(QualityUnparsableCoordinates, coords)
+	} else if lng, lngErr := ParseLatLong(st.longitud); lngErr != nil {
+		add(QualityUnparsableCoordinates, coords)
+	} else if !inSpain(lat, lng) {
+		if inSpain(lng, lat) {
+			add(QualitySwappedCoordinates, coords)
+		} else {
+			add(QualityOutsideSpain, coords)
+		}
+	}
+
+	if strings.TrimSpace(st.horario) == "" {
+		add(QualityEmptySchedule, "")
+	}
+
+	province := st.idProvincia
+	if len(province) == 1 {
+		province = "0" + province
+	}
+	if len(st.cp) != 5 || strings.IndexFunc(st.cp, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+		add(QualityPostalCodeMismatch, fmt.Sprintf("malformed postal code %q", st.cp))
+	} else if st.cp[:2] != province {
+		add(QualityPostalCodeMismatch, fmt.Sprintf("postal code %s in province %s", st.cp, province))
+	}
+
+	if st.prices == 0 {
+		add(QualityNoPrices, "")
+	}
+	return issues
+}
+
+// qualityReports checks the stations of every day between from and to, both
+// YYYY-MM-DD, and calls fn with the report of each day in order.
+func (s *Storage) qualityReports(ctx context.Context, from, to string, fn func(*QualityReport)) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.date, d.ideess, s.rotulo, s.provincia, s.idprovincia, s.cp, s.latitud, s.longitud, s.horario,
+			(SELECT COUNT(*) FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess)
+		FROM station_days d
+		JOIN stations s ON s.id = d.station_id
+		WHERE d.date BETWEEN ? AND ?
+		ORDER BY d.date, d.ideess
+	`, from, to)
+	if err != nil {
+		return fmt.Errorf("error querying stations: %w", err)
+	}
+	defer rows.Close()
+
+	var report *QualityReport
+	for rows.Next() {
+		var date string
+		var st qualityStation
+		if err := rows.Scan(&date, &st.ideess, &st.rotulo, &st.provincia, &st.idProvincia, &st.cp,
+			&st.latitud, &st.longitud, &st.horario, &st.prices); err != nil {
+			return fmt.Errorf("error scanning station: %w", err)
+		}
+
+		if report == nil || report.Date != date {
+			if report != nil {
+				fn(report)
+			}
+			report = &QualityReport{
+				QualitySummary: QualitySummary{Date: date, Counts: make(map[QualityIssueKind]int)},
+				Issues:         []QualityIssue{},
+			}
+		}
+		report.Stations++
+		for _, issue := range stationQuality(st) {
+			report.Counts[issue.Kind]++
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating stations: %w", err)
+	}
+	if report != nil {
+		fn(report)
+	}
+	return nil
+}
+
+// Quality returns the data quality issues of the stations listed on date, or
+// on the last day stored when date is zero.
+func (s *Storage) Quality(ctx context.Context, date time.Time) (*QualityReport, error) {
+	var dateStr string
+	if date.IsZero() {
+		err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(date), '') FROM station_days").Scan(&dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("error querying last day: %w", err)
+		}
+		if dateStr == "" {
+			return nil, ErrNoData
+		}
+	} else {
+		dateStr = formatDate(date)
+	}
+
+	var report *QualityReport
+	err := s.qualityReports(ctx, dateStr, dateStr, func(r *QualityReport) { report = r })
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, fmt.Errorf("%w for date %s", ErrNoData, dateStr)
+	}
+	return report, nil
+}
+
+// QualityHistory returns the number of stations and issues of every day
+// between from and to with stored stations. A zero from or to leaves that
+// end of the range open.
+func (s *Storage) QualityHistory(ctx context.Context, from, to time.Time) ([]QualitySummary, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = formatDate(from)
+	}
+	if !to.IsZero() {
+		toStr = formatDate(to)
+	}
+
+	history := []QualitySummary{}
+	err := s.qualityReports(ctx, fromStr, toStr, func(r *QualityReport) {
+		history = append(history, r.QualitySummary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
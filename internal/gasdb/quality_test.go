@@ -0,0 +1,107 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestQuality(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	if _, err := s.Quality(ctx, time.Time{}); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData on an empty database, got %v", err)
+	}
+
+	ok := testStation("1", "REPSOL", "1,459", "1,599")
+	missing := testStation("2", "CEPSA", "1,459", "")
+	missing.Longitud = ""
+	unparsable := testStation("3", "BP", "1,459", "")
+	unparsable.Latitud = "N/A"
+	swapped := testStation("4", "SHELL", "1,459", "")
+	swapped.Latitud, swapped.Longitud = swapped.Longitud, swapped.Latitud
+	outside := testStation("5", "GALP", "1,459", "")
+	outside.Latitud, outside.Longitud = "48,856600", "2,352200"
+	canary := testStation("6", "DISA", "1,459", "")
+	canary.Latitud, canary.Longitud, canary.CP, canary.IDProvincia = "28,123500", "-15,436300", "35001", "35"
+	ceuta := testStation("7", "CEPSA", "1,459", "")
+	ceuta.Latitud, ceuta.Longitud, ceuta.CP, ceuta.IDProvincia = "35,889400", "-5,321300", "51001", "51"
+	noSchedule := testStation("8", "REPSOL", "1,459", "")
+	noSchedule.Horario = " "
+	wrongCP := testStation("9", "REPSOL", "1,459", "")
+	wrongCP.CP = "08001"
+	noPrices := testStation("10", "REPSOL", "", "")
+	alava := testStation("11", "REPSOL", "1,459", "")
+	alava.Latitud, alava.Longitud, alava.CP, alava.IDProvincia = "42,846700", "-2,672600", "01001", "1"
+
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, day := range []struct {
+		date     time.Time
+		stations []api.GasStation
+	}{
+		{day1, []api.GasStation{ok, missing}},
+		{day2, []api.GasStation{ok, missing, unparsable, swapped, outside, canary, ceuta, noSchedule, wrongCP, noPrices, alava}},
+	} {
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: day.stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, day.date, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+
+	report, err := s.Quality(ctx, time.Time{})
+	if err != nil {
+		t.Fatalf("Quality() failed: %v", err)
+	}
+	if report.Date != "2024-01-02" || report.Stations != 11 {
+		t.Errorf("unexpected report: %s, %d stations", report.Date, report.Stations)
+	}
+	got := make(map[string]QualityIssueKind)
+	for _, issue := range report.Issues {
+		got[issue.IDEESS] = issue.Kind
+	}
+	want := map[string]QualityIssueKind{
+		"2":  QualityMissingCoordinates,
+		"3":  QualityUnparsableCoordinates,
+		"4":  QualitySwappedCoordinates,
+		"5":  QualityOutsideSpain,
+		"8":  QualityEmptySchedule,
+		"9":  QualityPostalCodeMismatch,
+		"10": QualityNoPrices,
+	}
+	if len(got) != len(want) || len(report.Issues) != len(want) {
+		t.Errorf("expected issues %v, got %+v", want, report.Issues)
+	}
+	for ideess, kind := range want {
+		if got[ideess] != kind {
+			t.Errorf("station %s: expected %s, got %q", ideess, kind, got[ideess])
+		}
+	}
+
+	history, err := s.QualityHistory(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("QualityHistory() failed: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 days, got %+v", history)
+	}
+	if history[0].Date != "2024-01-01" || history[0].Stations != 2 || history[0].Counts[QualityMissingCoordinates] != 1 || len(history[0].Counts) != 1 {
+		t.Errorf("unexpected first day: %+v", history[0])
+	}
+	if history[1].Counts[QualityNoPrices] != 1 || history[1].Counts[QualitySwappedCoordinates] != 1 {
+		t.Errorf("unexpected second day: %+v", history[1])
+	}
+
+	report, err = s.Quality(ctx, day1)
+	if err != nil || report.Stations != 2 || len(report.Issues) != 1 {
+		t.Errorf("unexpected report for %s: %+v, %v", day1.Format(time.DateOnly), report, err)
+	}
+}
@@ -0,0 +1,344 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const defaultPruneBatchSize = 500
+
+// RetentionPolicy declares how many days of data each table keeps: dates
+// older than that many days before today are pruned. A zero value keeps
+// that table forever.
+type RetentionPolicy struct {
+	// Snapshots is the retention of the raw fuel_prices snapshots.
+	Snapshots int
+	// DailyPrices is the retention of the per-station daily rows in prices
+	// and station_days. Older days are downsampled into weekly_prices
+	// before they are deleted.
+	DailyPrices int
+	// WeeklyPrices is the retention of the weekly_prices averages.
+	WeeklyPrices int
+	// Intraday is the retention of the intraday snapshots and their prices.
+	Intraday int
+	// BatchSize is the number of rows deleted per transaction.
+	BatchSize int
+}
+
+// PruneReport describes what Prune deleted, or would delete in a dry run.
+type PruneReport struct {
+	DryRun bool
+	// Cutoff dates, empty for tables kept forever.
+	SnapshotsCutoff    string
+	DailyPricesCutoff  string
+	WeeklyPricesCutoff string
+	IntradayCutoff     string
+
+	Snapshots         int64
+	DailyDays         int64
+	DailyPrices       int64
+	StationDays       int64
+	WeeklyPrices      int64
+	IntradaySnapshots int64
+	IntradayPrices    int64
+}
+
+// createWeeklyPricesTable creates weekly_prices, which holds the average
+// price of each station and fuel per week (starting on Monday) for days
+// downsampled by Prune, and downsampled_days, which records the days already
+// folded into those averages.
+func (s *Storage) createWeeklyPricesTable(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS weekly_prices (
+		week TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		samples INTEGER NOT NULL,
+		PRIMARY KEY (week, ideess, fuel)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_weekly_prices_ideess_fuel ON weekly_prices (ideess, fuel, week);
+
+	CREATE TABLE IF NOT EXISTS downsampled_days (
+		date TEXT PRIMARY KEY
+	) WITHOUT ROWID;
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating weekly_prices table: %w", err)
+	}
+	return nil
+}
+
+// retentionCutoff returns the first date kept by a retention of days, or
+// an empty string when days is zero.
+func retentionCutoff(now time.Time, days int) string {
+	if days <= 0 {
+		return ""
+	}
+	return now.AddDate(0, 0, -days).Format("2006-01-02")
+}
+
+// Prune applies policy. With dryRun set nothing is modified and the report
+// counts the rows that would be deleted.
+func (s *Storage) Prune(ctx context.Context, policy RetentionPolicy, dryRun bool) (*PruneReport, error) {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = defaultPruneBatchSize
+	}
+
+	now := Today()
+	report := &PruneReport{
+		DryRun:             dryRun,
+		SnapshotsCutoff:    retentionCutoff(now, policy.Snapshots),
+		DailyPricesCutoff:  retentionCutoff(now, policy.DailyPrices),
+		WeeklyPricesCutoff: retentionCutoff(now, policy.WeeklyPrices),
+		IntradayCutoff:     retentionCutoff(now, policy.Intraday),
+	}
+
+	if report.SnapshotsCutoff != "" {
+		if err := s.pruneSnapshots(ctx, report, policy.BatchSize); err != nil {
+			return report, err
+		}
+	}
+	if report.DailyPricesCutoff != "" {
+		if err := s.downsampleDailyPrices(ctx, report); err != nil {
+			return report, err
+		}
+	}
+	if report.WeeklyPricesCutoff != "" {
+		if err := s.pruneWeeklyPrices(ctx, report, policy.BatchSize); err != nil {
+			return report, err
+		}
+	}
+	if report.IntradayCutoff != "" {
+		if err := s.pruneIntraday(ctx, report, policy.BatchSize); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// deleteInBatches runs a DELETE limited to batchSize rows per transaction
+// until it deletes nothing, returning the total deleted.
+func (s *Storage) deleteInBatches(ctx context.Context, query string, batchSize int, args ...any) (int64, error) {
+	var total int64
+	for {
+		result, err := s.db.ExecContext(ctx, query, append(args, batchSize)...)
+		if err != nil {
+			return total, err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (s *Storage) pruneSnapshots(ctx context.Context, report *PruneReport, batchSize int) error {
+	if report.DryRun {
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fuel_prices WHERE date < ?", report.SnapshotsCutoff).
+			Scan(&report.Snapshots)
+		if err != nil {
+			return fmt.Errorf("error counting old snapshots: %w", err)
+		}
+		return nil
+	}
+
+	deleted, err := s.deleteInBatches(ctx,
+		"DELETE FROM fuel_prices WHERE id IN (SELECT id FROM fuel_prices WHERE date < ? ORDER BY date LIMIT ?)",
+		batchSize, report.SnapshotsCutoff)
+	report.Snapshots = deleted
+	if err != nil {
+		return fmt.Errorf("error deleting old snapshots: %w", err)
+	}
+
+	// Deleted snapshots may still be cached; deletions are rare, so start over
+	if deleted > 0 {
+		s.snapshots.Purge()
+		s.cache.Purge()
+	}
+	s.log.Info("Pruned snapshots", "cutoff_date", report.SnapshotsCutoff, "deleted_count", deleted)
+	return nil
+}
+
+// downsampleDailyPrices folds every day older than the cutoff into
+// weekly_prices and deletes its daily rows, one day per transaction.
+func (s *Storage) downsampleDailyPrices(ctx context.Context, report *PruneReport) error {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT DISTINCT date FROM station_days WHERE date < ? ORDER BY date", report.DailyPricesCutoff)
+	if err != nil {
+		return fmt.Errorf("error querying old days: %w", err)
+	}
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning date: %w", err)
+		}
+		dates = append(dates, date)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating old days: %w", err)
+	}
+
+	if report.DryRun {
+		report.DailyDays = int64(len(dates))
+		err := s.db.QueryRowContext(ctx,
+			"SELECT (SELECT COUNT(*) FROM prices WHERE date < ?), (SELECT COUNT(*) FROM station_days WHERE date < ?)",
+			report.DailyPricesCutoff, report.DailyPricesCutoff).Scan(&report.DailyPrices, &report.StationDays)
+		if err != nil {
+			return fmt.Errorf("error counting old daily prices: %w", err)
+		}
+		return nil
+	}
+
+	for _, date := range dates {
+		prices, stationDays, err := s.downsampleDay(ctx, date)
+		if err != nil {
+			return fmt.Errorf("error downsampling %s: %w", date, err)
+		}
+		report.DailyDays++
+		report.DailyPrices += prices
+		report.StationDays += stationDays
+		s.log.Debug("Downsampled daily prices", "date", date, "prices", prices)
+	}
+
+	s.log.Info("Downsampled daily prices", "cutoff_date", report.DailyPricesCutoff, "days", report.DailyDays)
+	return nil
+}
+
+// downsampleDay merges the prices of date into the running weekly averages
+// and deletes its daily rows. A day already folded in, whose daily rows came
+// back when its snapshot was saved again, only has them deleted so it is not
+// counted twice.
+func (s *Storage) downsampleDay(ctx context.Context, date string) (prices, stationDays int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO downsampled_days (date) VALUES (?)", date)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error recording downsampled day: %w", err)
+	}
+	folded, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// date(d, 'weekday 0', '-6 days') is the Monday starting the week of d
+	if folded > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO weekly_prices (week, ideess, fuel, price, samples)
+			SELECT date(date, 'weekday 0', '-6 days'), ideess, fuel, price, 1
+			FROM prices
+			WHERE date = ?
+			ON CONFLICT (week, ideess, fuel) DO UPDATE SET
+				price = (price * samples + excluded.price) / (samples + 1),
+				samples = samples + 1
+		`, date)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error writing weekly prices: %w", err)
+		}
+	}
+
+	result, err = tx.ExecContext(ctx, "DELETE FROM prices WHERE date = ?", date)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error deleting prices: %w", err)
+	}
+	if prices, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	result, err = tx.ExecContext(ctx, "DELETE FROM station_days WHERE date = ?", date)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error deleting station_days: %w", err)
+	}
+	if stationDays, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return prices, stationDays, nil
+}
+
+func (s *Storage) pruneWeeklyPrices(ctx context.Context, report *PruneReport, batchSize int) error {
+	if report.DryRun {
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM weekly_prices WHERE week < ?", report.WeeklyPricesCutoff).
+			Scan(&report.WeeklyPrices)
+		if err != nil {
+			return fmt.Errorf("error counting old weekly prices: %w", err)
+		}
+		return nil
+	}
+
+	deleted, err := s.deleteInBatches(ctx, `
+		DELETE FROM weekly_prices WHERE (week, ideess, fuel) IN (
+			SELECT week, ideess, fuel FROM weekly_prices WHERE week < ? ORDER BY week LIMIT ?
+		)`, batchSize, report.WeeklyPricesCutoff)
+	report.WeeklyPrices = deleted
+	if err != nil {
+		return fmt.Errorf("error deleting old weekly prices: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM downsampled_days WHERE date(date, 'weekday 0', '-6 days') < ?",
+		report.WeeklyPricesCutoff)
+	if err != nil {
+		return fmt.Errorf("error deleting old downsampled days: %w", err)
+	}
+	return nil
+}
+
+// pruneIntraday deletes the intraday snapshots older than the cutoff and
+// their prices.
+func (s *Storage) pruneIntraday(ctx context.Context, report *PruneReport, batchSize int) error {
+	if report.DryRun {
+		err := s.db.QueryRowContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM intraday_snapshots WHERE date < ?),
+				(SELECT COUNT(*) FROM intraday_prices WHERE snapshot_id IN (SELECT id FROM intraday_snapshots WHERE date < ?))
+		`, report.IntradayCutoff, report.IntradayCutoff).Scan(&report.IntradaySnapshots, &report.IntradayPrices)
+		if err != nil {
+			return fmt.Errorf("error counting old intraday snapshots: %w", err)
+		}
+		return nil
+	}
+
+	deleted, err := s.deleteInBatches(ctx, `
+		DELETE FROM intraday_prices WHERE (snapshot_id, ideess, fuel) IN (
+			SELECT snapshot_id, ideess, fuel FROM intraday_prices
+			WHERE snapshot_id IN (SELECT id FROM intraday_snapshots WHERE date < ?)
+			LIMIT ?
+		)`, batchSize, report.IntradayCutoff)
+	report.IntradayPrices = deleted
+	if err != nil {
+		return fmt.Errorf("error deleting old intraday prices: %w", err)
+	}
+
+	deleted, err = s.deleteInBatches(ctx,
+		"DELETE FROM intraday_snapshots WHERE id IN (SELECT id FROM intraday_snapshots WHERE date < ? ORDER BY id LIMIT ?)",
+		batchSize, report.IntradayCutoff)
+	report.IntradaySnapshots = deleted
+	if err != nil {
+		return fmt.Errorf("error deleting old intraday snapshots: %w", err)
+	}
+	s.log.Info("Pruned intraday snapshots", "cutoff_date", report.IntradayCutoff, "deleted_count", deleted)
+	return nil
+}
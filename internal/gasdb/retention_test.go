@@ -0,0 +1,113 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	// Ten days of prices for one station, ending today
+	today := time.Now()
+	for i := 0; i < 10; i++ {
+		price := []string{"1,400", "1,500"}[i%2]
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", price, "")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, today.AddDate(0, 0, -i), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Retentions keep today plus the given number of past days
+	policy := RetentionPolicy{Snapshots: 3, DailyPrices: 5, BatchSize: 2}
+	report, err := s.Prune(ctx, policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Snapshots != 6 || report.DailyDays != 4 || report.DailyPrices != 4 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	var snapshots int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM fuel_prices").Scan(&snapshots); err != nil || snapshots != 10 {
+		t.Fatalf("dry run modified fuel_prices: %d rows, %v", snapshots, err)
+	}
+
+	report, err = s.Prune(ctx, policy, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Snapshots != 6 || report.DailyDays != 4 || report.StationDays != 4 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	var days, samples int
+	var sum float64
+	err = s.db.QueryRow("SELECT COUNT(*) FROM station_days").Scan(&days)
+	if err != nil || days != 6 {
+		t.Errorf("expected 6 daily rows left, got %d, %v", days, err)
+	}
+	err = s.db.QueryRow("SELECT SUM(samples), SUM(price * samples) FROM weekly_prices WHERE ideess = '1'").Scan(&samples, &sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Days 6 to 9 alternate 1.400, 1.500, 1.400, 1.500
+	if samples != 4 || math.Abs(sum-5.8) > 1e-9 {
+		t.Errorf("weekly averages do not add up: %d samples, sum %f", samples, sum)
+	}
+}
+
+func TestPruneRefetchedDay(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	day := time.Now().AddDate(0, 0, -10)
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", "1,400", "")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, day, data); err != nil {
+		t.Fatal(err)
+	}
+
+	weekly := func() (samples int, price float64) {
+		t.Helper()
+		err := s.db.QueryRow("SELECT samples, price FROM weekly_prices WHERE ideess = '1'").Scan(&samples, &price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return samples, price
+	}
+
+	policy := RetentionPolicy{DailyPrices: 5}
+	if _, err := s.Prune(ctx, policy, false); err != nil {
+		t.Fatal(err)
+	}
+	if samples, price := weekly(); samples != 1 || price != 1.4 {
+		t.Fatalf("unexpected weekly average: %d samples of %f", samples, price)
+	}
+
+	// Fetching the day again brings its daily rows back, pruning them again
+	// must not fold the day into the week twice
+	if err := s.SavePrices(ctx, day, data); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Prune(ctx, policy, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DailyDays != 1 || report.StationDays != 1 {
+		t.Errorf("daily rows of the refetched day not deleted: %+v", report)
+	}
+	if samples, price := weekly(); samples != 1 || price != 1.4 {
+		t.Errorf("refetched day folded twice: %d samples of %f", samples, price)
+	}
+}
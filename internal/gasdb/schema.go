@@ -0,0 +1,657 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// Fuel identifies a fuel type in the normalized prices table. Values match the
+// precio_* column suffixes of the historic_prices view.
+type Fuel string
+
+const (
+	FuelBiodiesel         Fuel = "biodiesel"
+	FuelBioetanol         Fuel = "bioetanol"
+	FuelGasNaturalComp    Fuel = "gas_natural_comp"
+	FuelGasNaturalLicuado Fuel = "gas_natural_licuado"
+	FuelGasesLicuados     Fuel = "gases_licuados"
+	FuelGasoleoA          Fuel = "gasoleo_a"
+	FuelGasoleoB          Fuel = "gasoleo_b"
+	FuelGasoleoPremium    Fuel = "gasoleo_premium"
+	FuelGasolina95E10     Fuel = "gasolina_95_e10"
+	FuelGasolina95E5      Fuel = "gasolina_95_e5"
+	FuelGasolina95E5Prem  Fuel = "gasolina_95_e5_prem"
+	FuelGasolina98E10     Fuel = "gasolina_98_e10"
+	FuelGasolina98E5      Fuel = "gasolina_98_e5"
+	FuelHidrogeno         Fuel = "hidrogeno"
+)
+
+// fuelField maps a fuel to its GasStation field.
+type fuelField struct {
+	fuel  Fuel
+	field func(*api.GasStation) *string
+}
+
+func (f fuelField) get(st *api.GasStation) string { return *f.field(st) }
+
+// fuelFields lists every fuel stored in the prices table, in historic_prices column order.
+var fuelFields = []fuelField{
+	{FuelBiodiesel, func(st *api.GasStation) *string { return &st.PrecioBiodiesel }},
+	{FuelBioetanol, func(st *api.GasStation) *string { return &st.PrecioBioetanol }},
+	{FuelGasNaturalComp, func(st *api.GasStation) *string { return &st.PrecioGasNaturalComp }},
+	{FuelGasNaturalLicuado, func(st *api.GasStation) *string { return &st.PrecioGasNaturalLicuado }},
+	{FuelGasesLicuados, func(st *api.GasStation) *string { return &st.PrecioGasesLicuados }},
+	{FuelGasoleoA, func(st *api.GasStation) *string { return &st.PrecioGasoleoA }},
+	{FuelGasoleoB, func(st *api.GasStation) *string { return &st.PrecioGasoleoB }},
+	{FuelGasoleoPremium, func(st *api.GasStation) *string { return &st.PrecioGasoleoPremium }},
+	{FuelGasolina95E10, func(st *api.GasStation) *string { return &st.PrecioGasolina95E10 }},
+	{FuelGasolina95E5, func(st *api.GasStation) *string { return &st.PrecioGasolina95E5 }},
+	{FuelGasolina95E5Prem, func(st *api.GasStation) *string { return &st.PrecioGasolina95E5Prem }},
+	{FuelGasolina98E10, func(st *api.GasStation) *string { return &st.PrecioGasolina98E10 }},
+	{FuelGasolina98E5, func(st *api.GasStation) *string { return &st.PrecioGasolina98E5 }},
+	{FuelHidrogeno, func(st *api.GasStation) *string { return &st.PrecioHidrogeno }},
+}
+
+// Fuels returns every fuel stored in the prices table.
+func Fuels() []Fuel {
+	fuels := make([]Fuel, len(fuelFields))
+	for i, f := range fuelFields {
+		fuels[i] = f.fuel
+	}
+	return fuels
+}
+
+// fuelAliases maps common fuel names to their canonical Fuel.
+var fuelAliases = map[string]Fuel{
+	"diesel":         FuelGasoleoA,
+	"gasoleo":        FuelGasoleoA,
+	"gasoleoa":       FuelGasoleoA,
+	"diesel-premium": FuelGasoleoPremium,
+	"gasoleopremium": FuelGasoleoPremium,
+	"gasoleob":       FuelGasoleoB,
+	"gasolina95":     FuelGasolina95E5,
+	"gasolina95e5":   FuelGasolina95E5,
+	"95":             FuelGasolina95E5,
+	"gasolina95e10":  FuelGasolina95E10,
+	"gasolina98":     FuelGasolina98E5,
+	"gasolina98e5":   FuelGasolina98E5,
+	"98":             FuelGasolina98E5,
+	"gasolina98e10":  FuelGasolina98E10,
+	"glp":            FuelGasesLicuados,
+	"lpg":            FuelGasesLicuados,
+	"gnc":            FuelGasNaturalComp,
+	"cng":            FuelGasNaturalComp,
+	"gnl":            FuelGasNaturalLicuado,
+	"lng":            FuelGasNaturalLicuado,
+	"hydrogen":       FuelHidrogeno,
+}
+
+// ParseFuel returns the Fuel named by name, which can be a canonical fuel
+// name such as "gasoleo_a" or a common alias such as "diesel".
+func ParseFuel(name string) (Fuel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, f := range fuelFields {
+		if string(f.fuel) == name {
+			return f.fuel, nil
+		}
+	}
+	if fuel, ok := fuelAliases[name]; ok {
+		return fuel, nil
+	}
+	return "", fmt.Errorf("unknown fuel %q", name)
+}
+
+// stationField maps a stations column to its GasStation field.
+type stationField struct {
+	column string
+	field  func(*api.GasStation) *string
+}
+
+func (f stationField) get(st *api.GasStation) string { return *f.field(st) }
+
+// stationFields lists the station metadata columns. The first
+// stationFieldsBeforePrices precede the price columns in historic_prices,
+// the rest follow them.
+var stationFields = []stationField{
+	{"cp", func(st *api.GasStation) *string { return &st.CP }},
+	{"direccion", func(st *api.GasStation) *string { return &st.Direccion }},
+	{"horario", func(st *api.GasStation) *string { return &st.Horario }},
+	{"latitud", func(st *api.GasStation) *string { return &st.Latitud }},
+	{"localidad", func(st *api.GasStation) *string { return &st.Localidad }},
+	{"longitud", func(st *api.GasStation) *string { return &st.Longitud }},
+	{"margen", func(st *api.GasStation) *string { return &st.Margen }},
+	{"municipio", func(st *api.GasStation) *string { return &st.Municipio }},
+	{"provincia", func(st *api.GasStation) *string { return &st.Provincia }},
+	{"rotulo", func(st *api.GasStation) *string { return &st.Rotulo }},
+	{"tipo_venta", func(st *api.GasStation) *string { return &st.TipoVenta }},
+	{"porcentaje_bioetanol", func(st *api.GasStation) *string { return &st.PorcentajeBioEtanol }},
+	{"porcentaje_ester_metilico", func(st *api.GasStation) *string { return &st.PorcentajeEsterMetilico }},
+	{"idmunicipio", func(st *api.GasStation) *string { return &st.IDMunicipio }},
+	{"idprovincia", func(st *api.GasStation) *string { return &st.IDProvincia }},
+	{"idccaa", func(st *api.GasStation) *string { return &st.IDCCAA }},
+}
+
+const stationFieldsBeforePrices = 11
+
+func stationColumns() []string {
+	cols := make([]string, len(stationFields))
+	for i, f := range stationFields {
+		cols[i] = f.column
+	}
+	return cols
+}
+
+// createNormalizedTables creates the stations, station_days and prices tables.
+//
+// stations holds one row per metadata version of a station, with valid_from
+// and valid_to recording the first and last day that version was in effect. A
+// station going back to earlier metadata gets a new version, so the versions
+// of a station never overlap. station_days links each (date, ideess) to the
+// version in effect that day, and prices holds one narrow row per non-empty
+// fuel price.
+func (s *Storage) createNormalizedTables(ctx context.Context) error {
+	if err := s.migrateStationVersions(ctx); err != nil {
+		return err
+	}
+
+	createTablesSQL := stationsTableSQL("stations") + `
+	CREATE INDEX IF NOT EXISTS idx_stations_validity ON stations(valid_from, valid_to);
+	CREATE INDEX IF NOT EXISTS idx_stations_ideess ON stations(ideess, valid_from);
+
+	CREATE TABLE IF NOT EXISTS station_days (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		station_id INTEGER NOT NULL REFERENCES stations(id),
+		UNIQUE(date, ideess)
+	);
+	CREATE INDEX IF NOT EXISTS idx_station_days_ideess ON station_days(ideess);
+
+	CREATE TABLE IF NOT EXISTS prices (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		PRIMARY KEY (date, ideess, fuel)
+	) WITHOUT ROWID;
+	CREATE INDEX IF NOT EXISTS idx_prices_ideess_fuel ON prices(ideess, fuel, date);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTablesSQL); err != nil {
+		return fmt.Errorf("error creating normalized tables: %w", err)
+	}
+
+	return nil
+}
+
+// stationsTableSQL returns the statement creating the stations table as name.
+func stationsTableSQL(name string) string {
+	var cols strings.Builder
+	for _, f := range stationFields {
+		fmt.Fprintf(&cols, "\t\t%s TEXT NOT NULL DEFAULT '',\n", f.column)
+	}
+
+	return fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ideess TEXT NOT NULL,
+		valid_from TEXT NOT NULL,
+		valid_to TEXT NOT NULL,
+%s		CHECK (valid_from <= valid_to)
+	);
+	`, name, cols.String())
+}
+
+// migrateStationVersions rebuilds a stations table created with one row per
+// distinct metadata, which cannot hold a station going back to earlier
+// metadata, without that unique constraint. Ids are preserved. The indexes,
+// triggers and historic_prices view dropped with the old table are created
+// again by the callers of createNormalizedTables.
+func (s *Storage) migrateStationVersions(ctx context.Context) error {
+	var createSQL string
+	err := s.db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'stations'").Scan(&createSQL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("error inspecting stations: %w", err)
+	}
+	if !strings.Contains(createSQL, "UNIQUE(ideess") {
+		return nil
+	}
+
+	s.log.Info("Migrating stations to non-overlapping versions")
+
+	// Dropping stations must not cascade to station_days, and foreign keys
+	// can only be toggled outside a transaction, so use a connection of our own.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("error reading foreign keys: %w", err)
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return fmt.Errorf("error disabling foreign keys: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+				s.log.Error("error enabling foreign keys", "error", err)
+			}
+		}()
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	cols := "id, ideess, valid_from, valid_to, " + strings.Join(stationColumns(), ", ")
+	statements := []string{
+		"DROP VIEW IF EXISTS historic_prices",
+		stationsTableSQL("stations_versions"),
+		fmt.Sprintf("INSERT INTO stations_versions (%[1]s) SELECT %[1]s FROM stations", cols),
+		"DROP TABLE stations",
+		"ALTER TABLE stations_versions RENAME TO stations",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error migrating stations: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	s.log.Info("stations migration completed")
+	return nil
+}
+
+// createHistoricPricesView creates the historic_prices compatibility view, which
+// reproduces the columns of the former historic_prices table from the
+// normalized tables. Missing prices are returned as empty strings and prices
+// are formatted with a decimal comma, as the ministry publishes them.
+func (s *Storage) createHistoricPricesView(ctx context.Context) error {
+	var cols []string
+	for _, f := range stationFields[:stationFieldsBeforePrices] {
+		cols = append(cols, "s."+f.column)
+	}
+	for _, f := range fuelFields {
+		cols = append(cols, fmt.Sprintf(
+			"COALESCE((SELECT REPLACE(printf('%%.3f', p.price), '.', ',') FROM prices p"+
+				" WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = '%s'), '') AS precio_%s",
+			f.fuel, f.fuel))
+	}
+	for _, f := range stationFields[stationFieldsBeforePrices:] {
+		cols = append(cols, "s."+f.column)
+	}
+
+	createViewSQL := fmt.Sprintf(`
+	DROP VIEW IF EXISTS historic_prices;
+	CREATE VIEW historic_prices AS
+	SELECT
+		d.id, d.date, d.ideess,
+		%s
+	FROM station_days d
+	JOIN stations s ON s.id = d.station_id;
+	`, strings.Join(cols, ",\n\t\t"))
+
+	if _, err := s.db.ExecContext(ctx, createViewSQL); err != nil {
+		return fmt.Errorf("error creating historic_prices view: %w", err)
+	}
+
+	return nil
+}
+
+// historicPricesIsTable reports whether historic_prices is still the legacy
+// wide table rather than the compatibility view.
+func (s *Storage) historicPricesIsTable(ctx context.Context) (bool, error) {
+	var kind string
+	err := s.db.QueryRowContext(ctx, "SELECT type FROM sqlite_master WHERE name = 'historic_prices'").Scan(&kind)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("error inspecting historic_prices: %w", err)
+	}
+	return kind == "table", nil
+}
+
+// migrateLegacyHistoricPrices moves the rows of a legacy historic_prices table
+// into the normalized tables, then drops the legacy table so the compatibility
+// view can take its place. Row ids are preserved in station_days.
+func (s *Storage) migrateLegacyHistoricPrices(ctx context.Context) error {
+	s.log.Info("Migrating historic_prices to the normalized layout")
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	cols := stationColumns()
+	coalesced := make([]string, len(cols))
+	joins := make([]string, len(cols))
+	for i, c := range cols {
+		coalesced[i] = fmt.Sprintf("COALESCE(h.%s, '') AS %s", c, c)
+		joins[i] = fmt.Sprintf("s.%s = COALESCE(h.%s, '')", c, c)
+	}
+	colList := strings.Join(cols, ", ")
+
+	statements := []string{
+		"DROP TRIGGER IF EXISTS insert_historic_prices",
+		"ALTER TABLE historic_prices RENAME TO historic_prices_legacy",
+		// Each run of consecutive days with the same metadata is a version
+		fmt.Sprintf(`
+		WITH days AS (
+			SELECT h.ideess, h.date, %[2]s
+			FROM historic_prices_legacy h
+		), runs AS (
+			SELECT *,
+				ROW_NUMBER() OVER (PARTITION BY ideess ORDER BY date) -
+				ROW_NUMBER() OVER (PARTITION BY ideess, %[1]s ORDER BY date) AS run
+			FROM days
+		)
+		INSERT INTO stations (ideess, valid_from, valid_to, %[1]s)
+		SELECT ideess, MIN(date), MAX(date), %[1]s
+		FROM runs
+		GROUP BY ideess, %[1]s, run
+		`, colList, strings.Join(coalesced, ", ")),
+		fmt.Sprintf(`
+		INSERT INTO station_days (id, date, ideess, station_id)
+		SELECT h.id, h.date, h.ideess, s.id
+		FROM historic_prices_legacy h
+		JOIN stations s ON s.ideess = h.ideess
+			AND h.date BETWEEN s.valid_from AND s.valid_to
+			AND %s
+		`, strings.Join(joins, " AND ")),
+	}
+	for _, f := range fuelFields {
+		statements = append(statements, fmt.Sprintf(`
+		INSERT OR REPLACE INTO prices (date, ideess, fuel, price)
+		SELECT date, ideess, '%s', CAST(REPLACE(precio_%s, ',', '.') AS REAL)
+		FROM historic_prices_legacy
+		WHERE precio_%s IS NOT NULL AND precio_%s <> ''
+		`, f.fuel, f.fuel, f.fuel, f.fuel))
+	}
+	statements = append(statements, "DROP TABLE historic_prices_legacy")
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error migrating historic_prices: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	s.log.Info("historic_prices migration completed")
+	return nil
+}
+
+// snapshotWriter writes the stations of a snapshot into the normalized tables
+// using prepared statements bound to a transaction.
+type snapshotWriter struct {
+	deletePrices  *sql.Stmt
+	deleteDays    *sql.Stmt
+	prevVersion   *sql.Stmt
+	nextVersion   *sql.Stmt
+	extendVersion *sql.Stmt
+	insertVersion *sql.Stmt
+	splitVersion  *sql.Stmt
+	relinkDays    *sql.Stmt
+	endVersion    *sql.Stmt
+	startVersion  *sql.Stmt
+	deleteVersion *sql.Stmt
+	insertDay     *sql.Stmt
+	insertPrice   *sql.Stmt
+}
+
+// stationVersion is a stations row found around the day being written.
+type stationVersion struct {
+	id        int64
+	validFrom string
+	validTo   string
+	// same reports whether the version has the metadata being written.
+	same bool
+}
+
+func newSnapshotWriter(ctx context.Context, tx *sql.Tx) (*snapshotWriter, error) {
+	cols := stationColumns()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	same := make([]string, len(cols))
+	for i, c := range cols {
+		same[i] = c + " = ?"
+	}
+
+	queries := []string{
+		"DELETE FROM prices WHERE date = ?",
+		"DELETE FROM station_days WHERE date = ?",
+		// The version in effect on the day, or the last one before it
+		fmt.Sprintf(`
+		SELECT id, valid_from, valid_to, %s
+		FROM stations
+		WHERE ideess = ? AND valid_from <= ?
+		ORDER BY valid_from DESC, id DESC
+		LIMIT 1
+		`, strings.Join(same, " AND ")),
+		// The first version after the day
+		fmt.Sprintf(`
+		SELECT id, valid_from, valid_to, %s
+		FROM stations
+		WHERE ideess = ? AND valid_from > ?
+		ORDER BY valid_from, id
+		LIMIT 1
+		`, strings.Join(same, " AND ")),
+		"UPDATE stations SET valid_from = MIN(valid_from, ?1), valid_to = MAX(valid_to, ?1) WHERE id = ?2",
+		fmt.Sprintf(`
+		INSERT INTO stations (ideess, valid_from, valid_to, %s)
+		VALUES (?, ?, ?, %s)
+		RETURNING id
+		`, strings.Join(cols, ", "), placeholders),
+		fmt.Sprintf(`
+		INSERT INTO stations (ideess, valid_from, valid_to, %[1]s)
+		SELECT ideess, date(?1, '+1 day'), valid_to, %[1]s
+		FROM stations
+		WHERE id = ?2
+		RETURNING id
+		`, strings.Join(cols, ", ")),
+		"UPDATE station_days SET station_id = ?1 WHERE station_id = ?2 AND date > ?3",
+		"UPDATE stations SET valid_to = date(?1, '-1 day') WHERE id = ?2",
+		"UPDATE stations SET valid_from = date(?1, '+1 day') WHERE id = ?2",
+		"DELETE FROM stations WHERE id = ?",
+		"INSERT OR REPLACE INTO station_days (date, ideess, station_id) VALUES (?, ?, ?)",
+		"INSERT OR REPLACE INTO prices (date, ideess, fuel, price) VALUES (?, ?, ?, ?)",
+	}
+
+	stmts := make([]*sql.Stmt, len(queries))
+	for i, q := range queries {
+		stmt, err := tx.PrepareContext(ctx, q)
+		if err != nil {
+			for _, prepared := range stmts[:i] {
+				prepared.Close()
+			}
+			return nil, fmt.Errorf("error preparing statement: %w", err)
+		}
+		stmts[i] = stmt
+	}
+
+	return &snapshotWriter{
+		deletePrices:  stmts[0],
+		deleteDays:    stmts[1],
+		prevVersion:   stmts[2],
+		nextVersion:   stmts[3],
+		extendVersion: stmts[4],
+		insertVersion: stmts[5],
+		splitVersion:  stmts[6],
+		relinkDays:    stmts[7],
+		endVersion:    stmts[8],
+		startVersion:  stmts[9],
+		deleteVersion: stmts[10],
+		insertDay:     stmts[11],
+		insertPrice:   stmts[12],
+	}, nil
+}
+
+// clear removes the rows previously written for date.
+func (w *snapshotWriter) clear(ctx context.Context, date string) error {
+	if _, err := w.deletePrices.ExecContext(ctx, date); err != nil {
+		return fmt.Errorf("error clearing prices for %s: %w", date, err)
+	}
+	if _, err := w.deleteDays.ExecContext(ctx, date); err != nil {
+		return fmt.Errorf("error clearing station days for %s: %w", date, err)
+	}
+	return nil
+}
+
+// writeDay replaces the rows written for date with stations and returns how
+// many stations were written.
+func (w *snapshotWriter) writeDay(ctx context.Context, date string, stations []*api.GasStation) (int, error) {
+	if err := w.clear(ctx, date); err != nil {
+		return 0, err
+	}
+	for i, station := range stations {
+		if err := w.write(ctx, date, station); err != nil {
+			return i, err
+		}
+	}
+	return len(stations), nil
+}
+
+// write stores a single station as seen on date.
+func (w *snapshotWriter) write(ctx context.Context, date string, station *api.GasStation) error {
+	stationID, err := w.version(ctx, date, station)
+	if err != nil {
+		return fmt.Errorf("error saving station %s: %w", station.IDEESS, err)
+	}
+
+	if _, err := w.insertDay.ExecContext(ctx, date, station.IDEESS, stationID); err != nil {
+		return fmt.Errorf("error saving station day %s: %w", station.IDEESS, err)
+	}
+
+	for _, f := range fuelFields {
+		price, ok := parsePrice(f.get(station))
+		if !ok {
+			continue
+		}
+		if _, err := w.insertPrice.ExecContext(ctx, date, station.IDEESS, string(f.fuel), price); err != nil {
+			return fmt.Errorf("error saving %s price for %s: %w", f.fuel, station.IDEESS, err)
+		}
+	}
+
+	return nil
+}
+
+// version returns the id of the version of station in effect on date. The
+// version before or after date is extended when it has the same metadata and
+// no other version sits in between. Otherwise a new version is inserted, and
+// a version with other metadata whose range covers date is split around it.
+func (w *snapshotWriter) version(ctx context.Context, date string, station *api.GasStation) (int64, error) {
+	metadata := make([]any, len(stationFields))
+	for i, f := range stationFields {
+		metadata[i] = f.get(station)
+	}
+
+	prev, err := w.lookup(ctx, w.prevVersion, date, station.IDEESS, metadata)
+	if err != nil {
+		return 0, err
+	}
+	if prev.same {
+		_, err := w.extendVersion.ExecContext(ctx, date, prev.id)
+		return prev.id, err
+	}
+
+	split := false
+	if prev.id != 0 && prev.validTo >= date {
+		if split, err = w.split(ctx, date, prev); err != nil {
+			return 0, err
+		}
+	}
+
+	if !split {
+		next, err := w.lookup(ctx, w.nextVersion, date, station.IDEESS, metadata)
+		if err != nil {
+			return 0, err
+		}
+		if next.same {
+			_, err := w.extendVersion.ExecContext(ctx, date, next.id)
+			return next.id, err
+		}
+	}
+
+	var id int64
+	args := append([]any{station.IDEESS, date, date}, metadata...)
+	if err := w.insertVersion.QueryRowContext(ctx, args...).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// lookup runs one of the prevVersion and nextVersion queries. A zero
+// stationVersion is returned when there is no such version.
+func (w *snapshotWriter) lookup(ctx context.Context, stmt *sql.Stmt, date, ideess string, metadata []any) (stationVersion, error) {
+	args := append(append([]any{}, metadata...), ideess, date)
+
+	var v stationVersion
+	err := stmt.QueryRowContext(ctx, args...).Scan(&v.id, &v.validFrom, &v.validTo, &v.same)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return stationVersion{}, err
+	}
+	return v, nil
+}
+
+// split takes date out of the range of v, a version with other metadata. The
+// days of v after date move to a copy of v when v also has days before date.
+// v is deleted when date was its only day. It reports whether v, or its copy,
+// still follows date.
+func (w *snapshotWriter) split(ctx context.Context, date string, v stationVersion) (bool, error) {
+	var err error
+	switch {
+	case v.validFrom < date && v.validTo > date:
+		var tail int64
+		if err := w.splitVersion.QueryRowContext(ctx, date, v.id).Scan(&tail); err != nil {
+			return false, err
+		}
+		if _, err := w.relinkDays.ExecContext(ctx, tail, v.id, date); err != nil {
+			return false, err
+		}
+		_, err = w.endVersion.ExecContext(ctx, date, v.id)
+	case v.validFrom < date:
+		_, err = w.endVersion.ExecContext(ctx, date, v.id)
+	case v.validTo > date:
+		_, err = w.startVersion.ExecContext(ctx, date, v.id)
+	default:
+		_, err = w.deleteVersion.ExecContext(ctx, v.id)
+	}
+	return v.validTo > date, err
+}
+
+func (w *snapshotWriter) Close() {
+	for _, stmt := range []*sql.Stmt{
+		w.deletePrices, w.deleteDays, w.prevVersion, w.nextVersion,
+		w.extendVersion, w.insertVersion, w.splitVersion, w.relinkDays,
+		w.endVersion, w.startVersion, w.deleteVersion, w.insertDay, w.insertPrice,
+	} {
+		stmt.Close()
+	}
+}
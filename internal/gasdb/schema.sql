@@ -1,96 +1,231 @@
 CREATE TABLE fuel_prices (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		date TEXT UNIQUE NOT NULL,
-		data BLOB NOT NULL
+		data BLOB NOT NULL,
+		codec TEXT NOT NULL DEFAULT 'none'
 	);
 CREATE TABLE sqlite_sequence(name,seq);
 CREATE INDEX idx_fuel_prices_date ON fuel_prices(date);
-CREATE TABLE historic_prices (
+CREATE TABLE stations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ideess TEXT NOT NULL,
+		valid_from TEXT NOT NULL,
+		valid_to TEXT NOT NULL,
+		cp TEXT NOT NULL DEFAULT '',
+		direccion TEXT NOT NULL DEFAULT '',
+		horario TEXT NOT NULL DEFAULT '',
+		latitud TEXT NOT NULL DEFAULT '',
+		localidad TEXT NOT NULL DEFAULT '',
+		longitud TEXT NOT NULL DEFAULT '',
+		margen TEXT NOT NULL DEFAULT '',
+		municipio TEXT NOT NULL DEFAULT '',
+		provincia TEXT NOT NULL DEFAULT '',
+		rotulo TEXT NOT NULL DEFAULT '',
+		tipo_venta TEXT NOT NULL DEFAULT '',
+		porcentaje_bioetanol TEXT NOT NULL DEFAULT '',
+		porcentaje_ester_metilico TEXT NOT NULL DEFAULT '',
+		idmunicipio TEXT NOT NULL DEFAULT '',
+		idprovincia TEXT NOT NULL DEFAULT '',
+		idccaa TEXT NOT NULL DEFAULT '',
+		CHECK (valid_from <= valid_to)
+	);
+CREATE INDEX idx_stations_validity ON stations(valid_from, valid_to);
+CREATE INDEX idx_stations_ideess ON stations(ideess, valid_from);
+CREATE TABLE station_days (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		date TEXT NOT NULL,
 		ideess TEXT NOT NULL,
-		cp TEXT,
-		direccion TEXT,
-		horario TEXT,
-		latitud TEXT,
-		localidad TEXT,
-		longitud TEXT,
-		margen TEXT,
-		municipio TEXT,
-		provincia TEXT,
-		rotulo TEXT,
-		tipo_venta TEXT,
-		precio_biodiesel TEXT,
-		precio_bioetanol TEXT,
-		precio_gas_natural_comp TEXT,
-		precio_gas_natural_licuado TEXT,
-		precio_gases_licuados TEXT,
-		precio_gasoleo_a TEXT,
-		precio_gasoleo_b TEXT,
-		precio_gasoleo_premium TEXT,
-		precio_gasolina_95_e10 TEXT,
-		precio_gasolina_95_e5 TEXT,
-		precio_gasolina_95_e5_prem TEXT,
-		precio_gasolina_98_e10 TEXT,
-		precio_gasolina_98_e5 TEXT,
-		precio_hidrogeno TEXT,
-		porcentaje_bioetanol TEXT,
-		porcentaje_ester_metilico TEXT,
-		idmunicipio TEXT,
-		idprovincia TEXT,
-		idccaa TEXT,
+		station_id INTEGER NOT NULL REFERENCES stations(id),
 		UNIQUE(date, ideess)
 	);
-CREATE INDEX idx_historic_prices_date ON historic_prices(date);
-CREATE INDEX idx_historic_prices_ideess ON historic_prices(ideess);
-CREATE INDEX idx_historic_prices_latitud_longitud ON historic_prices(latitud, longitud);
-CREATE TRIGGER insert_historic_prices
-	AFTER INSERT ON fuel_prices
+CREATE INDEX idx_station_days_ideess ON station_days(ideess);
+CREATE TABLE prices (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		PRIMARY KEY (date, ideess, fuel)
+	) WITHOUT ROWID;
+CREATE INDEX idx_prices_ideess_fuel ON prices(ideess, fuel, date);
+CREATE VIEW historic_prices AS
+	SELECT
+		d.id, d.date, d.ideess,
+		s.cp,
+		s.direccion,
+		s.horario,
+		s.latitud,
+		s.localidad,
+		s.longitud,
+		s.margen,
+		s.municipio,
+		s.provincia,
+		s.rotulo,
+		s.tipo_venta,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'biodiesel'), '') AS precio_biodiesel,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'bioetanol'), '') AS precio_bioetanol,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gas_natural_comp'), '') AS precio_gas_natural_comp,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gas_natural_licuado'), '') AS precio_gas_natural_licuado,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gases_licuados'), '') AS precio_gases_licuados,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasoleo_a'), '') AS precio_gasoleo_a,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasoleo_b'), '') AS precio_gasoleo_b,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasoleo_premium'), '') AS precio_gasoleo_premium,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasolina_95_e10'), '') AS precio_gasolina_95_e10,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasolina_95_e5'), '') AS precio_gasolina_95_e5,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasolina_95_e5_prem'), '') AS precio_gasolina_95_e5_prem,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasolina_98_e10'), '') AS precio_gasolina_98_e10,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'gasolina_98_e5'), '') AS precio_gasolina_98_e5,
+		COALESCE((SELECT REPLACE(printf('%.3f', p.price), '.', ',') FROM prices p WHERE p.date = d.date AND p.ideess = d.ideess AND p.fuel = 'hidrogeno'), '') AS precio_hidrogeno,
+		s.porcentaje_bioetanol,
+		s.porcentaje_ester_metilico,
+		s.idmunicipio,
+		s.idprovincia,
+		s.idccaa
+	FROM station_days d
+	JOIN stations s ON s.id = d.station_id;
+CREATE VIRTUAL TABLE station_locations USING rtree(
+		id,
+		min_lat, max_lat,
+		min_lng, max_lng
+	);
+CREATE TABLE "station_locations_rowid"(rowid INTEGER PRIMARY KEY,nodeno);
+CREATE TABLE "station_locations_node"(nodeno INTEGER PRIMARY KEY,data);
+CREATE TABLE "station_locations_parent"(nodeno INTEGER PRIMARY KEY,parentnode);
+CREATE TRIGGER index_station_location
+	AFTER INSERT ON stations
+	WHEN (NEW.latitud GLOB '*[0-9]*' AND NOT NEW.latitud GLOB '*[^0-9,.+-]*') AND (NEW.longitud GLOB '*[0-9]*' AND NOT NEW.longitud GLOB '*[^0-9,.+-]*')
 	BEGIN
-		INSERT OR REPLACE INTO historic_prices (
-			date, ideess, cp, direccion, horario, latitud, localidad, longitud,
-			margen, municipio, provincia, rotulo, tipo_venta, precio_biodiesel,
-			precio_bioetanol, precio_gas_natural_comp, precio_gas_natural_licuado,
-			precio_gases_licuados, precio_gasoleo_a, precio_gasoleo_b, precio_gasoleo_premium,
-			precio_gasolina_95_e10, precio_gasolina_95_e5, precio_gasolina_95_e5_prem,
-			precio_gasolina_98_e10, precio_gasolina_98_e5, precio_hidrogeno,
-			porcentaje_bioetanol, porcentaje_ester_metilico, idmunicipio, idprovincia, idccaa
-		)
-		SELECT
-			NEW.date,
-			json_extract(station.value, '$.IDEESS'),
-			json_extract(station.value, '$."C.P."'),
-			json_extract(station.value, '$."Dirección"'),
-			json_extract(station.value, '$.Horario'),
-			json_extract(station.value, '$.Latitud'),
-			json_extract(station.value, '$.Localidad'),
-			json_extract(station.value, '$."Longitud (WGS84)"'),
-			json_extract(station.value, '$.Margen'),
-			json_extract(station.value, '$.Municipio'),
-			json_extract(station.value, '$.Provincia'),
-			json_extract(station.value, '$."Rótulo"'),
-			json_extract(station.value, '$."Tipo Venta"'),
-			json_extract(station.value, '$."Precio Biodiesel"'),
-			json_extract(station.value, '$."Precio Bioetanol"'),
-			json_extract(station.value, '$."Precio Gas Natural Comprimido"'),
-			json_extract(station.value, '$."Precio Gas Natural Licuado"'),
-			json_extract(station.value, '$."Precio Gases licuados del petróleo"'),
-			json_extract(station.value, '$."Precio Gasoleo A"'),
-			json_extract(station.value, '$."Precio Gasoleo B"'),
-			json_extract(station.value, '$."Precio Gasoleo Premium"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E10"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E5"'),
-			json_extract(station.value, '$."Precio Gasolina 95 E5 Premium"'),
-			json_extract(station.value, '$."Precio Gasolina 98 E10"'),
-			json_extract(station.value, '$."Precio Gasolina 98 E5"'),
-			json_extract(station.value, '$."Precio Hidrogeno"'),
-			json_extract(station.value, '$."% BioEtanol"'),
-			json_extract(station.value, '$."% Éster metílico"'),
-			json_extract(station.value, '$.IDMunicipio'),
-			json_extract(station.value, '$.IDProvincia'),
-			json_extract(station.value, '$.IDCCAA')
-		FROM json_each(json_extract(NEW.data, '$.ListaEESSPrecio')) AS station;
+		INSERT OR REPLACE INTO station_locations (id, min_lat, max_lat, min_lng, max_lng)
+		VALUES (
+			NEW.id,
+			CAST(REPLACE(NEW.latitud, ',', '.') AS REAL), CAST(REPLACE(NEW.latitud, ',', '.') AS REAL),
+			CAST(REPLACE(NEW.longitud, ',', '.') AS REAL), CAST(REPLACE(NEW.longitud, ',', '.') AS REAL)
+		);
 	END;
+CREATE TABLE ingestion_stats (
+		date TEXT PRIMARY KEY,
+		ingested_at TEXT NOT NULL,
+		stations INTEGER NOT NULL,
+		written INTEGER NOT NULL,
+		rejected INTEGER NOT NULL,
+		prices INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL
+	);
+CREATE TABLE quarantine (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL,
+		value REAL NOT NULL,
+		expected REAL NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		dismissed INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (date, ideess, fuel, reason)
+	) WITHOUT ROWID;
+CREATE INDEX idx_quarantine_ideess ON quarantine (ideess, date);
+CREATE TABLE station_events (
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		old_value TEXT NOT NULL DEFAULT '',
+		new_value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (date, ideess, kind)
+	) WITHOUT ROWID;
+CREATE INDEX idx_station_events_ideess ON station_events (ideess, date);
+CREATE TABLE watch_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		fuel TEXT NOT NULL,
+		condition TEXT NOT NULL,
+		threshold REAL NOT NULL DEFAULT 0,
+		stations TEXT NOT NULL DEFAULT '',
+		latitude REAL NOT NULL DEFAULT 0,
+		longitude REAL NOT NULL DEFAULT 0,
+		radius REAL NOT NULL DEFAULT 0,
+		cooldown_seconds INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+CREATE TABLE alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		rotulo TEXT NOT NULL DEFAULT '',
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		condition TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(rule_id, date, ideess)
+	);
+CREATE INDEX idx_alerts_date ON alerts (date);
+CREATE TABLE webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+CREATE TABLE webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TEXT NOT NULL DEFAULT '',
+		next_attempt_at TEXT NOT NULL DEFAULT ''
+	);
+CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries (status, id);
+CREATE VIRTUAL TABLE station_search USING fts5(
+		rotulo, direccion, localidad, municipio, provincia, cp,
+		content = 'stations',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+CREATE TABLE 'station_search_data'(id INTEGER PRIMARY KEY, block BLOB);
+CREATE TABLE 'station_search_idx'(segid, term, pgno, PRIMARY KEY(segid, term)) WITHOUT ROWID;
+CREATE TABLE 'station_search_docsize'(id INTEGER PRIMARY KEY, sz BLOB);
+CREATE TABLE 'station_search_config'(k PRIMARY KEY, v) WITHOUT ROWID;
+CREATE TRIGGER index_station_search
+	AFTER INSERT ON stations
+	BEGIN
+		INSERT INTO station_search (rowid, rotulo, direccion, localidad, municipio, provincia, cp) VALUES (NEW.id, NEW.rotulo, NEW.direccion, NEW.localidad, NEW.municipio, NEW.provincia, NEW.cp);
+	END;
+CREATE TRIGGER unindex_station_search
+	AFTER DELETE ON stations
+	BEGIN
+		INSERT INTO station_search (station_search, rowid, rotulo, direccion, localidad, municipio, provincia, cp) VALUES ('delete', OLD.id, OLD.rotulo, OLD.direccion, OLD.localidad, OLD.municipio, OLD.provincia, OLD.cp);
+	END;
+CREATE TABLE price_aggregates (
+		granularity TEXT NOT NULL,
+		period TEXT NOT NULL,
+		group_by TEXT NOT NULL,
+		group_key TEXT NOT NULL,
+		label TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		avg REAL NOT NULL,
+		median REAL NOT NULL,
+		min REAL NOT NULL,
+		max REAL NOT NULL,
+		stations INTEGER NOT NULL,
+		PRIMARY KEY (granularity, group_by, period, group_key, fuel)
+	) WITHOUT ROWID;
+CREATE TABLE weekly_prices (
+		week TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		samples INTEGER NOT NULL,
+		PRIMARY KEY (week, ideess, fuel)
+	) WITHOUT ROWID;
+CREATE INDEX idx_weekly_prices_ideess_fuel ON weekly_prices (ideess, fuel, week);
+CREATE TABLE downsampled_days (
+		date TEXT PRIMARY KEY
+	) WITHOUT ROWID;
 CREATE TABLE location_logs (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		latitude REAL NOT NULL,
@@ -98,6 +233,43 @@ CREATE TABLE location_logs (
 		distance REAL NOT NULL,
 		search_count INTEGER NOT NULL DEFAULT 1,
 		search_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		last_search TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		last_search TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		geohash TEXT NOT NULL DEFAULT ''
 	);
 CREATE INDEX idx_location_logs_coordinates ON location_logs (latitude, longitude);
+CREATE INDEX idx_location_logs_geohash ON location_logs (geohash);
+CREATE TABLE location_log_events (
+		geohash TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		latitude REAL NOT NULL,
+		longitude REAL NOT NULL,
+		search_count INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (geohash, bucket)
+	) WITHOUT ROWID;
+CREATE INDEX idx_location_log_events_bucket ON location_log_events (bucket);
+CREATE TABLE backfill_checkpoints (
+		date TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+CREATE TABLE intraday_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		fetched_at TEXT NOT NULL,
+		published_at TEXT NOT NULL DEFAULT '',
+		date TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		data BLOB NOT NULL,
+		codec TEXT NOT NULL DEFAULT 'none',
+		UNIQUE(fetched_at, published_at)
+	);
+CREATE INDEX idx_intraday_snapshots_date ON intraday_snapshots (date);
+CREATE TABLE intraday_prices (
+		snapshot_id INTEGER NOT NULL,
+		ideess TEXT NOT NULL,
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		PRIMARY KEY (snapshot_id, ideess, fuel)
+	) WITHOUT ROWID;
+CREATE INDEX idx_intraday_prices_ideess_fuel ON intraday_prices (ideess, fuel, snapshot_id);
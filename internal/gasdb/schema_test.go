@@ -0,0 +1,386 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+const legacyHistoricPricesSQL = `
+CREATE TABLE historic_prices (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	date TEXT NOT NULL,
+	ideess TEXT NOT NULL,
+	cp TEXT, direccion TEXT, horario TEXT, latitud TEXT, localidad TEXT, longitud TEXT,
+	margen TEXT, municipio TEXT, provincia TEXT, rotulo TEXT, tipo_venta TEXT,
+	precio_biodiesel TEXT, precio_bioetanol TEXT, precio_gas_natural_comp TEXT,
+	precio_gas_natural_licuado TEXT, precio_gases_licuados TEXT, precio_gasoleo_a TEXT,
+	precio_gasoleo_b TEXT, precio_gasoleo_premium TEXT, precio_gasolina_95_e10 TEXT,
+	precio_gasolina_95_e5 TEXT, precio_gasolina_95_e5_prem TEXT, precio_gasolina_98_e10 TEXT,
+	precio_gasolina_98_e5 TEXT, precio_hidrogeno TEXT, porcentaje_bioetanol TEXT,
+	porcentaje_ester_metilico TEXT, idmunicipio TEXT, idprovincia TEXT, idccaa TEXT,
+	UNIQUE(date, ideess)
+);
+`
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(context.Background(), filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewStorage() failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testStation(ideess, rotulo, diesel, gasolina95 string) api.GasStation {
+	return api.GasStation{
+		IDEESS:             ideess,
+		CP:                 "28001",
+		Direccion:          "CALLE MAYOR, " + ideess,
+		Horario:            "L-D: 24H",
+		Latitud:            "40,416800",
+		Longitud:           "-3,703800",
+		Localidad:          "MADRID",
+		Municipio:          "Madrid",
+		Provincia:          "MADRID",
+		Margen:             "D",
+		Rotulo:             rotulo,
+		TipoVenta:          "P",
+		PrecioGasoleoA:     diesel,
+		PrecioGasolina95E5: gasolina95,
+		IDMunicipio:        "4354",
+		IDProvincia:        "28",
+		IDCCAA:             "13",
+	}
+}
+
+func testSnapshots() map[string][]api.GasStation {
+	return map[string][]api.GasStation{
+		"2024-01-01": {
+			testStation("1", "REPSOL", "1,459", "1,599"),
+			testStation("2", "CEPSA", "1,479", ""),
+		},
+		"2024-01-02": {
+			testStation("1", "REPSOL", "1,449", "1,589"),
+			testStation("2", "MOEVE", "1,469", "1,609"),
+		},
+	}
+}
+
+func saveTestSnapshots(t *testing.T, s *Storage) {
+	t.Helper()
+	for date, stations := range testSnapshots() {
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: stations, ResultadoConsulta: api.ApiResultOK})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _ := time.Parse("2006-01-02", date)
+		if err := s.SavePrices(context.Background(), d, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+}
+
+// historicRows returns historic_prices ordered by date and ideess, without ids.
+func historicRows(t *testing.T, db *sql.DB) [][]string {
+	t.Helper()
+	rows, err := db.Query("SELECT * FROM historic_prices ORDER BY date, ideess")
+	if err != nil {
+		t.Fatalf("querying historic_prices: %v", err)
+	}
+	defer rows.Close()
+
+	cols, _ := rows.Columns()
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatal(err)
+		}
+		row := make([]string, 0, len(cols)-1)
+		for _, v := range values[1:] {
+			row = append(row, v.String)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func expectedHistoricRows() [][]string {
+	var result [][]string
+	for _, date := range []string{"2024-01-01", "2024-01-02"} {
+		for i := range testSnapshots()[date] {
+			station := &testSnapshots()[date][i]
+			row := []string{date, station.IDEESS}
+			for _, f := range stationFields[:stationFieldsBeforePrices] {
+				row = append(row, f.get(station))
+			}
+			for _, f := range fuelFields {
+				row = append(row, f.get(station))
+			}
+			for _, f := range stationFields[stationFieldsBeforePrices:] {
+				row = append(row, f.get(station))
+			}
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+func TestHistoricPricesView(t *testing.T) {
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	got := historicRows(t, s.db)
+	if want := expectedHistoricRows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("historic_prices mismatch\n got: %v\nwant: %v", got, want)
+	}
+
+	var versions int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM stations WHERE ideess = '2'").Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != 2 {
+		t.Errorf("expected 2 metadata versions for station 2, got %d", versions)
+	}
+
+	var validFrom, validTo string
+	err := s.db.QueryRow("SELECT valid_from, valid_to FROM stations WHERE ideess = '1'").Scan(&validFrom, &validTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validFrom != "2024-01-01" || validTo != "2024-01-02" {
+		t.Errorf("unexpected validity range for station 1: %s - %s", validFrom, validTo)
+	}
+}
+
+func TestMigrateLegacyHistoricPrices(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(legacyHistoricPricesSQL); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range expectedHistoricRows() {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(row)), ", ")
+		args := make([]any, len(row))
+		for i, v := range row {
+			args[i] = v
+		}
+		query := fmt.Sprintf("INSERT INTO historic_prices VALUES (NULL, %s)", placeholders)
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := NewStorage(ctx, path, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewStorage() failed: %v", err)
+	}
+	defer s.Close()
+
+	legacy, err := s.historicPricesIsTable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy {
+		t.Fatal("historic_prices is still a table after migration")
+	}
+
+	got := historicRows(t, s.db)
+	if want := expectedHistoricRows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("historic_prices mismatch after migration\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestStationVersions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	save := func(date string, rotulo string) {
+		t.Helper()
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{
+			testStation("1", rotulo, "1,459", "1,599"),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.savePrices(ctx, day, data, false); err != nil {
+			t.Fatalf("savePrices(%s) failed: %v", date, err)
+		}
+	}
+	versions := func() []string {
+		t.Helper()
+		rows, err := s.db.Query("SELECT rotulo, valid_from, valid_to FROM stations WHERE ideess = '1' ORDER BY valid_from")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var rotulo, from, to string
+			if err := rows.Scan(&rotulo, &from, &to); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rotulo+" "+from+" "+to)
+		}
+		return got
+	}
+	days := func() []string {
+		t.Helper()
+		rows, err := s.db.Query(`
+			SELECT d.date, s.rotulo FROM station_days d
+			JOIN stations s ON s.id = d.station_id AND d.date BETWEEN s.valid_from AND s.valid_to
+			ORDER BY d.date`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var date, rotulo string
+			if err := rows.Scan(&date, &rotulo); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, date+" "+rotulo)
+		}
+		return got
+	}
+
+	save("2024-01-01", "REPSOL")
+	save("2024-01-02", "REPSOL")
+	save("2024-01-03", "CEPSA")
+	save("2024-01-04", "REPSOL")
+	want := []string{
+		"REPSOL 2024-01-01 2024-01-02",
+		"CEPSA 2024-01-03 2024-01-03",
+		"REPSOL 2024-01-04 2024-01-04",
+	}
+	if got := versions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected versions after A-B-A\n got: %v\nwant: %v", got, want)
+	}
+
+	// A day backfilled inside a version with other metadata splits it
+	save("2024-01-07", "REPSOL")
+	save("2024-01-05", "CEPSA")
+	want = []string{
+		"REPSOL 2024-01-01 2024-01-02",
+		"CEPSA 2024-01-03 2024-01-03",
+		"REPSOL 2024-01-04 2024-01-04",
+		"CEPSA 2024-01-05 2024-01-05",
+		"REPSOL 2024-01-06 2024-01-07",
+	}
+	if got := versions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected versions after a backfilled change\n got: %v\nwant: %v", got, want)
+	}
+
+	// Filling the gap before the next version with its metadata extends it
+	save("2024-01-06", "REPSOL")
+	if got := versions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected versions after filling a gap\n got: %v\nwant: %v", got, want)
+	}
+
+	wantDays := []string{
+		"2024-01-01 REPSOL", "2024-01-02 REPSOL", "2024-01-03 CEPSA", "2024-01-04 REPSOL",
+		"2024-01-05 CEPSA", "2024-01-06 REPSOL", "2024-01-07 REPSOL",
+	}
+	if got := days(); !reflect.DeepEqual(got, wantDays) {
+		t.Errorf("station days outside their version\n got: %v\nwant: %v", got, wantDays)
+	}
+}
+
+func TestMigrateStationVersions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "unique.db")
+
+	s, err := NewStorage(ctx, path, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewStorage() failed: %v", err)
+	}
+	saveTestSnapshots(t, s)
+	s.Close()
+
+	// Rebuild stations as it was created before versions could repeat
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unique := strings.Replace(stationsTableSQL("stations_unique"), "CHECK (valid_from <= valid_to)",
+		"UNIQUE(ideess, "+strings.Join(stationColumns(), ", ")+")", 1)
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		"PRAGMA foreign_keys = OFF",
+		"DROP VIEW historic_prices",
+		unique,
+		"INSERT INTO stations_unique SELECT * FROM stations",
+		"DROP TABLE stations",
+		"ALTER TABLE stations_unique RENAME TO stations",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	s, err = NewStorage(ctx, path, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewStorage() failed: %v", err)
+	}
+	defer s.Close()
+
+	if got, want := historicRows(t, s.db), expectedHistoricRows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("historic_prices mismatch after migration\n got: %v\nwant: %v", got, want)
+	}
+
+	// Station 2 going back to its first metadata needs a second CEPSA row
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{
+		testStation("2", "CEPSA", "1,479", ""),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), data); err != nil {
+		t.Fatalf("SavePrices() failed after migration: %v", err)
+	}
+	var versions int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM stations WHERE ideess = '2'").Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != 3 {
+		t.Errorf("expected 3 versions for station 2, got %d", versions)
+	}
+
+	var matches int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM station_search WHERE station_search MATCH 'cepsa'").Scan(&matches); err != nil {
+		t.Fatal(err)
+	}
+	if matches != 2 {
+		t.Errorf("expected both CEPSA versions in the search index, got %d", matches)
+	}
+}
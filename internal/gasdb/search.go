@@ -0,0 +1,135 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultSearchLimit = 20
+
+// searchColumns are the stations columns indexed by station_search.
+var searchColumns = []string{"rotulo", "direccion", "localidad", "municipio", "provincia", "cp"}
+
+// createSearchIndex creates station_search, an FTS5 index over the name and
+// address of every station version kept in sync with stations by triggers.
+// Accents are removed when tokenizing, so "america" matches "AMÉRICA". An
+// index created on an existing database is built from its stations.
+func (s *Storage) createSearchIndex(ctx context.Context) error {
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'station_search'").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error inspecting station_search: %w", err)
+	}
+
+	cols := strings.Join(searchColumns, ", ")
+	newCols := "NEW." + strings.Join(searchColumns, ", NEW.")
+	oldCols := "OLD." + strings.Join(searchColumns, ", OLD.")
+
+	createIndexSQL := fmt.Sprintf(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS station_search USING fts5(
+		%[1]s,
+		content = 'stations',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS index_station_search
+	AFTER INSERT ON stations
+	BEGIN
+		INSERT INTO station_search (rowid, %[1]s) VALUES (NEW.id, %[2]s);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS unindex_station_search
+	AFTER DELETE ON stations
+	BEGIN
+		INSERT INTO station_search (station_search, rowid, %[1]s) VALUES ('delete', OLD.id, %[3]s);
+	END;
+	`, cols, newCols, oldCols)
+
+	if _, err := s.db.ExecContext(ctx, createIndexSQL); err != nil {
+		return fmt.Errorf("error creating search index: %w", err)
+	}
+
+	if exists == 0 {
+		if _, err := s.db.ExecContext(ctx, "INSERT INTO station_search (station_search) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("error building search index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// searchQuery turns free text into an FTS5 query matching every word as a
+// prefix, so user input never hits the FTS5 query syntax.
+func searchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchStations returns the stations whose name or address contains every
+// word of query, best match first. Each station is returned once, as its
+// most recent version matching the query, so a station can be found by a
+// former brand or address. A limit of zero returns the default number of
+// results.
+func (s *Storage) SearchStations(ctx context.Context, query string, limit int) ([]StationLocation, error) {
+	match := searchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH matches AS (
+			SELECT s.*,
+				ROW_NUMBER() OVER (PARTITION BY s.ideess ORDER BY s.valid_to DESC, s.id DESC) AS version,
+				MIN(m.rank) OVER (PARTITION BY s.ideess) AS best
+			FROM station_search m
+			JOIN stations s ON s.id = m.rowid
+			WHERE station_search MATCH ?
+		)
+		SELECT id, ideess, rotulo, direccion, localidad, municipio, provincia, cp,
+			latitud, longitud, valid_from, valid_to
+		FROM matches
+		WHERE version = 1
+		ORDER BY best, ideess
+		LIMIT ?
+	`, match, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching stations: %w", err)
+	}
+	defer rows.Close()
+
+	var stations []StationLocation
+	for rows.Next() {
+		var loc StationLocation
+		var lat, lng, validFrom, validTo string
+		if err := rows.Scan(&loc.ID, &loc.IDEESS, &loc.Rotulo, &loc.Direccion, &loc.Localidad, &loc.Municipio,
+			&loc.Provincia, &loc.CP, &lat, &lng, &validFrom, &validTo); err != nil {
+			return nil, fmt.Errorf("error scanning station: %w", err)
+		}
+		// Coordinates are informative here, keep stations without them
+		loc.Latitude, _ = ParseLatLong(lat)
+		loc.Longitude, _ = ParseLatLong(lng)
+		loc.ValidFrom, _ = time.Parse("2006-01-02", validFrom)
+		loc.ValidTo, _ = time.Parse("2006-01-02", validTo)
+		stations = append(stations, loc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stations: %w", err)
+	}
+
+	return stations, nil
+}
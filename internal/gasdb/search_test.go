@@ -0,0 +1,84 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestSearchStations(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	saveTestSnapshots(t, s)
+
+	america := testStation("3", "Galp", "1,499", "")
+	america.Direccion = "AVENIDA DE AMÉRICA, 12"
+	america.Localidad = "ALCOBENDAS"
+	america.CP = "28100"
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{america}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"galp avenida america", []string{"3"}},
+		{"Av. de Amér", []string{"3"}},
+		{"28100", []string{"3"}},
+		{"madrid", []string{"1", "2", "3"}},
+		{"calle mayor", []string{"1", "2"}},
+		{"shell", nil},
+		{`" OR *`, nil},
+	}
+	for _, tt := range tests {
+		stations, err := s.SearchStations(ctx, tt.query, 0)
+		if err != nil {
+			t.Fatalf("SearchStations(%q) failed: %v", tt.query, err)
+		}
+		got := make(map[string]bool)
+		for _, st := range stations {
+			if got[st.IDEESS] {
+				t.Errorf("SearchStations(%q) returned station %s twice", tt.query, st.IDEESS)
+			}
+			got[st.IDEESS] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("SearchStations(%q) = %v, want %v", tt.query, stations, tt.want)
+			continue
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("SearchStations(%q) is missing station %s", tt.query, id)
+			}
+		}
+	}
+
+	// A former brand finds the station as it was listed under that brand
+	stations, err := s.SearchStations(ctx, "cepsa", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].IDEESS != "2" || stations[0].Rotulo != "CEPSA" {
+		t.Errorf("unexpected results for a former brand: %+v", stations)
+	}
+
+	stations, err = s.SearchStations(ctx, "moeve", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].Rotulo != "MOEVE" {
+		t.Errorf("unexpected results for the current brand: %+v", stations)
+	}
+
+	if stations, err := s.SearchStations(ctx, "madrid", 2); err != nil || len(stations) != 2 {
+		t.Errorf("limit not applied: %d results, %v", len(stations), err)
+	}
+}
@@ -0,0 +1,191 @@
+package gasdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	// metersPerDegreeLat matches the earth radius used by gpx.Distance2D.
+	metersPerDegreeLat = 6371000 * math.Pi / 180
+	// bboxMargin widens radius bounding boxes so R*Tree rounding never drops a match.
+	bboxMargin   = 1.01
+	maxLatitude  = 89.9
+	minDateBound = "0000-01-01"
+	maxDateBound = "9999-12-31"
+)
+
+// isNumericSQL matches coordinates that CAST cleanly to REAL.
+const isNumericSQL = "(%[1]s GLOB '*[0-9]*' AND NOT %[1]s GLOB '*[^0-9,.+-]*')"
+
+// StationLocation is a station metadata version with parsed coordinates.
+// A station that moved or changed its details has one entry per version.
+type StationLocation struct {
+	ID        int64
+	IDEESS    string
+	Rotulo    string
+	Direccion string
+	Localidad string
+	Municipio string
+	Provincia string
+	CP        string
+	Latitude  float64
+	Longitude float64
+	ValidFrom time.Time
+	ValidTo   time.Time
+	// Distance in meters from the search center, only set by StationsWithinRadius.
+	Distance float64
+}
+
+// BBox is a latitude/longitude bounding box in decimal degrees.
+type BBox struct {
+	MinLat float64
+	MinLng float64
+	MaxLat float64
+	MaxLng float64
+}
+
+// CreateSpatialIndex creates the station_locations R*Tree and the trigger
+// that indexes every new station version, then indexes any existing version
+// missing from it. Versions with unparsable coordinates are not indexed.
+func (s *Storage) CreateSpatialIndex(ctx context.Context) error {
+	numeric := fmt.Sprintf(isNumericSQL, "latitud") + " AND " + fmt.Sprintf(isNumericSQL, "longitud")
+	newNumeric := fmt.Sprintf(isNumericSQL, "NEW.latitud") + " AND " + fmt.Sprintf(isNumericSQL, "NEW.longitud")
+
+	createIndexSQL := fmt.Sprintf(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS station_locations USING rtree(
+		id,
+		min_lat, max_lat,
+		min_lng, max_lng
+	);
+
+	CREATE TRIGGER IF NOT EXISTS index_station_location
+	AFTER INSERT ON stations
+	WHEN %[1]s
+	BEGIN
+		INSERT OR REPLACE INTO station_locations (id, min_lat, max_lat, min_lng, max_lng)
+		VALUES (
+			NEW.id,
+			CAST(REPLACE(NEW.latitud, ',', '.') AS REAL), CAST(REPLACE(NEW.latitud, ',', '.') AS REAL),
+			CAST(REPLACE(NEW.longitud, ',', '.') AS REAL), CAST(REPLACE(NEW.longitud, ',', '.') AS REAL)
+		);
+	END;
+
+	INSERT INTO station_locations (id, min_lat, max_lat, min_lng, max_lng)
+	SELECT
+		id,
+		CAST(REPLACE(latitud, ',', '.') AS REAL), CAST(REPLACE(latitud, ',', '.') AS REAL),
+		CAST(REPLACE(longitud, ',', '.') AS REAL), CAST(REPLACE(longitud, ',', '.') AS REAL)
+	FROM stations
+	WHERE %[2]s
+	AND id NOT IN (SELECT id FROM station_locations);
+	`, newNumeric, numeric)
+
+	if _, err := s.db.ExecContext(ctx, createIndexSQL); err != nil {
+		return fmt.Errorf("error creating spatial index: %w", err)
+	}
+
+	return nil
+}
+
+// StationsInBBox returns the station versions inside bbox that were listed at
+// some point between from and to. Zero times leave the range unbounded.
+func (s *Storage) StationsInBBox(ctx context.Context, bbox BBox, from, to time.Time) ([]StationLocation, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !from.IsZero() {
+		fromStr = from.Format("2006-01-02")
+	}
+	if !to.IsZero() {
+		toStr = to.Format("2006-01-02")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.ideess, s.rotulo, s.direccion, s.localidad, s.municipio, s.provincia, s.cp,
+			s.latitud, s.longitud, s.valid_from, s.valid_to
+		FROM station_locations l
+		JOIN stations s ON s.id = l.id
+		WHERE l.max_lat >= ? AND l.min_lat <= ?
+		AND l.max_lng >= ? AND l.min_lng <= ?
+		AND s.valid_from <= ? AND s.valid_to >= ?
+		ORDER BY s.ideess, s.valid_from
+	`, bbox.MinLat, bbox.MaxLat, bbox.MinLng, bbox.MaxLng, toStr, fromStr)
+	if err != nil {
+		return nil, fmt.Errorf("error querying station locations: %w", err)
+	}
+	defer rows.Close()
+
+	var locations []StationLocation
+	for rows.Next() {
+		var loc StationLocation
+		var lat, lng, validFrom, validTo string
+		if err := rows.Scan(&loc.ID, &loc.IDEESS, &loc.Rotulo, &loc.Direccion, &loc.Localidad, &loc.Municipio,
+			&loc.Provincia, &loc.CP, &lat, &lng, &validFrom, &validTo); err != nil {
+			return nil, fmt.Errorf("error scanning station location: %w", err)
+		}
+
+		// The R*Tree stores 32-bit floats, so filter again on the exact coordinates
+		if loc.Latitude, err = ParseLatLong(lat); err != nil {
+			continue
+		}
+		if loc.Longitude, err = ParseLatLong(lng); err != nil {
+			continue
+		}
+		if loc.Latitude < bbox.MinLat || loc.Latitude > bbox.MaxLat ||
+			loc.Longitude < bbox.MinLng || loc.Longitude > bbox.MaxLng {
+			continue
+		}
+		loc.ValidFrom, _ = time.Parse("2006-01-02", validFrom)
+		loc.ValidTo, _ = time.Parse("2006-01-02", validTo)
+
+		locations = append(locations, loc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating station locations: %w", err)
+	}
+
+	return locations, nil
+}
+
+// StationsWithinRadius returns the station versions within radius meters of
+// the given coordinates that were listed at some point between from and to,
+// closest first. Distances are computed with gpx.Distance2D, as NearbyPrices does.
+func (s *Storage) StationsWithinRadius(ctx context.Context, lat, lng, radius float64, from, to time.Time) ([]StationLocation, error) {
+	candidates, err := s.StationsInBBox(ctx, radiusBBox(lat, lng, radius), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []StationLocation
+	for _, loc := range candidates {
+		loc.Distance = gpx.Distance2D(lat, lng, loc.Latitude, loc.Longitude, true)
+		if loc.Distance <= radius {
+			locations = append(locations, loc)
+		}
+	}
+
+	sort.SliceStable(locations, func(i, j int) bool {
+		return locations[i].Distance < locations[j].Distance
+	})
+
+	return locations, nil
+}
+
+// radiusBBox returns a bounding box containing every point within radius meters of lat, lng.
+func radiusBBox(lat, lng, radius float64) BBox {
+	dLat := radius / metersPerDegreeLat * bboxMargin
+	maxAbsLat := math.Min(math.Abs(lat)+dLat, maxLatitude)
+	dLng := dLat / math.Cos(maxAbsLat*math.Pi/180)
+
+	return BBox{
+		MinLat: lat - dLat,
+		MaxLat: lat + dLat,
+		MinLng: lng - dLng,
+		MaxLng: lng + dLng,
+	}
+}
@@ -0,0 +1,73 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestStationsWithinRadius(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	// Stations on a grid around Madrid, roughly 0-30 km from the center
+	var stations []api.GasStation
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			st := testStation(fmt.Sprintf("%d", i*10+j), "REPSOL", "1,459", "1,599")
+			st.Latitud = fmt.Sprintf("%.6f", 40.3+float64(i)*0.025)
+			st.Longitud = fmt.Sprintf("%.6f", -3.85+float64(j)*0.033)
+			stations = append(stations, st)
+		}
+	}
+	broken := testStation("999", "BROKEN", "1,459", "")
+	broken.Latitud = "n/a"
+	stations = append(stations, broken)
+
+	list := api.GasStationList{ListaEESSPrecio: stations, ResultadoConsulta: api.ApiResultOK}
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.SavePrices(ctx, date, data); err != nil {
+		t.Fatal(err)
+	}
+
+	lat, lng := 40.4168, -3.7038
+	for _, radius := range []float64{1000, 5000, 10000, 25000} {
+		var want []string
+		for _, st := range filterNearby(&list, lat, lng, radius) {
+			want = append(want, st.IDEESS)
+		}
+
+		locations, err := s.StationsWithinRadius(ctx, lat, lng, radius, date, date)
+		if err != nil {
+			t.Fatalf("StationsWithinRadius() failed: %v", err)
+		}
+		var got []string
+		for _, loc := range locations {
+			got = append(got, loc.IDEESS)
+		}
+
+		sort.Strings(want)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("radius %.0f: got %v, want %v", radius, got, want)
+		}
+	}
+
+	// Nothing was listed before the snapshot date
+	locations, err := s.StationsWithinRadius(ctx, lat, lng, 25000, time.Time{}, date.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 0 {
+		t.Errorf("expected no stations before %s, got %d", date.Format("2006-01-02"), len(locations))
+	}
+}
@@ -0,0 +1,483 @@
+package gasdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// WatchCondition is the price condition a watch rule alerts on.
+type WatchCondition string
+
+const (
+	// WatchBelow fires when a price drops below the rule threshold.
+	WatchBelow WatchCondition = "below"
+	// WatchCheapestInTown fires when a station has the cheapest price of its
+	// municipality.
+	WatchCheapestInTown WatchCondition = "cheapest_in_town"
+)
+
+// defaultWatchCooldown is the cooldown of the rules created without one.
+const defaultWatchCooldown = 7 * 24 * time.Hour
+
+// ErrWatchRuleNotFound is returned when a watch rule does not exist.
+var ErrWatchRuleNotFound = errors.New("watch rule not found")
+
+// ParseWatchCondition validates a watch condition name.
+func ParseWatchCondition(name string) (WatchCondition, error) {
+	switch c := WatchCondition(strings.ToLower(name)); c {
+	case WatchBelow, WatchCheapestInTown:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown watch condition %q", name)
+	}
+}
+
+// WatchRule is a persistent price alert on a set of stations.
+type WatchRule struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Fuel      Fuel           `json:"fuel"`
+	Condition WatchCondition `json:"condition"`
+	// Threshold is the price WatchBelow compares with.
+	Threshold float64 `json:"threshold,omitempty"`
+	// Stations limits the rule to these stations. When empty, the rule
+	// covers the stations within Radius meters of Latitude and Longitude.
+	Stations  []string `json:"stations,omitempty"`
+	Latitude  float64  `json:"latitude,omitempty"`
+	Longitude float64  `json:"longitude,omitempty"`
+	Radius    float64  `json:"radius,omitempty"`
+	// Cooldown is the minimum time between two alerts of the rule for the
+	// same station, seven days when zero.
+	Cooldown  time.Duration `json:"cooldown"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// validate checks the rule can be evaluated and normalizes its fuel and
+// condition names.
+func (r *WatchRule) validate() error {
+	var err error
+	if r.Fuel, err = ParseFuel(string(r.Fuel)); err != nil {
+		return err
+	}
+	if r.Condition, err = ParseWatchCondition(string(r.Condition)); err != nil {
+		return err
+	}
+	if r.Condition == WatchBelow && r.Threshold <= 0 {
+		return errors.New("a below rule needs a positive threshold")
+	}
+	if len(r.Stations) == 0 && r.Radius <= 0 {
+		return errors.New("a watch rule needs stations or a radius")
+	}
+	if r.Cooldown < 0 {
+		return errors.New("the cooldown cannot be negative")
+	}
+	return nil
+}
+
+// Alert is a watch rule firing for a station on a day.
+type Alert struct {
+	ID        int64          `json:"id"`
+	RuleID    int64          `json:"rule_id"`
+	Date      string         `json:"date"`
+	IDEESS    string         `json:"ideess"`
+	Rotulo    string         `json:"rotulo"`
+	Fuel      Fuel           `json:"fuel"`
+	Price     float64        `json:"price"`
+	Condition WatchCondition `json:"condition"`
+	Detail    string         `json:"detail"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// AlertQuery filters Alerts. Zero fields match everything.
+type AlertQuery struct {
+	RuleID int64
+	From   time.Time
+	To     time.Time
+}
+
+// createWatchTables creates watch_rules and the alerts they raised.
+func (s *Storage) createWatchTables(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS watch_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		fuel TEXT NOT NULL,
+		condition TEXT NOT NULL,
+		threshold REAL NOT NULL DEFAULT 0,
+		stations TEXT NOT NULL DEFAULT '',
+		latitude REAL NOT NULL DEFAULT 0,
+		longitude REAL NOT NULL DEFAULT 0,
+		radius REAL NOT NULL DEFAULT 0,
+		cooldown_seconds INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		ideess TEXT NOT NULL,
+		rotulo TEXT NOT NULL DEFAULT '',
+		fuel TEXT NOT NULL,
+		price REAL NOT NULL,
+		condition TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(rule_id, date, ideess)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_alerts_date ON alerts (date);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating watch tables: %w", err)
+	}
+	return nil
+}
+
+// AddWatchRule stores a watch rule, evaluated from the next snapshot saved,
+// and returns its id.
+func (s *Storage) AddWatchRule(ctx context.Context, rule WatchRule) (int64, error) {
+	if err := rule.validate(); err != nil {
+		return 0, err
+	}
+	if rule.Cooldown == 0 {
+		rule.Cooldown = defaultWatchCooldown
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO watch_rules (name, fuel, condition, threshold, stations, latitude, longitude, radius, cooldown_seconds)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, string(rule.Fuel), string(rule.Condition), rule.Threshold, strings.Join(rule.Stations, ","),
+		rule.Latitude, rule.Longitude, rule.Radius, int64(rule.Cooldown.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("error saving watch rule: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// WatchRules returns every watch rule by id.
+func (s *Storage) WatchRules(ctx context.Context) ([]WatchRule, error) {
+	return queryWatchRules(ctx, s.db)
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// queryWatchRules returns every watch rule by id.
+func queryWatchRules(ctx context.Context, q queryer) ([]WatchRule, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, name, fuel, condition, threshold, stations, latitude, longitude, radius, cooldown_seconds, created_at
+		FROM watch_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying watch rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []WatchRule
+	for rows.Next() {
+		var r WatchRule
+		var fuel, condition, stations, createdAt string
+		var cooldown int64
+		if err := rows.Scan(&r.ID, &r.Name, &fuel, &condition, &r.Threshold, &stations,
+			&r.Latitude, &r.Longitude, &r.Radius, &cooldown, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning watch rule: %w", err)
+		}
+		r.Fuel, r.Condition = Fuel(fuel), WatchCondition(condition)
+		if stations != "" {
+			r.Stations = strings.Split(stations, ",")
+		}
+		r.Cooldown = time.Duration(cooldown) * time.Second
+		r.CreatedAt = parseSQLiteTime(createdAt)
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating watch rules: %w", err)
+	}
+	return rules, nil
+}
+
+// DeleteWatchRule deletes a watch rule and its alerts.
+func (s *Storage) DeleteWatchRule(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM watch_rules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting watch rule: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error deleting watch rule: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("%w: %d", ErrWatchRuleNotFound, id)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM alerts WHERE rule_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting alerts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// watchedPrice is a price of a day with the station details rules look at.
+type watchedPrice struct {
+	ideess, rotulo, municipio, idMunicipio string
+	price                                  float64
+	lat, lng                               float64
+	located                                bool
+}
+
+// dayFuelPrices returns the prices of fuel on date, leaving out the ones
+// quarantined as anomalies.
+func dayFuelPrices(ctx context.Context, tx *sql.Tx, date string, fuel Fuel) ([]watchedPrice, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.ideess, p.price, s.rotulo, s.municipio, s.idmunicipio, s.latitud, s.longitud
+		FROM prices p
+		JOIN station_days d ON d.date = p.date AND d.ideess = p.ideess
+		JOIN stations s ON s.id = d.station_id
+		WHERE p.date = ? AND p.fuel = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM quarantine q
+			WHERE q.date = p.date AND q.ideess = p.ideess AND q.fuel IN (p.fuel, '') AND NOT q.dismissed
+		)
+	`, date, string(fuel))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s prices: %w", fuel, err)
+	}
+	defer rows.Close()
+
+	var prices []watchedPrice
+	for rows.Next() {
+		var p watchedPrice
+		var lat, lng string
+		if err := rows.Scan(&p.ideess, &p.price, &p.rotulo, &p.municipio, &p.idMunicipio, &lat, &lng); err != nil {
+			return nil, fmt.Errorf("error scanning %s price: %w", fuel, err)
+		}
+		var latErr, lngErr error
+		p.lat, latErr = ParseLatLong(lat)
+		p.lng, lngErr = ParseLatLong(lng)
+		p.located = latErr == nil && lngErr == nil
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// lastAlerts returns the date of the last alert of a rule before date for
+// each station.
+func lastAlerts(ctx context.Context, tx *sql.Tx, ruleID int64, date string) (map[string]time.Time, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ideess, MAX(date) FROM alerts WHERE rule_id = ? AND date < ? GROUP BY ideess
+	`, ruleID, date)
+	if err != nil {
+		return nil, fmt.Errorf("error querying last alerts: %w", err)
+	}
+	defer rows.Close()
+
+	last := make(map[string]time.Time)
+	for rows.Next() {
+		var ideess, lastDate string
+		if err := rows.Scan(&ideess, &lastDate); err != nil {
+			return nil, fmt.Errorf("error scanning last alert: %w", err)
+		}
+		last[ideess], _ = time.Parse("2006-01-02", lastDate)
+	}
+	return last, rows.Err()
+}
+
+// evaluateWatchRules raises the alerts of every watch rule on the prices of
+// date and returns the new ones. A rule alerts once per station and day, and
+// not again for the same station until its cooldown has passed.
+func (s *Storage) evaluateWatchRules(ctx context.Context, tx *sql.Tx, date string) ([]Alert, error) {
+	rules, err := queryWatchRules(ctx, tx)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q: %w", date, err)
+	}
+
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO alerts (rule_id, date, ideess, rotulo, fuel, price, condition, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing alert insert: %w", err)
+	}
+	defer insert.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	fuelPrices := make(map[Fuel][]watchedPrice)
+	var alerts []Alert
+	for _, rule := range rules {
+		prices, ok := fuelPrices[rule.Fuel]
+		if !ok {
+			if prices, err = dayFuelPrices(ctx, tx, date, rule.Fuel); err != nil {
+				return nil, err
+			}
+			fuelPrices[rule.Fuel] = prices
+		}
+		last, err := lastAlerts(ctx, tx, rule.ID, date)
+		if err != nil {
+			return nil, err
+		}
+
+		townMin := make(map[string]float64)
+		townStations := make(map[string]int)
+		if rule.Condition == WatchCheapestInTown {
+			for _, p := range prices {
+				if m, ok := townMin[p.idMunicipio]; !ok || p.price < m {
+					townMin[p.idMunicipio] = p.price
+				}
+				townStations[p.idMunicipio]++
+			}
+		}
+		watched := make(map[string]bool, len(rule.Stations))
+		for _, id := range rule.Stations {
+			watched[id] = true
+		}
+
+		for _, p := range prices {
+			if len(watched) > 0 {
+				if !watched[p.ideess] {
+					continue
+				}
+			} else if !p.located || gpx.Distance2D(rule.Latitude, rule.Longitude, p.lat, p.lng, true) > rule.Radius {
+				continue
+			}
+
+			var detail string
+			switch rule.Condition {
+			case WatchBelow:
+				if p.price >= rule.Threshold {
+					continue
+				}
+				detail = fmt.Sprintf("%.3f is below %.3f", p.price, rule.Threshold)
+			case WatchCheapestInTown:
+				if p.price > townMin[p.idMunicipio] {
+					continue
+				}
+				detail = fmt.Sprintf("%.3f is the cheapest of %d stations in %s", p.price, townStations[p.idMunicipio], p.municipio)
+			default:
+				continue
+			}
+			if prev, ok := last[p.ideess]; ok && prev.Add(rule.Cooldown).After(day) {
+				continue
+			}
+
+			result, err := insert.ExecContext(ctx, rule.ID, date, p.ideess, p.rotulo, string(rule.Fuel), p.price, string(rule.Condition), detail, now.Format(sqliteTimeLayout))
+			if err != nil {
+				return nil, fmt.Errorf("error saving alert of rule %d: %w", rule.ID, err)
+			}
+			if n, err := result.RowsAffected(); err != nil || n == 0 {
+				// Already raised by an earlier save of the day
+				continue
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return nil, fmt.Errorf("error saving alert of rule %d: %w", rule.ID, err)
+			}
+			alerts = append(alerts, Alert{
+				ID: id, RuleID: rule.ID, Date: date, IDEESS: p.ideess, Rotulo: p.rotulo, Fuel: rule.Fuel,
+				Price: p.price, Condition: rule.Condition, Detail: detail, CreatedAt: now,
+			})
+		}
+	}
+	return alerts, nil
+}
+
+// isLatestDay reports whether no day after date is stored. Watch rules are
+// only evaluated on live prices of the latest day, see SavePrices.
+func isLatestDay(ctx context.Context, tx *sql.Tx, date string) (bool, error) {
+	var later int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM station_days WHERE date > ?", date).Scan(&later); err != nil {
+		return false, fmt.Errorf("error querying later days: %w", err)
+	}
+	return later == 0, nil
+}
+
+// EvaluateWatchRules evaluates every watch rule on the prices of date and
+// returns the alerts raised. SavePrices does this for the latest day.
+func (s *Storage) EvaluateWatchRules(ctx context.Context, date time.Time) ([]Alert, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	alerts, err := s.evaluateWatchRules(ctx, tx, formatDate(date))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return alerts, nil
+}
+
+// Alerts returns the alerts matching q, newest first.
+func (s *Storage) Alerts(ctx context.Context, q AlertQuery) ([]Alert, error) {
+	fromStr, toStr := minDateBound, maxDateBound
+	if !q.From.IsZero() {
+		fromStr = formatDate(q.From)
+	}
+	if !q.To.IsZero() {
+		toStr = formatDate(q.To)
+	}
+
+	query := `
+		SELECT id, rule_id, date, ideess, rotulo, fuel, price, condition, detail, created_at
+		FROM alerts
+		WHERE date BETWEEN ? AND ?`
+	args := []any{fromStr, toStr}
+	if q.RuleID != 0 {
+		query += " AND rule_id = ?"
+		args = append(args, q.RuleID)
+	}
+	query += " ORDER BY date DESC, id DESC"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		var a Alert
+		var fuel, condition, createdAt string
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.Date, &a.IDEESS, &a.Rotulo, &fuel, &a.Price, &condition, &a.Detail, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning alert: %w", err)
+		}
+		a.Fuel, a.Condition = Fuel(fuel), WatchCondition(condition)
+		a.CreatedAt = parseSQLiteTime(createdAt)
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating alerts: %w", err)
+	}
+	return alerts, nil
+}
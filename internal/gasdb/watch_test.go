@@ -0,0 +1,148 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+func TestWatchRules(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	save := func(date time.Time, stations ...api.GasStation) {
+		t.Helper()
+		data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SavePrices(ctx, date, data); err != nil {
+			t.Fatalf("SavePrices() failed: %v", err)
+		}
+	}
+
+	if _, err := s.AddWatchRule(ctx, WatchRule{Fuel: "diesel", Condition: WatchBelow, Stations: []string{"1"}}); err == nil {
+		t.Error("expected an error for a below rule without threshold")
+	}
+	if _, err := s.AddWatchRule(ctx, WatchRule{Fuel: "diesel", Condition: WatchBelow, Threshold: 1.4}); err == nil {
+		t.Error("expected an error for a rule without stations or radius")
+	}
+
+	below, err := s.AddWatchRule(ctx, WatchRule{
+		Name: "saved", Fuel: "diesel", Condition: WatchBelow, Threshold: 1.40,
+		Stations: []string{"1", "2"}, Cooldown: 48 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("AddWatchRule() failed: %v", err)
+	}
+	cheapest, err := s.AddWatchRule(ctx, WatchRule{
+		Name: "home", Fuel: FuelGasoleoA, Condition: WatchCheapestInTown,
+		Latitude: 40.4168, Longitude: -3.7038, Radius: 10000,
+	})
+	if err != nil {
+		t.Fatalf("AddWatchRule() failed: %v", err)
+	}
+	rules, err := s.WatchRules(ctx)
+	if err != nil || len(rules) != 2 || rules[0].Fuel != FuelGasoleoA || rules[1].Cooldown != defaultWatchCooldown {
+		t.Fatalf("unexpected rules: %+v, %v", rules, err)
+	}
+
+	far := testStation("3", "BP", "1,299", "")
+	far.Latitud, far.Longitud, far.Municipio, far.IDMunicipio = "41,385100", "2,173400", "Barcelona", "0193"
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	save(day1, testStation("1", "REPSOL", "1,399", ""), testStation("2", "CEPSA", "1,459", ""), far)
+
+	alerts, err := s.Alerts(ctx, AlertQuery{})
+	if err != nil {
+		t.Fatalf("Alerts() failed: %v", err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", alerts)
+	}
+	for _, a := range alerts {
+		if a.IDEESS != "1" || a.Price != 1.399 || a.Date != "2024-01-01" {
+			t.Errorf("unexpected alert: %+v", a)
+		}
+	}
+
+	// Saving the day again does not raise the alerts twice, and the
+	// cooldown holds them back the next day
+	save(day1, testStation("1", "REPSOL", "1,399", ""), testStation("2", "CEPSA", "1,459", ""), far)
+	day2 := day1.AddDate(0, 0, 1)
+	save(day2, testStation("1", "REPSOL", "1,389", ""), testStation("2", "CEPSA", "1,379", ""), far)
+	alerts, err = s.Alerts(ctx, AlertQuery{RuleID: below})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].IDEESS != "2" || alerts[0].Date != "2024-01-02" {
+		t.Errorf("unexpected alerts of the below rule: %+v", alerts)
+	}
+	alerts, err = s.Alerts(ctx, AlertQuery{RuleID: cheapest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].IDEESS != "2" || alerts[1].IDEESS != "1" {
+		t.Errorf("unexpected alerts of the cheapest rule: %+v", alerts)
+	}
+
+	// Backfilled days are not evaluated
+	save(day1.AddDate(0, 0, -1), testStation("2", "CEPSA", "1,199", ""))
+	alerts, err = s.Alerts(ctx, AlertQuery{To: day1.AddDate(0, 0, -1)})
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("alerts raised for a backfilled day: %+v, %v", alerts, err)
+	}
+
+	if err := s.DeleteWatchRule(ctx, below); err != nil {
+		t.Fatalf("DeleteWatchRule() failed: %v", err)
+	}
+	if err := s.DeleteWatchRule(ctx, below); !errors.Is(err, ErrWatchRuleNotFound) {
+		t.Errorf("expected ErrWatchRuleNotFound, got %v", err)
+	}
+	alerts, err = s.Alerts(ctx, AlertQuery{RuleID: below})
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("alerts of a deleted rule kept: %+v, %v", alerts, err)
+	}
+}
+
+func TestBackfillRaisesNoAlerts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	if _, err := s.AddWatchRule(ctx, WatchRule{Fuel: FuelGasoleoA, Condition: WatchBelow, Threshold: 1.5, Stations: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddWebhook(ctx, Webhook{URL: "http://127.0.0.1:1/hook"}); err != nil {
+		t.Fatal(err)
+	}
+	day1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{testStation("1", "REPSOL", "1,599", "")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, day1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fetcher serves diesel at 1,459, below the threshold, and the last
+	// day of the gap is the latest day stored
+	opts := BackfillOptions{
+		From: day1.AddDate(0, 0, 1), To: day1.AddDate(0, 0, 3), Delay: time.Millisecond,
+		Fetcher: &fakeFetcher{calls: make(map[string]int)},
+	}
+	if report, err := s.Backfill(ctx, opts); err != nil || len(report.Fetched) != 3 {
+		t.Fatalf("Backfill() = %+v, %v", report, err)
+	}
+
+	alerts, err := s.Alerts(ctx, AlertQuery{})
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("alerts raised for backfilled days: %+v, %v", alerts, err)
+	}
+	deliveries, err := s.WebhookDeliveries(ctx, DeliveryQuery{})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Event != EventSnapshot {
+		t.Errorf("unexpected deliveries after backfilling: %+v, %v", deliveries, err)
+	}
+}
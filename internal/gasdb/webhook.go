@@ -0,0 +1,551 @@
+package gasdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookEvent is the kind of event pushed to webhooks.
+type WebhookEvent string
+
+const (
+	// EventSnapshot is the first save of the snapshot of a new latest day.
+	EventSnapshot WebhookEvent = "snapshot"
+	// EventAnomaly is an anomaly quarantined in the latest day.
+	EventAnomaly WebhookEvent = "anomaly"
+	// EventAlert is an alert raised by a watch rule.
+	EventAlert WebhookEvent = "alert"
+	// EventPing is the event sent by TestWebhook.
+	EventPing WebhookEvent = "ping"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to.
+var WebhookEvents = []WebhookEvent{EventSnapshot, EventAnomaly, EventAlert}
+
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Minute
+	defaultWebhookTimeout  = 10 * time.Second
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ParseWebhookEvent validates an event name.
+func ParseWebhookEvent(name string) (WebhookEvent, error) {
+	e := WebhookEvent(strings.ToLower(name))
+	if !slices.Contains(WebhookEvents, e) {
+		return "", fmt.Errorf("unknown webhook event %q", name)
+	}
+	return e, nil
+}
+
+// WithWebhookClient sets the HTTP client webhooks are delivered with.
+func WithWebhookClient(client *http.Client) Option {
+	return func(o *options) { o.webhookClient = client }
+}
+
+// WithWebhookRetries sets how many times a delivery is attempted and the
+// wait before the first retry, doubled after every attempt. Retries are sent
+// by the first DeliverWebhooks call after the wait.
+func WithWebhookRetries(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.webhookAttempts = attempts
+		o.webhookBackoff = backoff
+	}
+}
+
+// Webhook is an endpoint events are pushed to.
+type Webhook struct {
+	ID  int64
+	URL string
+	// Secret is the HMAC-SHA256 key of the X-Gasdb-Signature header.
+	Secret string
+	// Events filters the events delivered, every event when empty.
+	Events    []WebhookEvent
+	CreatedAt time.Time
+}
+
+// subscribed reports whether the webhook receives event.
+func (w *Webhook) subscribed(event WebhookEvent) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// WebhookPayload is the JSON body posted to webhooks.
+type WebhookPayload struct {
+	Event     WebhookEvent `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	Data      any          `json:"data"`
+}
+
+// SnapshotEvent is the data of EventSnapshot.
+type SnapshotEvent struct {
+	Date     string `json:"date"`
+	Stations int    `json:"stations"`
+	Prices   int    `json:"prices"`
+}
+
+// WebhookDelivery is an entry of the delivery log.
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	Event     WebhookEvent
+	Payload   []byte
+	Status    string
+	Attempts  int
+	// ResponseCode is the HTTP status of the last attempt, zero when no
+	// response was received. Error describes why it failed.
+	ResponseCode int
+	Error        string
+	CreatedAt    time.Time
+	DeliveredAt  time.Time
+	// NextAttemptAt is when a pending delivery that failed is due again.
+	NextAttemptAt time.Time
+}
+
+// SignWebhookPayload returns the X-Gasdb-Signature header of a payload:
+// "sha256=" followed by the hex HMAC-SHA256 of the body with the secret.
+// Receivers compute it again to authenticate deliveries.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// createWebhookTables creates webhooks and the webhook_deliveries log.
+func (s *Storage) createWebhookTables(ctx context.Context) error {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TEXT NOT NULL DEFAULT '',
+		next_attempt_at TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries (status, id);
+	`
+
+	if _, err := s.db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating webhook tables: %w", err)
+	}
+	return s.migrateWebhookDeliveries(ctx)
+}
+
+// migrateWebhookDeliveries adds the next_attempt_at column to
+// webhook_deliveries tables created when retries were sent in place.
+func (s *Storage) migrateWebhookDeliveries(ctx context.Context) error {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('webhook_deliveries') WHERE name = 'next_attempt_at'").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error inspecting webhook_deliveries: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, "ALTER TABLE webhook_deliveries ADD COLUMN next_attempt_at TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error adding next_attempt_at column: %w", err)
+	}
+	return nil
+}
+
+// AddWebhook registers an endpoint and returns it with its id. A random
+// secret is generated when hook.Secret is empty.
+func (s *Storage) AddWebhook(ctx context.Context, hook Webhook) (*Webhook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", hook.URL)
+	}
+	events := make([]string, len(hook.Events))
+	for i, e := range hook.Events {
+		if _, err := ParseWebhookEvent(string(e)); err != nil {
+			return nil, err
+		}
+		events[i] = string(e)
+	}
+	if hook.Secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("error generating webhook secret: %w", err)
+		}
+		hook.Secret = hex.EncodeToString(key)
+	}
+
+	result, err := s.db.ExecContext(ctx, "INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)",
+		hook.URL, hook.Secret, strings.Join(events, ","))
+	if err != nil {
+		return nil, fmt.Errorf("error saving webhook: %w", err)
+	}
+	if hook.ID, err = result.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("error saving webhook: %w", err)
+	}
+	return &hook, nil
+}
+
+// Webhooks returns every registered webhook by id.
+func (s *Storage) Webhooks(ctx context.Context) ([]Webhook, error) {
+	return queryWebhooks(ctx, s.db, "")
+}
+
+// queryWebhooks returns the webhooks matching the where clause, by id.
+func queryWebhooks(ctx context.Context, q queryer, where string, args ...any) ([]Webhook, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, url, secret, events, created_at FROM webhooks "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		var events, createdAt string
+		if err := rows.Scan(&h.ID, &h.URL, &h.Secret, &events, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %w", err)
+		}
+		if events != "" {
+			for _, e := range strings.Split(events, ",") {
+				h.Events = append(h.Events, WebhookEvent(e))
+			}
+		}
+		h.CreatedAt = parseSQLiteTime(createdAt)
+		hooks = append(hooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log.Error("rollback error", "error", err)
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting webhook deliveries: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// webhookMessage is an event waiting to be queued for delivery.
+type webhookMessage struct {
+	event WebhookEvent
+	data  any
+}
+
+// enqueueWebhooks queues a delivery of every message to each webhook
+// subscribed to its event, within tx, and returns the number queued.
+func enqueueWebhooks(ctx context.Context, tx *sql.Tx, messages []webhookMessage) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	hooks, err := queryWebhooks(ctx, tx, "")
+	if err != nil || len(hooks) == 0 {
+		return 0, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	queued := 0
+	for _, m := range messages {
+		payload, err := json.Marshal(WebhookPayload{Event: m.event, CreatedAt: now, Data: m.data})
+		if err != nil {
+			return 0, fmt.Errorf("error encoding %s webhook payload: %w", m.event, err)
+		}
+		for _, h := range hooks {
+			if !h.subscribed(m.event) {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES (?, ?, ?)",
+				h.ID, string(m.event), payload)
+			if err != nil {
+				return 0, fmt.Errorf("error queueing webhook delivery: %w", err)
+			}
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// post sends a delivery once, returning the response status.
+func (s *Storage) post(ctx context.Context, hook *Webhook, d *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gasdb-webhook")
+	req.Header.Set("X-Gasdb-Event", string(d.Event))
+	req.Header.Set("X-Gasdb-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Gasdb-Signature", SignWebhookPayload(hook.Secret, d.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver attempts a delivery once and records the outcome. A failure is
+// due again after the backoff, doubled after every attempt, and the delivery
+// is marked as failed once it runs out of attempts.
+func (s *Storage) deliver(ctx context.Context, hook *Webhook, d *WebhookDelivery) error {
+	code, err := s.post(ctx, hook, d)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	d.Attempts++
+	d.ResponseCode = code
+	now := time.Now().UTC()
+	if err != nil {
+		d.Error = err.Error()
+		if d.Attempts >= s.webhookAttempts {
+			d.Status, d.NextAttemptAt = DeliveryFailed, time.Time{}
+		} else {
+			d.NextAttemptAt = now.Add(s.webhookBackoff << (d.Attempts - 1))
+		}
+		s.log.Warn("Webhook delivery failed", "webhook", hook.ID, "delivery", d.ID, "attempt", d.Attempts, "error", err)
+	} else {
+		d.Status, d.Error, d.DeliveredAt, d.NextAttemptAt = DeliveryDelivered, "", now, time.Time{}
+	}
+
+	var deliveredAt, nextAttemptAt string
+	if !d.DeliveredAt.IsZero() {
+		deliveredAt = d.DeliveredAt.Format(time.RFC3339Nano)
+	}
+	if !d.NextAttemptAt.IsZero() {
+		nextAttemptAt = d.NextAttemptAt.Format(time.RFC3339Nano)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_code = ?, error = ?, delivered_at = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, d.Status, d.Attempts, d.ResponseCode, d.Error, deliveredAt, nextAttemptAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("error saving webhook delivery %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+// DeliverWebhooks attempts every pending delivery that is due once, and
+// returns how many were delivered. The deliveries of a webhook go out in
+// order: one that failed, or is waiting for its retry, holds back the later
+// ones of its webhook until a later call. Deliveries still failing after the
+// last attempt are marked as failed in the log. SavePrices only queues
+// events, callers drain the queue with it out of band and periodically.
+func (s *Storage) DeliverWebhooks(ctx context.Context) (int, error) {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	pending, err := s.WebhookDeliveries(ctx, DeliveryQuery{Status: DeliveryPending})
+	if err != nil {
+		return 0, err
+	}
+	hooks, err := s.Webhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byID := make(map[int64]*Webhook, len(hooks))
+	for i := range hooks {
+		byID[hooks[i].ID] = &hooks[i]
+	}
+
+	now := time.Now().UTC()
+	held := make(map[int64]bool)
+	delivered := 0
+	for i := range pending {
+		d := &pending[i]
+		hook, ok := byID[d.WebhookID]
+		if !ok || held[d.WebhookID] {
+			continue
+		}
+		if d.NextAttemptAt.After(now) {
+			held[d.WebhookID] = true
+			continue
+		}
+		if err := s.deliver(ctx, hook, d); err != nil {
+			return delivered, err
+		}
+		switch d.Status {
+		case DeliveryDelivered:
+			delivered++
+		case DeliveryPending:
+			held[d.WebhookID] = true
+		}
+	}
+	return delivered, nil
+}
+
+// RetryFailedWebhooks puts the failed deliveries back in the queue with
+// their attempts reset, due right away, so the next DeliverWebhooks sends
+// them again. It returns how many were queued.
+func (s *Storage) RetryFailedWebhooks(ctx context.Context) (int, error) {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = ?, attempts = 0, response_code = 0, error = '', next_attempt_at = ''
+		WHERE status = ?
+	`, DeliveryPending, DeliveryFailed)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing failed webhook deliveries: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error queueing failed webhook deliveries: %w", err)
+	}
+	return int(n), nil
+}
+
+// TestWebhook queues a ping event for a webhook and attempts it right away,
+// returning the delivery with its outcome. A ping that failed is retried by
+// DeliverWebhooks like any other delivery.
+func (s *Storage) TestWebhook(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	hooks, err := queryWebhooks(ctx, s.db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event: EventPing, CreatedAt: time.Now().UTC().Truncate(time.Second), Data: map[string]int64{"webhook_id": id},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding ping payload: %w", err)
+	}
+	result, err := s.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES (?, ?, ?)",
+		id, string(EventPing), payload)
+	if err != nil {
+		return nil, fmt.Errorf("error queueing webhook delivery: %w", err)
+	}
+	d := &WebhookDelivery{WebhookID: id, Event: EventPing, Payload: payload, Status: DeliveryPending}
+	if d.ID, err = result.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("error queueing webhook delivery: %w", err)
+	}
+
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	if err := s.deliver(ctx, &hooks[0], d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DeliveryQuery filters WebhookDeliveries. Zero fields match everything.
+type DeliveryQuery struct {
+	WebhookID int64
+	Status    string
+	Limit     int
+}
+
+// WebhookDeliveries returns the delivery log entries matching q, oldest
+// first.
+func (s *Storage) WebhookDeliveries(ctx context.Context, q DeliveryQuery) ([]WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, payload, status, attempts, response_code, error, created_at, delivered_at,
+			next_attempt_at
+		FROM webhook_deliveries
+		WHERE 1 = 1`
+	var args []any
+	if q.WebhookID != 0 {
+		query += " AND webhook_id = ?"
+		args = append(args, q.WebhookID)
+	}
+	if q.Status != "" {
+		query += " AND status = ?"
+		args = append(args, q.Status)
+	}
+	query += " ORDER BY id"
+	if q.Limit > 0 {
+		query = "SELECT * FROM (" + query + " DESC LIMIT ?) ORDER BY id"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var event, createdAt, deliveredAt, nextAttemptAt string
+		if err := rows.Scan(&d.ID, &d.WebhookID, &event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseCode, &d.Error, &createdAt, &deliveredAt, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		d.Event = WebhookEvent(event)
+		d.CreatedAt = parseSQLiteTime(createdAt)
+		d.DeliveredAt = parseSQLiteTime(deliveredAt)
+		d.NextAttemptAt = parseSQLiteTime(nextAttemptAt)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
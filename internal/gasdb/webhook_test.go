@@ -0,0 +1,270 @@
+package gasdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/gasdb/pkg/api"
+)
+
+// webhookReceiver records the payloads posted with a valid signature and
+// fails the first failures requests.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	requests int
+	payloads []WebhookPayload
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests++
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Gasdb-Signature") != SignWebhookPayload(rc.secret, body) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	var p WebhookPayload
+	if err := json.Unmarshal(body, &p); err != nil || string(p.Event) != r.Header.Get("X-Gasdb-Event") {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+		return
+	}
+	rc.payloads = append(rc.payloads, p)
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler),
+		WithWebhookRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	all := &webhookReceiver{secret: "s3cret", failures: 2}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	alerts := &webhookReceiver{}
+	alertsServer := httptest.NewServer(alerts)
+	defer alertsServer.Close()
+	var up atomic.Bool
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+		}
+	}))
+	defer down.Close()
+
+	if _, err := s.AddWebhook(ctx, Webhook{URL: "ftp://example.com"}); err == nil {
+		t.Error("expected an error for a non HTTP URL")
+	}
+	if _, err := s.AddWebhook(ctx, Webhook{URL: allServer.URL, Events: []WebhookEvent{"price"}}); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+	allHook, err := s.AddWebhook(ctx, Webhook{URL: allServer.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatalf("AddWebhook() failed: %v", err)
+	}
+	alertsHook, err := s.AddWebhook(ctx, Webhook{URL: alertsServer.URL, Events: []WebhookEvent{EventAlert}})
+	if err != nil {
+		t.Fatalf("AddWebhook() failed: %v", err)
+	}
+	if len(alertsHook.Secret) != 64 {
+		t.Errorf("expected a generated secret, got %q", alertsHook.Secret)
+	}
+	alerts.secret = alertsHook.Secret
+	downHook, err := s.AddWebhook(ctx, Webhook{URL: down.URL, Events: []WebhookEvent{EventSnapshot}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddWatchRule(ctx, WatchRule{Fuel: FuelGasoleoA, Condition: WatchBelow, Threshold: 1.4, Stations: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(api.GasStationList{ListaEESSPrecio: []api.GasStation{
+		testStation("1", "REPSOL", "1,399", ""),
+		testStation("2", "CEPSA", "0,001", ""),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePrices(ctx, day, data); err != nil {
+		t.Fatalf("SavePrices() failed: %v", err)
+	}
+
+	// Saving only queues the deliveries
+	pending, err := s.WebhookDeliveries(ctx, DeliveryQuery{Status: DeliveryPending})
+	if err != nil || len(pending) != 5 || all.requests != 0 {
+		t.Fatalf("unexpected pending deliveries: %+v, %v", pending, err)
+	}
+
+	// Each drain attempts a due delivery once, and a failure holds back the
+	// later deliveries of its webhook until its retry is due
+	delivered, err := s.DeliverWebhooks(ctx)
+	if err != nil || delivered != 1 || all.requests != 1 {
+		t.Fatalf("DeliverWebhooks() = %d, %v after %d requests", delivered, err, all.requests)
+	}
+	for range 2 {
+		time.Sleep(10 * time.Millisecond)
+		n, err := s.DeliverWebhooks(ctx)
+		if err != nil {
+			t.Fatalf("DeliverWebhooks() failed: %v", err)
+		}
+		delivered += n
+	}
+	if delivered != 4 {
+		t.Fatalf("expected 4 deliveries after the retries, got %d", delivered)
+	}
+
+	events := func(rc *webhookReceiver) []WebhookEvent {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		var got []WebhookEvent
+		for _, p := range rc.payloads {
+			got = append(got, p.Event)
+		}
+		return got
+	}
+	if got := events(all); len(got) != 3 || got[0] != EventSnapshot || got[1] != EventAnomaly || got[2] != EventAlert {
+		t.Errorf("unexpected events delivered to the catch-all webhook: %v", got)
+	}
+	if got := events(alerts); len(got) != 1 || got[0] != EventAlert {
+		t.Errorf("unexpected events delivered to the alerts webhook: %v", got)
+	}
+	var alert Alert
+	if data, err := json.Marshal(alerts.payloads[0].Data); err != nil || json.Unmarshal(data, &alert) != nil || alert.IDEESS != "1" || alert.Price != 1.399 {
+		t.Errorf("unexpected alert payload: %+v", alerts.payloads[0].Data)
+	}
+
+	// Two failures were retried, the endpoint that is down ran out of attempts
+	deliveries, err := s.WebhookDeliveries(ctx, DeliveryQuery{WebhookID: allHook.ID})
+	if err != nil || len(deliveries) != 3 {
+		t.Fatalf("unexpected deliveries: %+v, %v", deliveries, err)
+	}
+	if d := deliveries[0]; d.Status != DeliveryDelivered || d.Attempts != 3 || d.ResponseCode != http.StatusOK || d.DeliveredAt.IsZero() {
+		t.Errorf("unexpected retried delivery: %+v", d)
+	}
+	failed, err := s.WebhookDeliveries(ctx, DeliveryQuery{Status: DeliveryFailed})
+	if err != nil || len(failed) != 1 || failed[0].WebhookID != downHook.ID || failed[0].Attempts != 3 || failed[0].ResponseCode != http.StatusInternalServerError {
+		t.Errorf("unexpected failed deliveries: %+v, %v", failed, err)
+	}
+
+	// Saving the day again neither announces the snapshot nor repeats the
+	// anomalies and alerts
+	if err := s.SavePrices(ctx, day, data); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, err := s.DeliverWebhooks(ctx); err != nil || delivered != 0 {
+		t.Errorf("DeliverWebhooks() = %d, %v", delivered, err)
+	}
+	if got := events(all); len(got) != 3 {
+		t.Errorf("events delivered again: %v", got)
+	}
+
+	// Failed deliveries are sent again once queued back
+	up.Store(true)
+	if retried, err := s.RetryFailedWebhooks(ctx); err != nil || retried != 1 {
+		t.Fatalf("RetryFailedWebhooks() = %d, %v", retried, err)
+	}
+	if delivered, err := s.DeliverWebhooks(ctx); err != nil || delivered != 1 {
+		t.Errorf("DeliverWebhooks() = %d, %v", delivered, err)
+	}
+	deliveries, err = s.WebhookDeliveries(ctx, DeliveryQuery{WebhookID: downHook.ID})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered || deliveries[0].Attempts != 1 {
+		t.Errorf("unexpected retried delivery: %+v, %v", deliveries, err)
+	}
+
+	d, err := s.TestWebhook(ctx, alertsHook.ID)
+	if err != nil || d.Status != DeliveryDelivered || d.Event != EventPing {
+		t.Errorf("TestWebhook() = %+v, %v", d, err)
+	}
+	if _, err := s.TestWebhook(ctx, 404); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound, got %v", err)
+	}
+
+	if err := s.DeleteWebhook(ctx, downHook.ID); err != nil {
+		t.Fatalf("DeleteWebhook() failed: %v", err)
+	}
+	deliveries, err = s.WebhookDeliveries(ctx, DeliveryQuery{WebhookID: downHook.ID})
+	if err != nil || len(deliveries) != 0 {
+		t.Errorf("deliveries of a deleted webhook kept: %+v, %v", deliveries, err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "test.db"), slog.New(slog.DiscardHandler),
+		WithWebhookRetries(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var requests atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	hook, err := s.AddWebhook(ctx, Webhook{URL: down.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A ping that failed stays queued for its retry
+	start := time.Now()
+	d, err := s.TestWebhook(ctx, hook.ID)
+	if err != nil || d.Status != DeliveryPending || d.Attempts != 1 {
+		t.Fatalf("TestWebhook() = %+v, %v", d, err)
+	}
+	if d.NextAttemptAt.Before(start.Add(time.Hour)) || d.NextAttemptAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("unexpected next attempt: %v", d.NextAttemptAt)
+	}
+
+	// Deliveries not due yet are skipped without waiting
+	if delivered, err := s.DeliverWebhooks(ctx); err != nil || delivered != 0 || requests.Load() != 1 {
+		t.Fatalf("DeliverWebhooks() = %d, %v after %d requests", delivered, err, requests.Load())
+	}
+	if time.Since(start) > time.Minute {
+		t.Errorf("DeliverWebhooks() waited for the backoff")
+	}
+
+	// Once due, the last attempt marks the delivery as failed
+	if _, err := s.db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?", start.Add(-time.Minute).UTC().Format(time.RFC3339Nano)); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, err := s.DeliverWebhooks(ctx); err != nil || delivered != 0 || requests.Load() != 2 {
+		t.Fatalf("DeliverWebhooks() = %d, %v after %d requests", delivered, err, requests.Load())
+	}
+	deliveries, err := s.WebhookDeliveries(ctx, DeliveryQuery{WebhookID: hook.ID})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != DeliveryFailed || deliveries[0].Attempts != 2 ||
+		deliveries[0].ResponseCode != http.StatusBadGateway || !deliveries[0].NextAttemptAt.IsZero() {
+		t.Errorf("unexpected failed delivery: %+v, %v", deliveries, err)
+	}
+
+	// Queued again, it is due right away
+	if retried, err := s.RetryFailedWebhooks(ctx); err != nil || retried != 1 {
+		t.Fatalf("RetryFailedWebhooks() = %d, %v", retried, err)
+	}
+	if _, err := s.DeliverWebhooks(ctx); err != nil || requests.Load() != 3 {
+		t.Errorf("DeliverWebhooks() failed: %v after %d requests", err, requests.Load())
+	}
+}